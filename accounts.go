@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/jrnt30/aws-utils/sqsmigrate"
+)
+
+// accountOf returns the account ID in a queue URL or ARN, or "" for a queue name, which
+// belongs to the account of the credentials looking it up.
+func accountOf(queue string) string {
+	if arn.IsARN(queue) {
+		if queueARN, err := arn.Parse(queue); err == nil {
+			return queueARN.AccountID
+		}
+		return ""
+	}
+	if sqsmigrate.IsQueueURL(queue) {
+		if u, err := url.Parse(queue); err == nil {
+			if parts := strings.Split(strings.Trim(u.Path, "/"), "/"); len(parts) == 2 {
+				return parts[0]
+			}
+		}
+	}
+	return ""
+}
+
+// accountIdentity is the account a set of credentials belongs to, and its IAM alias if it
+// has one.
+type accountIdentity struct {
+	id    string
+	alias string
+}
+
+// accountAliases looks up the accounts of the source and dest credentials with
+// -show-account-alias, so logs and confirmations name them by alias rather than by ID
+// alone.  Each set of credentials is only looked up once.  A nil *accountAliases looks
+// up nothing.
+type accountAliases struct {
+	mu         sync.Mutex
+	identities map[string]accountIdentity
+	// aliases holds the alias of every account looked up, keyed by ID.
+	aliases map[string]string
+}
+
+func newAccountAliases(enabled bool) *accountAliases {
+	if !enabled {
+		return nil
+	}
+	return &accountAliases{identities: map[string]accountIdentity{}, aliases: map[string]string{}}
+}
+
+// lookup returns the account of cfg's credentials, named by key, with
+// sts.GetCallerIdentity and iam.ListAccountAliases.  An account without an alias, or
+// credentials not allowed to list it, has an empty one.
+func (a *accountAliases) lookup(ctx context.Context, key string, cfg aws.Config) (accountIdentity, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if identity, ok := a.identities[key]; ok {
+		return identity, nil
+	}
+	caller, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return accountIdentity{}, err
+	}
+	identity := accountIdentity{id: aws.ToString(caller.Account)}
+	if alias, ok := a.aliases[identity.id]; ok {
+		identity.alias = alias
+	} else if resp, err := iam.NewFromConfig(cfg).ListAccountAliases(ctx, &iam.ListAccountAliasesInput{}); err == nil && len(resp.AccountAliases) > 0 {
+		identity.alias = resp.AccountAliases[0]
+	}
+	a.aliases[identity.id] = identity.alias
+	a.identities[key] = identity
+	return identity, nil
+}
+
+// label describes an account as its alias and ID, or the ID alone when its alias isn't
+// known, such as for a queue shared from an account other than the credentials'.
+func (a *accountAliases) label(account string) string {
+	if a == nil || account == "" {
+		return account
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if alias := a.aliases[account]; alias != "" {
+		return alias + " (" + account + ")"
+	}
+	return account
+}
+
+// describeQueue names a queue along with the account it is in, given the account of the
+// credentials it is looked up with.
+func (a *accountAliases) describeQueue(queue, callerAccount string) string {
+	if a == nil {
+		return queue
+	}
+	account := accountOf(queue)
+	if account == "" {
+		account = callerAccount
+	}
+	if account == "" {
+		return queue
+	}
+	return queue + " in " + a.label(account)
+}
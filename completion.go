@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const commandName = "aws-utils"
+
+// writeCompletion emits a completion script for the given shell covering every flag
+// registered on fs, so the scripts stay in step with the flag set without maintenance.
+func writeCompletion(w io.Writer, shell string, fs *flag.FlagSet) error {
+	switch shell {
+	case "bash":
+		names := []string{"completion"}
+		fs.VisitAll(func(f *flag.Flag) {
+			names = append(names, "-"+f.Name)
+		})
+		fmt.Fprintf(w, "_aws_utils() {\n")
+		fmt.Fprintf(w, "    local cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+		fmt.Fprintf(w, "    if [ \"$COMP_CWORD\" -eq 2 ] && [ \"${COMP_WORDS[1]}\" = completion ]; then\n")
+		fmt.Fprintf(w, "        COMPREPLY=( $(compgen -W \"bash zsh fish\" -- \"$cur\") )\n")
+		fmt.Fprintf(w, "        return\n")
+		fmt.Fprintf(w, "    fi\n")
+		fmt.Fprintf(w, "    COMPREPLY=( $(compgen -W \"%s\" -- \"$cur\") )\n", strings.Join(names, " "))
+		fmt.Fprintf(w, "}\n")
+		fmt.Fprintf(w, "complete -o default -F _aws_utils %s\n", commandName)
+	case "zsh":
+		fmt.Fprintf(w, "#compdef %s\n\n", commandName)
+		fmt.Fprintf(w, "_arguments \\\n")
+		fs.VisitAll(func(f *flag.Flag) {
+			usage := strings.NewReplacer("[", "(", "]", ")", "'", "", ":", " ").Replace(f.Usage)
+			if isBoolFlag(f) {
+				fmt.Fprintf(w, "  '-%s[%s]' \\\n", f.Name, usage)
+			} else {
+				fmt.Fprintf(w, "  '-%s[%s]:%s:' \\\n", f.Name, usage, f.Name)
+			}
+		})
+		fmt.Fprintf(w, "  '1::command:(completion)'\n")
+	case "fish":
+		fmt.Fprintf(w, "complete -c %s -n '__fish_use_subcommand' -a completion -d 'Generate shell completion'\n", commandName)
+		fmt.Fprintf(w, "complete -c %s -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish'\n", commandName)
+		fs.VisitAll(func(f *flag.Flag) {
+			usage := strings.Replace(f.Usage, "'", "\\'", -1)
+			if isBoolFlag(f) {
+				fmt.Fprintf(w, "complete -c %s -o %s -d '%s'\n", commandName, f.Name, usage)
+			} else {
+				fmt.Fprintf(w, "complete -c %s -o %s -d '%s' -r\n", commandName, f.Name, usage)
+			}
+		})
+	default:
+		return fmt.Errorf("unsupported shell %q, expected bash, zsh or fish", shell)
+	}
+	return nil
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/jrnt30/aws-utils/sqsmigrate"
+)
+
+// copiedQueueAttributes are the source queue attributes an auto-created destination
+// inherits.  Redrive policies are deliberately left out since pointing the new queue at
+// the source's dead-letter queue is rarely what's wanted.
+var copiedQueueAttributes = []types.QueueAttributeName{
+	types.QueueAttributeNameDelaySeconds,
+	types.QueueAttributeNameMaximumMessageSize,
+	types.QueueAttributeNameMessageRetentionPeriod,
+	types.QueueAttributeNameReceiveMessageWaitTimeSeconds,
+	types.QueueAttributeNameVisibilityTimeout,
+	types.QueueAttributeNameFifoQueue,
+	types.QueueAttributeNameContentBasedDeduplication,
+}
+
+// destQueueSettings are explicit attributes for an auto-created destination, overriding
+// whatever is copied from the source.  Zero values are left alone.
+type destQueueSettings struct {
+	retention   time.Duration
+	visibility  time.Duration
+	dlqARN      string
+	maxReceives int
+	// fifo creates a FIFO queue whatever the source is, with contentDedup,
+	// dedupScope and throughputLimit setting its deduplication and throughput.
+	fifo            bool
+	contentDedup    bool
+	dedupScope      string
+	throughputLimit string
+}
+
+// attributes renders the settings as CreateQueue attributes.
+func (s destQueueSettings) attributes() (map[string]string, error) {
+	attrs := map[string]string{}
+	if s.retention > 0 {
+		attrs[string(types.QueueAttributeNameMessageRetentionPeriod)] = strconv.Itoa(int(s.retention / time.Second))
+	}
+	if s.visibility > 0 {
+		attrs[string(types.QueueAttributeNameVisibilityTimeout)] = strconv.Itoa(int(s.visibility / time.Second))
+	}
+	if s.dlqARN != "" {
+		if s.maxReceives < 1 {
+			return nil, fmt.Errorf("a dead-letter queue needs a -dest-max-receives of at least 1")
+		}
+		policy, err := json.Marshal(map[string]interface{}{
+			"deadLetterTargetArn": s.dlqARN,
+			"maxReceiveCount":     s.maxReceives,
+		})
+		if err != nil {
+			return nil, err
+		}
+		attrs[string(types.QueueAttributeNameRedrivePolicy)] = string(policy)
+	}
+	if s.fifo {
+		attrs[string(types.QueueAttributeNameFifoQueue)] = "true"
+	}
+	if s.contentDedup {
+		attrs[string(types.QueueAttributeNameContentBasedDeduplication)] = "true"
+	}
+	switch s.dedupScope {
+	case "", sqsmigrate.DedupScopeQueue, sqsmigrate.DedupScopeMessageGroup:
+	default:
+		return nil, fmt.Errorf("unknown -dest-dedup-scope %q, expected queue or messageGroup", s.dedupScope)
+	}
+	switch s.throughputLimit {
+	case "", sqsmigrate.ThroughputPerQueue, sqsmigrate.ThroughputPerGroupLimit:
+	default:
+		return nil, fmt.Errorf("unknown -dest-fifo-throughput %q, expected perQueue or perMessageGroupId", s.throughputLimit)
+	}
+	// High throughput FIFO needs both, SQS rejects a per group limit deduplicating
+	// across the whole queue.
+	if s.throughputLimit == sqsmigrate.ThroughputPerGroupLimit && s.dedupScope != sqsmigrate.DedupScopeMessageGroup {
+		return nil, fmt.Errorf("a -dest-fifo-throughput of perMessageGroupId needs a -dest-dedup-scope of messageGroup")
+	}
+	if s.dedupScope != "" {
+		attrs[string(types.QueueAttributeNameDeduplicationScope)] = s.dedupScope
+	}
+	if s.throughputLimit != "" {
+		attrs[string(types.QueueAttributeNameFifoThroughputLimit)] = s.throughputLimit
+	}
+	return attrs, nil
+}
+
+// isQueueMissing reports whether err is SQS saying the queue doesn't exist.
+func isQueueMissing(err error) bool {
+	var missing *types.QueueDoesNotExist
+	return errors.As(err, &missing)
+}
+
+// createDestQueue creates the destination queue with destSvc, copying the source queue's
+// settings and then applying any explicit ones.
+func createDestQueue(ctx context.Context, sourceSvc, destSvc *sqs.Client, name string, sourceQueueURL *string, settings destQueueSettings) (*string, error) {
+	source, err := sourceSvc.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       sourceQueueURL,
+		AttributeNames: copiedQueueAttributes,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := settings.attributes()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range copiedQueueAttributes {
+		value, copied := source.Attributes[string(name)]
+		if _, ok := attrs[string(name)]; !ok && copied {
+			attrs[string(name)] = value
+		}
+	}
+	// SQS rejects the FIFO attributes on standard queues, even when false.
+	if attrs[string(types.QueueAttributeNameFifoQueue)] != "true" {
+		delete(attrs, string(types.QueueAttributeNameFifoQueue))
+		delete(attrs, string(types.QueueAttributeNameContentBasedDeduplication))
+		delete(attrs, string(types.QueueAttributeNameDeduplicationScope))
+		delete(attrs, string(types.QueueAttributeNameFifoThroughputLimit))
+	}
+
+	resp, err := destSvc.CreateQueue(ctx, &sqs.CreateQueueInput{
+		QueueName:  aws.String(name),
+		Attributes: attrs,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.QueueUrl, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/endpointcreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// Providers -credential-source can pin the source credentials to, instead of the first
+// of the default chain that has any.
+const (
+	credentialSourceEnv         = "env"
+	credentialSourceShared      = "shared"
+	credentialSourceEC2         = "ec2-metadata"
+	credentialSourceECS         = "ecs"
+	credentialSourceWebIdentity = "web-identity"
+)
+
+// ecsCredentialsHost serves the credentials of an ECS task at its relative URI.
+const ecsCredentialsHost = "http://169.254.170.2"
+
+// credentialProvider builds the provider of a -credential-source other than shared,
+// which is chosen by loading the config with a profile instead.  cfg is the loaded
+// config, used for the STS client of web-identity.
+func credentialProvider(cfg aws.Config, source string) (aws.CredentialsProvider, error) {
+	switch source {
+	case credentialSourceEnv:
+		env, err := config.NewEnvConfig()
+		if err != nil {
+			return nil, err
+		}
+		if !env.Credentials.HasKeys() {
+			return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY need to be set for -credential-source env")
+		}
+		return credentials.NewStaticCredentialsProvider(env.Credentials.AccessKeyID, env.Credentials.SecretAccessKey, env.Credentials.SessionToken), nil
+	case credentialSourceEC2:
+		return ec2rolecreds.New(), nil
+	case credentialSourceECS:
+		endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+		if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+			endpoint = ecsCredentialsHost + relative
+		}
+		if endpoint == "" {
+			return nil, fmt.Errorf("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI or AWS_CONTAINER_CREDENTIALS_FULL_URI need to be set for -credential-source ecs")
+		}
+		token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+		if path := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); path != "" {
+			contents, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			token = strings.TrimSpace(string(contents))
+		}
+		return endpointcreds.New(endpoint, func(o *endpointcreds.Options) {
+			o.AuthorizationToken = token
+		}), nil
+	case credentialSourceWebIdentity:
+		tokenFile, roleARN := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"), os.Getenv("AWS_ROLE_ARN")
+		if tokenFile == "" || roleARN == "" {
+			return nil, fmt.Errorf("AWS_WEB_IDENTITY_TOKEN_FILE and AWS_ROLE_ARN need to be set for -credential-source web-identity")
+		}
+		return stscreds.NewWebIdentityRoleProvider(sts.NewFromConfig(cfg), roleARN, stscreds.IdentityTokenFile(tokenFile), func(o *stscreds.WebIdentityRoleOptions) {
+			o.RoleSessionName = os.Getenv("AWS_ROLE_SESSION_NAME")
+		}), nil
+	}
+	return nil, fmt.Errorf("unknown -credential-source %q, expected env, shared, ec2-metadata, ecs or web-identity", source)
+}
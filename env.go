@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix namespaces the environment variables that can stand in for flags.
+const envPrefix = "SQSMIGRATE_"
+
+// envName is the environment variable for a flag, so -max-age is SQSMIGRATE_MAX_AGE.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// setFlagsFromEnv fills in every flag of fs that wasn't given on the command line from
+// its environment variable, if set.  It runs after parsing so a flag always wins, even
+// for the repeatable ones that would otherwise add to the environment's value, and the
+// flags it sets count as given for isFlagSet.
+func setFlagsFromEnv(fs *flag.FlagSet) error {
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if err != nil || given[f.Name] || !ok {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %s", value, envName(f.Name), setErr)
+		}
+	})
+	return err
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/jrnt30/aws-utils/sqsmigrate"
+)
+
+// inspectWaitSeconds is how long -inspect long polls for a message.
+const inspectWaitSeconds = 5
+
+// inspect receives a single message from the queue for -inspect, writes everything SQS
+// knows about it to w and makes it visible again straight away.  The receive still counts
+// towards its ApproximateReceiveCount, and so towards a redrive policy.
+func inspect(ctx context.Context, sqsSvc *sqs.Client, w io.Writer, queueURL *string) error {
+	resp, err := sqsSvc.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:                    queueURL,
+		MaxNumberOfMessages:         1,
+		MessageSystemAttributeNames: []types.MessageSystemAttributeName{types.MessageSystemAttributeNameAll},
+		MessageAttributeNames:       []string{"All"},
+		VisibilityTimeout:           30,
+		WaitTimeSeconds:             inspectWaitSeconds,
+	})
+	if err != nil {
+		return err
+	}
+	if len(resp.Messages) == 0 {
+		return fmt.Errorf("no message was received from %s within %ds", path.Base(*queueURL), inspectWaitSeconds)
+	}
+	message := resp.Messages[0]
+	// The message goes back whether or not it could be written out.
+	writeErr := writeInspection(w, path.Base(*queueURL), &message, time.Now())
+	_, err = sqsSvc.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          queueURL,
+		ReceiptHandle:     message.ReceiptHandle,
+		VisibilityTimeout: 0,
+	})
+	if writeErr != nil {
+		return writeErr
+	}
+	return err
+}
+
+// writeInspection renders a message for -inspect, with a JSON body indented and
+// timestamps shown as times.
+func writeInspection(w io.Writer, queue string, message *types.Message, now time.Time) error {
+	var out strings.Builder
+	fmt.Fprintf(&out, "Message %s on %s\n", aws.ToString(message.MessageId), queue)
+	if sent, ok := attributeTime(message.Attributes[string(types.MessageSystemAttributeNameSentTimestamp)]); ok {
+		fmt.Fprintf(&out, "    Age: %s, sent %s\n", now.Sub(sent).Round(time.Second), sent.UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintf(&out, "    Receive count: %s, including this one\n", message.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)])
+	fmt.Fprintf(&out, "    MD5 of body: %s\n", aws.ToString(message.MD5OfBody))
+
+	body := aws.ToString(message.Body)
+	var indented bytes.Buffer
+	if json.Valid([]byte(body)) && json.Indent(&indented, []byte(body), "    ", "  ") == nil {
+		body = indented.String()
+	} else {
+		body = sqsmigrate.DescribeBody(body)
+	}
+	fmt.Fprintf(&out, "\nBody (%d bytes):\n    %s\n", len(aws.ToString(message.Body)), body)
+
+	out.WriteString("\nMessage attributes:\n")
+	if len(message.MessageAttributes) == 0 {
+		out.WriteString("    none\n")
+	}
+	names := []string{}
+	for name := range message.MessageAttributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&out, "    %s\n", sqsmigrate.DescribeAttribute(name, message.MessageAttributes[name]))
+	}
+
+	out.WriteString("\nSystem attributes:\n")
+	names = names[:0]
+	for name := range message.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := message.Attributes[name]
+		if at, ok := attributeTime(value); ok && strings.HasSuffix(name, "Timestamp") {
+			value += " (" + at.UTC().Format(time.RFC3339) + ")"
+		}
+		fmt.Fprintf(&out, "    %s: %s\n", name, value)
+	}
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// attributeTime parses a system attribute holding epoch milliseconds.
+func attributeTime(value string) (time.Time, bool) {
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond)), true
+}
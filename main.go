@@ -1,157 +1,1818 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"math"
+	"net"
+	"net/http"
 	"os"
-	"strconv"
-	"strings"
+	"path"
+	"regexp"
+	"sync"
+	"text/template"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/jrnt30/aws-utils/sqsmigrate"
 )
 
-const batchSize = 10
-
 // This is a small utility to allow migrating an SQS message from one queue to another.
 func main() {
-	source := flag.String("source", "", "Source queue to read from")
-	dest := flag.String("dest", "", "Queue to potentially move data to")
+	var sources sqsmigrate.QueueList
+	flag.Var(&sources, "source", "Source queue name, ARN or URL to read from, repeat or comma separate to migrate several in turn")
+	dest := flag.String("dest", "", "Queue name, ARN or URL to potentially move data to")
+	destPrefix := flag.String("dest-prefix", "", "Route each message to the queue named by this prefix followed by its -route-by field, instead of a single -dest")
+	sourceTags := queueTags{}
+	flag.Var(sourceTags, "source-tags", "Key=Value tags, repeat or comma separate, picking the one source queue that carries all of them instead of naming it with -source")
+	destTags := queueTags{}
+	flag.Var(destTags, "dest-tags", "Key=Value tags, repeat or comma separate, picking the one dest queue that carries all of them instead of naming it with -dest")
+	routeBy := flag.String("route-by", "", "JSON path, such as $.type, of the body field naming each message's -dest-prefix queue")
+	region := flag.String("region", "", "Region of the queues, overriding the shared config (e.g. us-gov-west-1 or cn-north-1)")
 	execute := flag.Bool("execute", false, "Perform migration of the messages to destination queue")
-	maxMessageAge := flag.Duration("max-age", time.Hour*12, "Duration of stale messages we are willing to tolerate and republish")
+	maxMessageAge := flag.Duration("max-age", time.Hour*12, "Only migrate messages sent less than this long ago.  The default leaves anything older than 12h on the source, use 0 to migrate messages of any age")
 	limit := flag.Int("limit", 10, "Duration of stale messages we are willing to tolerate and republish")
-	filter := flag.String("filter", "", "Provides a string filter that can be used to filter the message body")
+	filter := flag.String("filter", "", "Comma separated substrings to filter the message body on, a message matches if it contains any of them")
+	filterAll := flag.String("filter-all", "", "Comma separated substrings that must all be in the message body, on top of matching -filter when both are given")
+	filterMode := flag.String("filter-mode", sqsmigrate.FilterModeContains, "How -filter matches a body: contains for a substring, regex for a regular expression, or jsonpath for a $.path=value field of a JSON body.  With regex and jsonpath -filter is a single pattern, not split on commas")
+	filterFile := flag.String("filter-file", "", "File of additional -filter substrings, one per line")
 	verbose := flag.Bool("verbose", false, "Will print additional information for every message to be transmitted")
+	errorFilePath := flag.String("error-file", "", "Appends failed sends and deletes to this file so they can be replayed later")
+	replayPath := flag.String("replay-errors", "", "Re-attempts the failures recorded in this error file instead of reading new messages")
+	concurrency := flag.Int("concurrency", 1, "Number of workers receiving and migrating batches in parallel")
+	adaptive := flag.Bool("adaptive", false, "Start with a single worker and adjust concurrency based on throttling, up to -max-concurrency")
+	maxConcurrency := flag.Int("max-concurrency", 10, "Upper bound on the number of workers when using -adaptive")
+	groupIDFrom := flag.String("group-id-from", "", "Message attribute, or JSON path such as $.customerId, whose value becomes each message's MessageGroupId when moving into a FIFO queue")
+	staticGroupID := flag.String("group-id", "", "MessageGroupId for messages without the -group-id-from value, or for every message when it isn't given")
+	groupIDTemplate := flag.String("group-id-template", "", "Go template computing the destination MessageGroupId from the original {{.GroupID}} and source {{.Queue}} name")
+
+	onEmptyBody := flag.String("on-empty-body", sqsmigrate.EmptyBodySkip, "What to do with messages that have an empty body: skip, error or substitute")
+	emptyPlaceholder := flag.String("empty-body-placeholder", "(empty)", "Body sent in place of an empty one when using -on-empty-body substitute")
+	delay := flag.Duration("delay", 0, "Delivery delay applied to every migrated message, overriding -preserve-delay (up to 15m)")
+	preserveDelay := flag.Bool("preserve-delay", false, "Apply a message's own DelaySeconds attribute, when present, as its delivery delay on the destination")
+	maxInFlight := flag.Int("max-in-flight", 0, "Maximum number of received messages held across all workers until they are deleted, 0 for no cap")
+	forceText := flag.Bool("force-text", false, "Apply text filters to bodies that aren't valid UTF-8 instead of treating them as binary")
+	senderID := flag.String("sender-id", "", "Only migrate messages whose SenderId matches this IAM user, role or account ID")
+	transformTemplate := flag.String("transform-template", "", "Go template producing the new body from the original {{.Body}}, {{.MessageId}} and source {{.Queue}} name")
+	transformExec := flag.String("transform-exec", "", "Command that reads each body on stdin and writes the new body to stdout, given SQS_MESSAGE_ID and SQS_QUEUE in its environment.  Run directly, not through a shell")
+	transformExecConcurrency := flag.Int("transform-exec-concurrency", 4, "Most -transform-exec commands to run at once")
+	transformExecTimeout := flag.Duration("transform-exec-timeout", 10*time.Second, "How long each -transform-exec command may run before it is killed and counts as failed")
+	onTransformError := flag.String("on-transform-error", sqsmigrate.TransformErrorSkip, "What to do with a message whose transform fails, including a non-zero exit of -transform-exec: skip, or error to end the run")
+	showDiff := flag.Int("show-diff", 0, "In Dry-Run mode, print a unified diff of the transformed body and the attributes added, removed and renamed for up to this many messages")
+	compat := flag.String("compat", "", "Relax assumptions about the SQS API for compatible servers: elasticmq or localstack")
+	notifyWebhook := flag.String("notify-webhook", "", "POST the final summary as JSON, with the queues and exit status, to this URL (such as a Slack incoming webhook) when the run finishes or fails")
+	reportFile := flag.String("report-file", "", "Writes a JSON summary of the run, including API latency, to this file")
+	all := flag.Bool("all", false, "Migrate every matching message, ignoring -limit")
+	maxEmptyDuration := flag.Duration("max-empty-duration", 0, "Keep long polling an empty source until no messages have arrived for this long, rather than stopping at the first empty receive")
+	createDest := flag.Bool("create-dest", false, "Create the destination queue if it doesn't exist, copying the source queue's settings")
+	destRetention := flag.Duration("dest-retention", 0, "Message retention period for a created destination queue")
+	destVisibility := flag.Duration("dest-visibility", 0, "Visibility timeout for a created destination queue")
+	destDLQ := flag.String("dest-dlq-arn", "", "Dead-letter queue ARN for a created destination queue")
+	destMaxReceives := flag.Int("dest-max-receives", 0, "Receives before a message moves to -dest-dlq-arn on a created destination queue")
+	destCreateFIFO := flag.Bool("dest-create-fifo", false, "Create the destination as a FIFO queue even when the source is a standard one.  Its name has to end in .fifo")
+	destContentDedup := flag.Bool("dest-content-dedup", false, "Turn on ContentBasedDeduplication for a created FIFO destination")
+	destDedupScope := flag.String("dest-dedup-scope", "", "DeduplicationScope of a created FIFO destination: queue or messageGroup")
+	destFIFOThroughput := flag.String("dest-fifo-throughput", "", "FifoThroughputLimit of a created FIFO destination: perQueue or perMessageGroupId, which needs a -dest-dedup-scope of messageGroup")
+	minBodyBytes := flag.Int("min-body-bytes", 0, "Only migrate messages of at least this many bytes")
+	maxBodyBytes := flag.Int("max-body-bytes", 0, "Only migrate messages of at most this many bytes, 0 for no maximum")
+	sizeIncludesAttributes := flag.Bool("size-include-attributes", false, "Count message attributes towards -min-body-bytes/-max-body-bytes, as SQS does for its size limit")
+	histogram := flag.Bool("histogram", false, "Print how many matched messages fall into each age bucket, useful in Dry-Run mode to gauge how stale a queue is")
+	stats := flag.Bool("stats", false, "Dry-Run only: scan up to -limit messages, matching or not, and print how old they are and how many the filters would migrate, with the oldest and newest send times under -verbose.  The messages are made visible on the source again afterwards")
+	newestFirst := flag.Bool("newest-first", false, "Scan the source first and migrate the most recently sent matching messages, up to -limit.  Only messages received within one visibility timeout are ranked")
+	maxAPICalls := flag.Int64("max-api-calls", 0, "Stop starting new batches once the run could exceed this many SQS API requests, 0 for no limit")
+	onOversize := flag.String("on-oversize", sqsmigrate.OversizeSkip, "What to do with messages over the 256KB SQS limit once staged: skip, or truncate to drop attributes added by this tool")
+	sourcePrefix := flag.String("source-prefix", "", "Also migrate every queue whose name starts with this prefix, other than -dest")
+	yes := flag.Bool("yes", false, "Skip the confirmation prompt before migrating the queues found by -source-prefix, -source-tags, -dest-tags or -redrive")
+	setAttributes := sqsmigrate.AttributeList{}
+	flag.Var(setAttributes, "set-attr", "Message attribute name=value:Type, with a Type of String or Number, set on every migrated message.  May be repeated")
+	renameAttributes := sqsmigrate.AttributeRenames{}
+	flag.Var(renameAttributes, "rename-attr", "Rename a message attribute old=new on every migrated message, copying the message's attributes over.  May be repeated")
+	dropAttributes := sqsmigrate.AttributeNames{}
+	flag.Var(dropAttributes, "drop-attr", "Leave a message attribute off every migrated message, copying the rest of its attributes over.  May be repeated")
+	copyAttributes := flag.Bool("copy-attributes", true, "Copy each message's MessageAttributes over to the destination.  Set -copy-attributes=false for a body-only copy")
+	copyDedupID := flag.Bool("copy-dedup-id", true, "Between FIFO queues, carry each message's MessageDeduplicationId over along with its MessageGroupId")
+	preserveTimestamp := flag.Bool("preserve-timestamp", false, "Copy each message's ApproximateFirstReceiveTimestamp onto the migrated message as a Number attribute of the same name")
+	pricePerMillion := flag.Float64("price-per-million", 0.40, "SQS price in USD per million requests, used to estimate the cost of a dry run")
+	destRegion := flag.String("dest-region", "", "Region of -dest when it differs from the source, which may be in another partition such as us-gov-west-1")
+	showAccountAlias := flag.Bool("show-account-alias", false, "Look up the IAM account alias of the source and -dest-profile credentials, and show it alongside the account ID in the logs and confirmation prompts")
+	destProfile := flag.String("dest-profile", "", "Shared config profile whose credentials are used for -dest, needed when it is in another account or partition.  Defaults to the source's credentials")
+	sourceProfile := flag.String("source-profile", "", "Shared config profile whose credentials are used to receive from and delete on the sources, instead of the default credential chain")
+	once := flag.Bool("once", false, "Process a single receive, filter, send and delete cycle from the first source and stop, whatever -limit is")
+	dedupFromBody := flag.Bool("dedup-from-body", false, "Set each message's MessageDeduplicationId to a SHA-256 of its body as sent, so a re-run within the 5 minute deduplication interval doesn't duplicate it.  FIFO destinations only")
+	batchDelay := flag.Duration("batch-delay", 0, "Pause each worker for this long after every batch it migrates, to pace the load on downstream consumers.  Ignored in Dry-Run mode")
+	rate := flag.Float64("rate", 0, "Send at most this many messages per second across every worker and source, to pace the load on downstream consumers.  0 for no limit")
+	idsFilePath := flag.String("ids-file", "", "Writes the ID of every matching message to this file, one per line.  In Dry-Run mode the received messages are also made visible again once the run is done")
+	unwrapSNS := flag.Bool("unwrap-sns", false, "Apply -filter and -transform-template to the inner Message of SNS notification bodies, re-wrapping it in the original envelope when sent.  Other bodies are handled as usual")
+	sendUnwrapped := flag.Bool("send-unwrapped", false, "With -unwrap-sns, send the inner Message of SNS notifications on its own instead of re-wrapping it")
+	minVisibility := flag.Duration("min-visibility", 60*time.Second, "Shortest visibility timeout for received messages")
+	maxVisibility := flag.Duration("max-visibility", 60*time.Second, "Longest visibility timeout for received messages.  When above -min-visibility the timeout adapts to recent send and delete latency within the two")
+	credentialsFile := flag.String("credentials-file", "", "Shared credentials file to use instead of ~/.aws/credentials")
+	configFile := flag.String("config-file", "", "Shared config file to use instead of ~/.aws/config")
+	noDelete := flag.Bool("no-delete", false, "Send messages with -execute but never delete them from the source, where they reappear once their visibility timeout expires")
+	skipPreflight := flag.Bool("skip-preflight", false, "Don't check the receive, send and delete permissions on the queues before an -execute run")
+	slaAge := flag.Duration("sla-age", 0, "Warn about, and count in the summary, each migrated message older than this, without filtering it")
+	interactive := flag.Bool("interactive", false, "Show each matching message and ask whether to migrate it.  Declined messages stay on the source, invisible until their visibility timeout expires")
+	var jsonFilters sqsmigrate.JSONFilterList
+	var attrFilters sqsmigrate.AttributeFilterList
+	flag.Var(&attrFilters, "attr-filter", "Only migrate messages with a message attribute matching, as name=value, name~substring, or for Number attributes name>=5 with any of == != < <= > >=.  May be repeated, all must match")
+	flag.Var(&jsonFilters, "json-filter", "Only migrate JSON bodies with a field equal to a value, as $.path.to.field=value with [n] for array elements.  May be repeated, all must match")
+	skipDuplicateIDs := flag.Bool("skip-duplicate-ids", false, "Remove a message from the source without sending it when a copy with the same MessageId has already been sent in this run")
+	tail := flag.Bool("tail", false, "Keep migrating messages from a single source as they arrive until interrupted, implying -all.  Long polls and backs off while the source is empty, pace it with -batch-delay")
+	checkpointFile := flag.String("checkpoint-file", "", "Save progress to this file as the run goes, and resume from it when it exists: the summary adds up every run and the message IDs already seen carry over, so -skip-duplicate-ids can drop messages that reappear after being sent")
+	format := flag.String("format", sqsmigrate.SummaryText, "Format of the closing summary: text, json for a JSON object, or prometheus for metrics in the text exposition format")
+	quiet := flag.Bool("quiet", false, "Only log errors, to stderr, leaving out the progress logs and the text summary.  A -report-file or -format json or prometheus summary is still written")
+	requireMin := flag.Int("require-min", 0, "Exit with status 3 before migrating anything if the sources hold fewer than this many messages in total, going by ApproximateNumberOfMessages")
+	waitTime := flag.Duration("wait-time", 0, "Long poll of each receive, waited out on the server when the source is empty (up to 20s).  Defaults to 20s with -tail, to -max-empty-duration up to 20s when given, and to no long polling otherwise")
+	pollDelay := flag.Duration("poll-delay", 0, "Pause after each empty receive that doesn't end the run, on top of -wait-time.  Replaces the backoff of -tail, otherwise there is no pause")
+	releaseNonmatching := flag.Bool("release-nonmatching", false, "Make received messages that aren't migrated visible on the source again straight away, rather than after the visibility timeout.  The run ends once a receive returns only released messages")
+	httpTimeout := flag.Duration("http-timeout", 0, "Give up on any SQS request that takes longer than this, connecting included, instead of waiting on a hung endpoint forever.  Must be longer than 20s when long polling")
+	redrive := flag.Bool("redrive", false, "Move the messages of a dead-letter -source back to the queue its RedrivePolicy belongs to, found from the queues' settings, when no -dest is given")
+	moveToDLQ := flag.Bool("move-to-dlq", false, "Quarantine matching messages by moving them to -dlq with their attributes, tagged with a MovedToDLQReason attribute, instead of migrating them to -dest")
+	dlq := flag.String("dlq", "", "Queue name or ARN that -move-to-dlq moves messages to")
+	dlqReason := flag.String("dlq-reason", "Moved manually", "MovedToDLQReason attribute set on every message moved with -move-to-dlq")
+	batchReportPath := flag.String("batch-report", "", "Write a JSON line for every batch, with its counts and latencies, to this file as the run goes, or - for stdout")
+	verifyChecksum := flag.Bool("verify-checksum", false, "Check the MD5s SQS reports for every sent body and its message attributes against what was sent, leaving any mismatch on the source as a failed send")
+	pluginDir := flag.String("plugin-dir", "", "Directory of Go plugins (.so, built with -buildmode=plugin) exporting a Transform(body []byte, attrs map[string]string) ([]byte, map[string]string, error), run on every message in name order after any other transform")
+	color := flag.String("color", sqsmigrate.ColorAuto, "Color the logs: auto when stderr is a terminal and NO_COLOR isn't set, always or never")
+	fifoSequential := flag.Bool("fifo-sequential", false, "Send to a FIFO destination one message at a time with SendMessage, in the order they were sent to the source, for the strictest ordering at the cost of speed")
+	maxRetries := flag.Int("max-retries", 0, "Send a message that failed to send again up to this many times within its batch")
+	batchTimeout := flag.Duration("batch-timeout", 0, "Cut off any single send or delete request taking longer than this, counting its messages as failed so they stay on the source, instead of letting a hung call stall the run.  0 for no limit")
+	partialRetryDelay := flag.Duration("partial-retry-delay", 0, "With -max-retries, wait this long before retrying the failed sends of a batch, doubling the wait for every attempt after.  Independent of -batch-delay")
+	failedDest := flag.String("failed-dest", "", "Queue name or ARN to move messages to, tagged with a SendFailedReason attribute, once -max-retries is used up, removing them from the source")
+	flag.StringVar(failedDest, "failure-dest", "", "Same as -failed-dest")
+	sendFailureThreshold := flag.Int("send-failure-threshold", 0, "With -failed-dest, only move a message there once this many of its sends have failed in the run, counting -max-retries and later receives, leaving it on the source until then")
+	probeQueues := flag.Bool("probe", false, "Print the settings and depth of the queues and check the permissions a migration needs on them, then exit without moving anything")
+	ageFrom := flag.String("age-from", "", "JSON path such as $.created_at of a body field holding when the message was created, used for -max-age instead of SentTimestamp when present")
+	ageFormat := flag.String("age-format", sqsmigrate.AgeFormatRFC3339, "Format of the -age-from field: rfc3339, unix, unix-ms or a Go time layout")
+	accumulate := flag.Duration("accumulate", 0, "Hold messages staged from under-full receives for up to this long so they can be sent in full batches of 10, 0 to send each receive's messages straight away")
+	listQueueNames := flag.Bool("list-queues", false, "Print every queue, or those starting with -source-prefix, with its approximate number of messages and exit")
+	strictAttributes := flag.Bool("strict-attributes", false, "Refuse to migrate when the destination's retention, visibility timeout, maximum message size, dead-letter queue or FIFO setting falls short of a source's")
+	bodyPrefix := flag.String("body-prefix", "", "Text added to the start of every body before it is sent, after any transform")
+	bodySuffix := flag.String("body-suffix", "", "Text added to the end of every body before it is sent, after any transform")
+	var receiveAttributes sqsmigrate.SystemAttributeList
+	receiveMessageAttributes := sqsmigrate.AttributeNames{}
+	flag.Var(receiveMessageAttributes, "receive-message-attributes", "Message attributes to request on every receive, comma separated, with a trailing .* for a prefix, instead of All.  Copying, sizing and plugins then only see these.  May be repeated")
+	flag.Var(&receiveAttributes, "receive-attributes", "System attributes to request on every receive on top of those the other flags need, comma separated, or All.  May be repeated")
+	deleteConcurrency := flag.Int("delete-concurrency", 1, "Number of batches deleted from the source in parallel, once they have been sent")
+	assertEmpty := flag.Bool("assert-empty", false, "Once the migration is done, exit with status 4 unless every source reports no messages, available, in flight or delayed, within -assert-empty-grace")
+	assertEmptyGrace := flag.Duration("assert-empty-grace", time.Minute, "How long -assert-empty waits for the sources to report empty, as the counts SQS reports lag behind")
+	dryRunSample := flag.Int("dry-run-sample", 0, "Dry-Run only: stop once this many messages match, showing each of them, and make every received message visible on the source again")
+	compressOver := flag.Int("compress-over", 0, "Gzip and base64 encode bodies over this many bytes before sending, tagged with a Content-Encoding attribute of gzip, 0 to never compress")
+	var pairs pairList
+	flag.Var(&pairs, "pair", "Source and destination queue to migrate as source=dest, instead of -source and -dest.  May be repeated")
+	pairsFile := flag.String("pairs-file", "", "File of source=dest pairs to migrate, one per line")
+	parallelQueues := flag.Int("queue-concurrency", 1, "Number of source queues, from -source, -source-prefix or -pair, migrated at once, each with its own -concurrency workers")
+	flag.IntVar(parallelQueues, "parallel-queues", 1, "Same as -queue-concurrency")
+	sdkMaxRetries := flag.Int("sdk-max-retries", 2, "Times the SDK retries a failed or throttled request before giving up, on top of the first attempt.  Separate from -max-retries, which resends messages a successful batch request reported as failed")
+	retryMode := flag.String("retry-mode", string(aws.RetryModeStandard), "SDK retry mode: standard, or adaptive to also rate limit requests on the client once throttled")
+	dedupFrom := flag.String("dedup-from", "", "JSON path such as $.order_id of a body field to use as each message's MessageDeduplicationId, leaving messages without it on the source.  FIFO destinations only")
+	checkDedupIDs := flag.String("check-dedup-ids", "", "Check every message's deduplication ID against the others staged within 5 minutes, which the dest would silently drop: warn counts each collision, error stops before sending it.  FIFO destinations only")
+	maxAgeSeconds := flag.Int64("max-age-seconds", 0, "-max-age as a number of seconds")
+	maxAgeMinutes := flag.Int64("max-age-minutes", 0, "-max-age as a number of minutes")
+	maxAgeHours := flag.Float64("max-age-hours", 0, "-max-age as a number of hours, such as 1.5")
+	ttl := flag.Duration("ttl", 0, "Remove matching messages older than this from the source instead of migrating them, using the -age-from field when given.  Needs a longer -max-age, or -max-age 0")
+	emitScript := flag.String("emit-script", "", "In Dry-Run mode, write the aws sqs send-message-batch and delete-message-batch commands -execute would have run to this file")
+	heartbeat := flag.Bool("heartbeat", false, "Keep extending the visibility timeout of each batch on the source while it is being sent, for sends slower than the visibility timeout")
+	heartbeatInterval := flag.Duration("heartbeat-interval", 0, "How often -heartbeat extends a batch's visibility, half of -heartbeat-extend by default.  Turns on -heartbeat")
+	heartbeatExtend := flag.Duration("heartbeat-extend", 0, "How far each -heartbeat extends a batch's visibility from that moment, the receive's visibility timeout by default.  Turns on -heartbeat")
+	dropMatching := flag.String("drop-matching", "", "Comma separated substrings marking junk: a message whose body contains any of them is removed from the source without being sent, whatever the other filters say")
+	var queueFilters sqsmigrate.QueueFilterList
+	flag.Var(&queueFilters, "queue-filter", "Only take -source-prefix queues with a queue attribute of this value, as Attribute=value, Attribute=* for any value or Attribute= for none.  May be repeated, all must match")
+	outputTemplate := flag.String("output-template", "", "Go template written to stdout for each staged message instead of the logged preview, with {{.MessageId}}, {{.Queue}}, {{.Body}}, {{.Age}}, {{.Attributes}} and {{.SystemAttributes}}, and {{json ...}} to render a field as JSON")
+	promoteSNSAttributes := flag.Bool("promote-sns-attributes", false, "With -unwrap-sns, set the MessageAttributes inside each SNS notification as message attributes on the migrated message")
+	failFast := flag.Bool("fail-fast", true, "End the run on the first receive, send or delete request that fails as a whole.  The default, see -continue-on-error")
+	continueOnError := flag.Bool("continue-on-error", false, "Log and count a receive, send or delete request that fails as a whole and carry on with the next batch, exiting with status 5 at the end.  Same as -fail-fast=false")
+	testSendFlag := flag.Bool("test-send", false, "Send one synthetic message, with -test-body and any -set-attr attributes, to -dest with the destination's credentials and exit, non-zero when it can't be sent")
+	testBody := flag.String("test-body", `{"aws-utils":"test-send"}`, "Body of the -test-send message")
+	testDelete := flag.Bool("test-delete", false, "Receive the -test-send message back from -dest and delete it once sent")
+	filterConfigPath := flag.String("filter-config", "", "File of named regular expression rules, one per line as NAME include|exclude|route=QUEUE PATTERN, the first matching a body deciding whether it is migrated and to which queue")
+	destMaxDepth := flag.Int("dest-max-depth", 0, "Stop receiving while the destination holds more than this many messages, going by ApproximateNumberOfMessages, so consumers can keep up.  0 never waits")
+	destResumeDepth := flag.Int("dest-resume-depth", 0, "Carry on receiving once -dest-max-depth has been exceeded when the destination is down to this many messages, 0 for half of -dest-max-depth")
+	destDepthInterval := flag.Duration("dest-depth-interval", 10*time.Second, "How often -dest-max-depth checks the depth of the destination")
+	dedupeWindow := flag.Duration("dedupe-window", 0, "Only remember the MessageIds received within this long for spotting repeats and -skip-duplicate-ids, bounding memory on very large runs.  0 remembers them for the whole run")
+	hashModuloFlag := flag.String("hash-modulo", "", "Only migrate the messages whose MessageId hashes to below N modulo M, given as N/M, for a canary subset that stays the same across runs")
+	skipUntilID := flag.String("skip-until-id", "", "Leave every message on the source until the one with this MessageId is received, then migrate it and those after it, to resume an ordered migration")
+	skipIDsFile := flag.String("skip-ids-file", "", "File of MessageIds to leave on the source, one per line as -ids-file writes them, such as those an earlier run already migrated")
+	streamEvents := flag.Bool("stream-events", false, "Write an ndjson event to stdout for every message received, skipped, migrated or failed, with its reason, as the run goes.  The logs stay on stderr")
+	requireJSON := flag.Bool("require-json", false, "Only migrate messages whose body is valid JSON, handling the rest by -on-invalid-json or moving them to -invalid-dest")
+	onInvalidJSON := flag.String("on-invalid-json", sqsmigrate.InvalidJSONSkip, "What -require-json does with a body that isn't valid JSON: skip, leaving it on the source, or error")
+	invalidDest := flag.String("invalid-dest", "", "Standard queue to move messages whose body fails -require-json to, unchanged, removing them from the source")
+	detectDLQ := flag.Bool("detect-dlq", false, "Log the dead-letter queue of each source, from its RedrivePolicy, with its depth next to the source's")
+	includeDLQ := flag.Bool("include-dlq", false, "Also migrate, and so clear, the dead-letter queue of each source found as with -detect-dlq, after the sources themselves")
+	delayPerReceive := flag.Duration("delay-per-receive", 0, "Extra delivery delay for each time a message was received before, going by ApproximateReceiveCount, so messages that keep failing are spread out on the destination.  Capped at 15m in total")
+	credentialSource := flag.String("credential-source", "", "Take the source credentials from this provider only, instead of the first of the default chain that has any: env, shared, ec2-metadata, ecs or web-identity")
+	inspectFlag := flag.Bool("inspect", false, "Receive one message from the -source, print its body, message attributes and system attributes, and make it visible again straight away")
+	pipelinePath := flag.String("pipeline", "", "File of transform steps run in order on every body, one per line as STEP ARGUMENT, in place of -transform-template, -transform-exec and -unwrap-sns")
+	cloudWatchNamespace := flag.String("cloudwatch-namespace", "", "Publish the counts of messages processed, sent, deleted and failed to CloudWatch under this namespace as the run goes, with a final flush when it ends or is interrupted")
+	metricsFlushInterval := flag.Duration("metrics-flush-interval", time.Minute, "How often -cloudwatch-namespace publishes the counts since the previous flush, all in one PutMetricData call")
+	compareBodies := flag.Bool("compare-bodies", false, "After a -no-delete copy, receive from the destination and check every body sent arrived, reporting missing and unexpected messages.  Exits with status 6 when any are missing")
+	compareSample := flag.Int("compare-sample", 0, "Most messages -compare-bodies receives from each destination, or 0 for no limit")
+	compareTimeout := flag.Duration("compare-timeout", time.Minute, "How long -compare-bodies looks for the sent bodies on each destination")
+	exitOnIdle := flag.Duration("exit-on-idle", 0, "With -tail, stop once nothing has been received for this long, finishing with the usual summary")
+	timeout := flag.Duration("timeout", 0, "Stop receiving once the migration has run this long, finishing the batches in hand and printing the summary.  Exits with status 7, except with -tail.  0 for no limit")
+	csvOutcome := flag.String("csv-outcome", "", "Write a CSV row to this file for every message received, with its age, whether it was skipped, migrated or failed, its destination and any error code, as the run goes")
+	maxInFlightBatches := flag.Int("max-in-flight-batches", 0, "Maximum number of batches between their receive and the end of their deletes across all workers, blocking receives while full, 0 for no cap")
+
+	// Completion lists every flag, so it has to come after the last one is defined.
+	if len(os.Args) > 1 && os.Args[1] == "completion" {
+		if len(os.Args) != 3 {
+			log.Fatalf("Usage: %s completion bash|zsh|fish", commandName)
+		}
+		if err := writeCompletion(os.Stdout, os.Args[2], flag.CommandLine); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	flag.Parse()
+	if err := setFlagsFromEnv(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
 
-	var destQueueURL *sqs.GetQueueUrlOutput
-	logger := log.New(os.Stdout, "", log.LstdFlags)
+	var destQueueURL *string
+	if *color != sqsmigrate.ColorAuto && *color != sqsmigrate.ColorAlways && *color != sqsmigrate.ColorNever {
+		log.Fatalf("Unknown -color setting %q, expected auto, always or never", *color)
+	}
+	logger := sqsmigrate.NewLogger(*quiet, sqsmigrate.UseColor(*color))
 	runTime := time.Now()
+	notifier := sqsmigrate.NewWebhookNotifier(*notifyWebhook, logger, sources, *dest)
+	if notifier != nil {
+		logger.OnFatal = notifier.Fatal
+	}
+
+	if *pairsFile != "" {
+		if err := readPairsFile(*pairsFile, &pairs); err != nil {
+			logger.Errorln("Encountered an error when attempting to read the pairs file")
+			logger.Fatal(err)
+		}
+	}
+	if len(pairs) > 0 {
+		if len(sources) > 0 || *sourcePrefix != "" || *dest != "" || *destPrefix != "" || *moveToDLQ || *replayPath != "" || *createDest {
+			logger.Fatal("-pair and -pairs-file name every source and destination, which can't be combined with -source, -source-prefix, -dest, -dest-prefix, -move-to-dlq, -create-dest or -replay-errors")
+		}
+		for _, pair := range pairs {
+			if pair.source == pair.dest && *destRegion == "" && *destProfile == "" {
+				logger.Fatalf("Need to provide a different destination than the source in the pair %s", pair)
+			}
+			sources = append(sources, pair.source)
+		}
+	}
+	ageFlags := 0
+	for _, name := range []string{"max-age", "max-age-seconds", "max-age-minutes", "max-age-hours"} {
+		if isFlagSet(name) {
+			ageFlags++
+		}
+	}
+	if ageFlags > 1 {
+		logger.Fatal("Need to provide only one of -max-age, -max-age-seconds, -max-age-minutes and -max-age-hours")
+	}
+	if *maxAgeSeconds < 0 || *maxAgeMinutes < 0 || *maxAgeHours < 0 {
+		logger.Fatal("Need to provide a maximum age of 0 or more")
+	}
+	switch {
+	case isFlagSet("max-age-seconds"):
+		*maxMessageAge = time.Duration(*maxAgeSeconds) * time.Second
+	case isFlagSet("max-age-minutes"):
+		*maxMessageAge = time.Duration(*maxAgeMinutes) * time.Minute
+	case isFlagSet("max-age-hours"):
+		*maxMessageAge = time.Duration(*maxAgeHours * float64(time.Hour))
+	}
+	if *ttl < 0 {
+		logger.Fatal("Need to provide a -ttl of 0 or more")
+	}
+	if *ttl > 0 && *maxMessageAge > 0 && *maxMessageAge <= *ttl {
+		logger.Fatal("-ttl needs a -max-age longer than it, or -max-age 0, as -max-age leaves older messages on the source before the TTL is checked")
+	}
+	if len(queueFilters) > 0 && *sourcePrefix == "" {
+		logger.Fatal("-queue-filter only applies to the queues found with -source-prefix")
+	}
+	if *continueOnError && isFlagSet("fail-fast") && *failFast {
+		logger.Fatal("Need to provide only one of -fail-fast and -continue-on-error")
+	}
+	if !*failFast {
+		*continueOnError = true
+	}
+	if *dedupeWindow < 0 {
+		logger.Fatal("Need to provide a -dedupe-window of at least 0")
+	}
+	if *parallelQueues < 1 {
+		logger.Fatal("Need to provide a -queue-concurrency of at least 1")
+	}
+	if *destMaxDepth < 0 || *destResumeDepth < 0 {
+		logger.Fatal("Need to provide a -dest-max-depth and -dest-resume-depth of at least 0")
+	}
+	if *cloudWatchNamespace == "" && isFlagSet("metrics-flush-interval") {
+		logger.Fatal("-metrics-flush-interval only applies to -cloudwatch-namespace")
+	}
+	if *metricsFlushInterval <= 0 {
+		logger.Fatal("Need to provide a positive -metrics-flush-interval")
+	}
+	if *destMaxDepth == 0 && (isFlagSet("dest-resume-depth") || isFlagSet("dest-depth-interval")) {
+		logger.Fatal("-dest-resume-depth and -dest-depth-interval only apply to -dest-max-depth")
+	}
+	if *destMaxDepth > 0 {
+		if *destPrefix != "" {
+			logger.Fatal("-dest-max-depth watches a -dest, it can't follow the queues -dest-prefix routes to")
+		}
+		if *destResumeDepth == 0 {
+			*destResumeDepth = *destMaxDepth / 2
+		}
+		if *destResumeDepth >= *destMaxDepth {
+			logger.Fatal("Need to provide a -dest-resume-depth below -dest-max-depth")
+		}
+		if *destDepthInterval <= 0 {
+			logger.Fatal("Need to provide a positive -dest-depth-interval")
+		}
+	}
+
+	if *testDelete && !*testSendFlag {
+		logger.Fatal("-test-delete only applies to -test-send")
+	}
+	if *testSendFlag && *dest == "" && len(destTags) == 0 {
+		logger.Fatal("Need to provide a -dest to -test-send to")
+	}
 
-	if *source == "" {
-		logger.Println("Need to provide a source queue name properly to use this utility")
+	if len(sources) == 0 && *sourcePrefix == "" && len(sourceTags) == 0 && !*listQueueNames && !*testSendFlag {
+		logger.Errorln("Need to provide a source queue name properly to use this utility")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	if *dest == "" && *execute {
-		logger.Println("Need ot provide a destination queue name if attempting to execute a migration")
+	if *inspectFlag && (len(sources) != 1 && !(len(sources) == 0 && len(sourceTags) > 0) || *sourcePrefix != "" || len(pairs) > 0) {
+		logger.Fatal("-inspect looks at a single -source")
+	}
+	if *includeDLQ && (len(pairs) > 0 || *moveToDLQ) {
+		logger.Fatal("-include-dlq adds sources for the -dest, it can't be combined with -pair or -move-to-dlq")
+	}
+
+	if *moveToDLQ {
+		if *dlq == "" || *dest != "" || *destPrefix != "" {
+			logger.Fatal("-move-to-dlq needs a -dlq to move messages to instead of a -dest")
+		}
+		if err := setAttributes.Set(sqsmigrate.MovedToDLQAttribute + "=" + *dlqReason + ":String"); err != nil {
+			logger.Fatal(err)
+		}
+		*dest = *dlq
+	} else if *dlq != "" {
+		logger.Fatal("-dlq only applies with -move-to-dlq")
+	}
+
+	if (*destPrefix == "") != (*routeBy == "") {
+		logger.Fatal("-dest-prefix and -route-by need to be given together")
+	}
+	if *destPrefix != "" && (*dest != "" || *createDest || *replayPath != "") {
+		logger.Fatal("-dest-prefix routes to several queues, which can't be combined with -dest, -create-dest or -replay-errors")
+	}
+	if len(sourceTags) > 0 && (*sourcePrefix != "" || len(pairs) > 0) {
+		logger.Fatal("-source-tags picks a single source, which can't be combined with -source-prefix or -pair")
+	}
+	if len(destTags) > 0 && (*dest != "" || *destPrefix != "" || *createDest || len(pairs) > 0) {
+		logger.Fatal("-dest-tags picks an existing queue as the -dest, which can't be combined with -dest, -dest-prefix, -create-dest, -move-to-dlq or -pair")
+	}
+	if *redrive && (len(sources)+len(sourceTags) == 0 || len(sources) > 1 || len(sources) == 1 && len(sourceTags) > 0 || *sourcePrefix != "" || len(pairs) > 0) {
+		logger.Fatal("-redrive moves a single dead-letter queue back, which needs exactly one -source or -source-tags")
+	}
+	if *redrive && *dest == "" && (*destPrefix != "" || len(destTags) > 0 || *createDest) {
+		logger.Fatal("-redrive finds the -dest itself, which can't be combined with -dest-prefix, -dest-tags or -create-dest")
+	}
+	// destLater is whether the -dest is only found once the clients are set up, which
+	// delays the checks that depend on it being FIFO until then.
+	destLater := len(destTags) > 0 || *redrive && *dest == ""
+	destName := *dest
+	if *destPrefix != "" {
+		destName = *destPrefix + "*"
+	}
+	if len(destTags) > 0 {
+		destName = "the queue tagged " + destTags.String()
+	}
+	if *redrive && *dest == "" {
+		destName = "the queue the dead-letter queue belongs to"
+	}
+
+	if destName == "" && *execute && len(pairs) == 0 {
+		logger.Errorln("Need ot provide a destination queue name if attempting to execute a migration")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	if *execute && *source == *dest {
-		logger.Fatal("Need to provide different a different queue name for source and destination")
+	for _, source := range sources {
+		if *execute && source == *dest && *destRegion == "" && *destProfile == "" {
+			logger.Fatal("Need to provide different a different queue name for source and destination")
+		}
+	}
+
+	if *heartbeatInterval != 0 || *heartbeatExtend != 0 {
+		*heartbeat = true
+	}
+	if *heartbeatInterval < 0 || *heartbeatExtend < 0 || *heartbeatExtend > sqsmigrate.MaxVisibilityTimeout {
+		logger.Fatal("Need to provide a -heartbeat-interval and -heartbeat-extend of 0 or more, with -heartbeat-extend up to 12h")
+	}
+	if *heartbeatExtend > 0 && *heartbeatExtend < time.Second {
+		logger.Fatal("Need to provide a -heartbeat-extend of at least 1s")
+	}
+	if *heartbeatInterval > 0 && *heartbeatExtend > 0 && *heartbeatInterval >= *heartbeatExtend {
+		logger.Fatal("Need to provide a -heartbeat-interval shorter than -heartbeat-extend, or the batch becomes visible between heartbeats")
+	}
+	if *heartbeatInterval > 0 && *heartbeatExtend == 0 && *heartbeatInterval >= *minVisibility {
+		logger.Fatal("Need to provide a -heartbeat-interval shorter than -min-visibility, or a longer -heartbeat-extend")
+	}
+	if *minVisibility < time.Second || *minVisibility > *maxVisibility || *maxVisibility > sqsmigrate.MaxVisibilityTimeout {
+		logger.Fatal("Need to provide a -min-visibility of at least 1s, no more than a -max-visibility of up to 12h")
+	}
+
+	switch *format {
+	case sqsmigrate.SummaryText, sqsmigrate.SummaryJSON, sqsmigrate.SummaryPrometheus:
+	default:
+		logger.Fatalf("Unknown -format %q, expected text, json or prometheus", *format)
+	}
+
+	if *pricePerMillion < 0 {
+		logger.Fatal("Need to provide a -price-per-million of at least 0")
+	}
+
+	if *noDelete && *execute {
+		logger.Println("Running with -no-delete: messages are sent but left on the source, where they will reappear and could be migrated again")
+	}
+
+	if *promoteSNSAttributes && !*unwrapSNS {
+		logger.Fatal("-promote-sns-attributes only applies with -unwrap-sns")
+	}
+	if *sendUnwrapped && !*unwrapSNS {
+		logger.Fatal("-send-unwrapped only applies with -unwrap-sns")
+	}
+
+	if *dedupFromBody && *dest != "" && !sqsmigrate.IsFIFO(*dest) {
+		logger.Fatal("-dedup-from-body only applies to a FIFO destination, SQS rejects deduplication IDs on standard queues")
+	}
+
+	if *dedupFrom != "" && *dedupFromBody {
+		logger.Fatal("Need to provide only one of -dedup-from and -dedup-from-body")
+	}
+	if *dedupFrom != "" && *dest != "" && !sqsmigrate.IsFIFO(*dest) {
+		logger.Fatal("-dedup-from only applies to a FIFO destination, SQS rejects deduplication IDs on standard queues")
+	}
+	if *checkDedupIDs != "" && *checkDedupIDs != sqsmigrate.DedupCheckWarn && *checkDedupIDs != sqsmigrate.DedupCheckError {
+		logger.Fatalf("-check-dedup-ids must be %s or %s, not %q", sqsmigrate.DedupCheckWarn, sqsmigrate.DedupCheckError, *checkDedupIDs)
+	}
+	if *checkDedupIDs != "" && !destLater && (*dest == "" || !sqsmigrate.IsFIFO(*dest)) {
+		logger.Fatal("-check-dedup-ids only applies to a FIFO -dest")
+	}
+	var dedupFromPath []interface{}
+	if *dedupFrom != "" {
+		var err error
+		if dedupFromPath, err = sqsmigrate.ParseJSONPath(*dedupFrom); err != nil {
+			logger.Fatalf("Invalid -dedup-from: %s", err)
+		}
+	}
+
+	if *fifoSequential && !destLater && (*dest == "" || !sqsmigrate.IsFIFO(*dest)) {
+		logger.Fatal("-fifo-sequential only applies to a FIFO -dest, standard queues don't keep messages in order")
+	}
+
+	if len(*bodyPrefix)+len(*bodySuffix) >= sqsmigrate.MaxMessageBytes {
+		logger.Fatalf("Need to provide a -body-prefix and -body-suffix under %dKB together, leaving room for the body", sqsmigrate.MaxMessageBytes>>10)
+	}
+
+	if *compareBodies && (!*execute || !*noDelete) {
+		logger.Fatal("-compare-bodies checks a copy against its source, which needs -execute with -no-delete")
+	}
+	if !*compareBodies && (isFlagSet("compare-sample") || isFlagSet("compare-timeout")) {
+		logger.Fatal("-compare-sample and -compare-timeout only apply to -compare-bodies")
+	}
+	if *compareSample < 0 || *compareTimeout <= 0 || *compareTimeout > sqsmigrate.MaxVisibilityTimeout-sqsmigrate.CompareVisibilityMargin {
+		logger.Fatal("Need to provide a -compare-sample of 0 or more and a positive -compare-timeout under 12h")
+	}
+
+	if *assertEmpty && (!*execute || *noDelete) {
+		logger.Fatal("-assert-empty checks the sources were drained, which needs -execute without -no-delete")
+	}
+
+	if *accumulate >= *minVisibility {
+		logger.Fatal("Need to provide an -accumulate shorter than -min-visibility, or held messages would reappear on the source before being sent")
+	}
+	// A held message only gets its first heartbeat an interval after its send starts.
+	if *accumulate > 0 && *heartbeat {
+		interval, extend := *heartbeatInterval, *heartbeatExtend
+		if extend == 0 {
+			extend = *minVisibility
+		}
+		if interval == 0 {
+			interval = extend / 2
+		}
+		if *accumulate+interval >= *minVisibility {
+			logger.Fatalf("Need to provide an -accumulate under %s with -heartbeat, so held messages are extended before they reappear on the source", *minVisibility-interval)
+		}
+	}
+
+	if *concurrency < 1 || *maxConcurrency < 1 || *deleteConcurrency < 1 {
+		logger.Fatal("Need to provide a concurrency of at least 1")
+	}
+
+	switch *onEmptyBody {
+	case sqsmigrate.EmptyBodySkip, sqsmigrate.EmptyBodyError:
+	case sqsmigrate.EmptyBodySubstitute:
+		if *emptyPlaceholder == "" {
+			logger.Fatal("Need to provide a non-empty -empty-body-placeholder to substitute empty bodies")
+		}
+	default:
+		logger.Fatalf("Unknown -on-empty-body policy %q, expected skip, error or substitute", *onEmptyBody)
+	}
+
+	var waitSeconds *int32
+	if isFlagSet("wait-time") {
+		if *waitTime < 0 || *waitTime > sqsmigrate.MaxWaitTimeSeconds*time.Second || *waitTime%time.Second != 0 {
+			logger.Fatal("Need to provide a -wait-time of whole seconds no longer than 20s")
+		}
+		waitSeconds = aws.Int32(int32(*waitTime / time.Second))
+	}
+	if *pollDelay < 0 {
+		logger.Fatal("Need to provide a -poll-delay of at least 0")
+	}
+	// A long poll legitimately holds a receive open for up to 20s.
+	longPolling := *tail || *maxEmptyDuration > 0 || waitSeconds != nil && *waitSeconds > 0
+	if *httpTimeout < 0 || longPolling && *httpTimeout > 0 && *httpTimeout <= sqsmigrate.MaxWaitTimeSeconds*time.Second {
+		logger.Fatal("Need to provide a positive -http-timeout, longer than the 20s of a long poll with -tail, -max-empty-duration or -wait-time")
+	}
+
+	var delaySeconds *int32
+	if isFlagSet("delay") {
+		if *delay < 0 || *delay > sqsmigrate.MaxDelaySeconds*time.Second || *delay%time.Second != 0 {
+			logger.Fatal("Need to provide a -delay of whole seconds no longer than 15m")
+		}
+		delaySeconds = aws.Int32(int32(*delay / time.Second))
+	}
+
+	if *delayPerReceive < 0 || *delayPerReceive%time.Second != 0 {
+		logger.Fatal("Need to provide a -delay-per-receive of whole seconds")
+	}
+	if *delayPerReceive > 0 && sqsmigrate.IsFIFO(*dest) {
+		logger.Fatal("-delay-per-receive sets a per-message delay, which FIFO queues don't support")
+	}
+
+	if *onOversize != sqsmigrate.OversizeSkip && *onOversize != sqsmigrate.OversizeTruncate {
+		logger.Fatalf("Unknown -on-oversize policy %q, expected skip or truncate", *onOversize)
+	}
+
+	switch sqsmigrate.CompatMode(*compat) {
+	case sqsmigrate.CompatAWS, sqsmigrate.CompatElasticMQ, sqsmigrate.CompatLocalStack:
+	default:
+		logger.Fatalf("Unknown -compat mode %q, expected elasticmq or localstack", *compat)
 	}
 
-	sess := session.Must(session.NewSessionWithOptions(session.Options{SharedConfigState: session.SharedConfigEnable}))
-	sqsSvc := sqs.New(sess)
+	destSettings := destQueueSettings{
+		retention:   *destRetention,
+		visibility:  *destVisibility,
+		dlqARN:      *destDLQ,
+		maxReceives: *destMaxReceives,
+
+		fifo:            *destCreateFIFO,
+		contentDedup:    *destContentDedup,
+		dedupScope:      *destDedupScope,
+		throughputLimit: *destFIFOThroughput,
+	}
+	fifoSettings := *destCreateFIFO || *destContentDedup || *destDedupScope != "" || *destFIFOThroughput != ""
+	if fifoSettings && !*createDest {
+		logger.Fatal("-dest-create-fifo, -dest-content-dedup, -dest-dedup-scope and -dest-fifo-throughput only apply to -create-dest")
+	}
+	if fifoSettings && !sqsmigrate.IsFIFO(*dest) {
+		logger.Fatalf("Need to provide a -dest ending in .fifo to create a FIFO queue, not %s", *dest)
+	}
+	if _, err := destSettings.attributes(); err != nil {
+		logger.Fatal(err)
+	}
 
-	sourceQueueURL, err := sqsSvc.GetQueueUrl(&sqs.GetQueueUrlInput{QueueName: source})
+	dropPatterns, err := sqsmigrate.ParseFilters(*dropMatching, "")
 	if err != nil {
-		logger.Println("Encountered an error when attempting to identify the source queue")
 		logger.Fatal(err)
 	}
+	filters, err := sqsmigrate.ParseFilters(*filter, *filterFile)
+	if err != nil {
+		logger.Errorln("Encountered an error when attempting to read the filter file")
+		logger.Fatal(err)
+	}
+	filtersAll, _ := sqsmigrate.ParseFilters(*filterAll, "")
+	var filterPatterns []*regexp.Regexp
+	var filterFields []sqsmigrate.JSONFilter
+	switch *filterMode {
+	case sqsmigrate.FilterModeContains:
+	case sqsmigrate.FilterModeRegex, sqsmigrate.FilterModeJSONPath:
+		filters = nil
+		if filterPatterns, filterFields, err = sqsmigrate.CompileFilters(*filterMode, *filter, *filterFile); err != nil {
+			logger.Errorln("Encountered an error when attempting to parse -filter")
+			logger.Fatal(err)
+		}
+	default:
+		logger.Fatalf("Unknown -filter-mode %q, expected contains, regex or jsonpath", *filterMode)
+	}
+	var until *sqsmigrate.SkipUntil
+	if *skipUntilID != "" {
+		until = &sqsmigrate.SkipUntil{ID: *skipUntilID}
+	}
+	var skipIDs map[string]bool
+	if *skipIDsFile != "" {
+		if skipIDs, err = sqsmigrate.ReadSkipIDs(*skipIDsFile); err != nil {
+			logger.Errorln("Encountered an error when attempting to read the skip IDs file")
+			logger.Fatal(err)
+		}
+		logger.Printf("Leaving the %d MessageIds of %s on the source\n", len(skipIDs), *skipIDsFile)
+	}
+	var selection *sqsmigrate.HashModulo
+	if *hashModuloFlag != "" {
+		if selection, err = sqsmigrate.ParseHashModulo(*hashModuloFlag); err != nil {
+			logger.Fatal(err)
+		}
+	}
+	var filterRules sqsmigrate.FilterConfig
+	if *filterConfigPath != "" {
+		filterRules, err = sqsmigrate.LoadFilterConfig(*filterConfigPath)
+		if err != nil {
+			logger.Errorln("Encountered an error when attempting to read the filter config")
+			logger.Fatal(err)
+		}
+	}
 
-	if *dest != "" {
-		destQueueURL, err = sqsSvc.GetQueueUrl(&sqs.GetQueueUrlInput{QueueName: dest})
+	var transform *template.Template
+	if *transformTemplate != "" {
+		var err error
+		transform, err = sqsmigrate.ParseTransformTemplate(*transformTemplate)
+		if err != nil {
+			logger.Errorln("Encountered an error when attempting to parse the transform template")
+			logger.Fatal(err)
+		}
+	}
+	var events *sqsmigrate.EventStream
+	var stream io.Writer
+	if *streamEvents {
+		if *outputTemplate != "" || *batchReportPath == "-" || *format != sqsmigrate.SummaryText {
+			logger.Fatal("-stream-events has stdout to itself, it can't be combined with -output-template, -batch-report - or a -format other than text")
+		}
+		stream = os.Stdout
+	}
+	if *format != sqsmigrate.SummaryText && (*outputTemplate != "" || *batchReportPath == "-") {
+		logger.Fatalf("A -format %s summary has stdout to itself, it can't be combined with -output-template or -batch-report -", *format)
+	}
+	var ledger *sqsmigrate.OutcomeLedger
+	if *csvOutcome != "" {
+		ledger, err = sqsmigrate.OpenOutcomeLedger(*csvOutcome)
+		if err != nil {
+			logger.Errorln("Encountered an error when attempting to create the -csv-outcome file")
+			logger.Fatal(err)
+		}
+	}
+	if stream != nil || ledger != nil {
+		events = sqsmigrate.NewEventStream(stream, ledger, logger)
+	}
+	var output *sqsmigrate.MessageOutput
+	if *outputTemplate != "" {
+		var err error
+		output, err = sqsmigrate.ParseOutputTemplate(*outputTemplate, os.Stdout)
+		if err != nil {
+			logger.Errorln("Encountered an error when attempting to parse the output template")
+			logger.Fatal(err)
+		}
+	}
+	var execTransformer *sqsmigrate.ExecTransform
+	if *transformExec != "" {
+		if transform != nil {
+			logger.Fatal("Need to provide either -transform-template or -transform-exec, not both")
+		}
+		if *transformExecConcurrency < 1 || *transformExecTimeout <= 0 {
+			logger.Fatal("Need to provide a -transform-exec-concurrency of at least 1 and a positive -transform-exec-timeout")
+		}
+		execTransformer, err = sqsmigrate.NewExecTransform(*transformExec, *transformExecConcurrency, *transformExecTimeout)
+		if err != nil {
+			logger.Errorln("Encountered an error when attempting to find the -transform-exec command")
+			logger.Fatal(err)
+		}
+	}
+	var steps *sqsmigrate.Pipeline
+	if *pipelinePath != "" {
+		if transform != nil || execTransformer != nil || *unwrapSNS {
+			logger.Fatal("Need to provide the transforms as -pipeline steps, not alongside -transform-template, -transform-exec or -unwrap-sns")
+		}
+		if *transformExecConcurrency < 1 || *transformExecTimeout <= 0 {
+			logger.Fatal("Need to provide a -transform-exec-concurrency of at least 1 and a positive -transform-exec-timeout")
+		}
+		steps, err = sqsmigrate.LoadPipeline(*pipelinePath, *transformExecConcurrency, *transformExecTimeout)
+		if err != nil {
+			logger.Errorln("Encountered an error when attempting to load the pipeline")
+			logger.Fatal(err)
+		}
+	}
+	var plugins []sqsmigrate.TransformPlugin
+	if *pluginDir != "" {
+		plugins, err = sqsmigrate.LoadPlugins(*pluginDir)
+		if err != nil {
+			logger.Errorln("Encountered an error when attempting to load the plugins")
+			logger.Fatal(err)
+		}
+		for _, p := range plugins {
+			logger.Printf("Loaded plugin %s\n", p.Name)
+		}
+	}
+	if *onInvalidJSON != sqsmigrate.InvalidJSONSkip && *onInvalidJSON != sqsmigrate.InvalidJSONError {
+		logger.Fatalf("Unknown -on-invalid-json policy %q, expected skip or error", *onInvalidJSON)
+	}
+	if (*invalidDest != "" || isFlagSet("on-invalid-json")) && !*requireJSON {
+		logger.Fatal("-on-invalid-json and -invalid-dest only apply to -require-json")
+	}
+	if *invalidDest != "" && sqsmigrate.IsFIFO(*invalidDest) {
+		logger.Fatal("Need to provide a standard queue as -invalid-dest, its messages carry no MessageGroupId")
+	}
+	if *onTransformError != sqsmigrate.TransformErrorSkip && *onTransformError != sqsmigrate.TransformErrorFail {
+		logger.Fatalf("Unknown -on-transform-error policy %q, expected skip or error", *onTransformError)
+	}
+	if *showDiff > 0 && *execute {
+		logger.Println("-show-diff only applies to a Dry-Run, ignoring it")
+	}
+
+	var groupID *template.Template
+	if *groupIDTemplate != "" {
+		var err error
+		groupID, err = sqsmigrate.ParseGroupIDTemplate(*groupIDTemplate)
 		if err != nil {
-			logger.Println("Encountered an error when attempting to identify the dest queue")
+			logger.Errorln("Encountered an error when attempting to parse the group ID template")
+			logger.Fatal(err)
+		}
+	}
+	if !*copyAttributes && (len(renameAttributes) > 0 || len(dropAttributes) > 0) {
+		logger.Fatal("-rename-attr and -drop-attr change the copied attributes, which can't be combined with -copy-attributes=false")
+	}
+	var groupIDFromSource *sqsmigrate.GroupIDSource
+	if *groupIDFrom != "" || *staticGroupID != "" {
+		if groupID != nil {
+			logger.Fatal("-group-id-template remaps existing FIFO groups, which can't be combined with -group-id-from or -group-id")
+		}
+		groupIDFromSource, err = sqsmigrate.ParseGroupIDSource(*groupIDFrom, *staticGroupID)
+		if err != nil {
+			logger.Errorln("Encountered an error when attempting to parse -group-id-from")
 			logger.Fatal(err)
 		}
 	}
 
-	logger.Printf("Attempting to load messages less than %s from source queue of %s\n\n", *maxMessageAge, *source)
+	if *replayPath != "" && *replayPath == *errorFilePath {
+		logger.Fatal("Need to provide a different error file than the one being replayed")
+	}
 
-	count := 0
-	for {
-		curBatch := batchSize
-		left := *limit - count
-		if left <= 0 {
-			break
-		} else if left < batchSize {
-			curBatch = left
+	var errs *sqsmigrate.ErrorFile
+	if *errorFilePath != "" {
+		var err error
+		errs, err = sqsmigrate.OpenErrorFile(*errorFilePath)
+		if err != nil {
+			logger.Errorln("Encountered an error when attempting to open the error file")
+			logger.Fatal(err)
+		}
+		defer errs.Close()
+	}
+
+	// A JSON summary lists each batch and failed message, on top of the counts.
+	detailed := *format == sqsmigrate.SummaryJSON || *reportFile != ""
+	var batches *sqsmigrate.BatchReport
+	if *batchReportPath != "" || detailed {
+		var err error
+		batches, err = sqsmigrate.OpenBatchReport(*batchReportPath, detailed)
+		if err != nil {
+			logger.Errorln("Encountered an error when attempting to create the batch report")
+			logger.Fatal(err)
+		}
+		defer batches.Close()
+	}
+
+	var script *sqsmigrate.ScriptFile
+	if *emitScript != "" {
+		if *execute {
+			logger.Fatal("-emit-script only applies to a dry run, leave out -execute")
+		}
+		var err error
+		script, err = sqsmigrate.CreateScriptFile(*emitScript)
+		if err != nil {
+			logger.Errorln("Encountered an error when attempting to create the script")
+			logger.Fatal(err)
+		}
+		defer func() {
+			if err := script.Close(); err != nil {
+				logger.Errorf("Encountered an error when attempting to write the script: %s\n", err)
+			}
+		}()
+	}
+
+	var ids *sqsmigrate.IDFile
+	if *idsFilePath != "" {
+		var err error
+		ids, err = sqsmigrate.CreateIDFile(*idsFilePath)
+		if err != nil {
+			logger.Errorln("Encountered an error when attempting to create the ids file")
+			logger.Fatal(err)
+		}
+		defer func() {
+			if err := ids.Close(); err != nil {
+				logger.Errorf("Encountered an error when attempting to write the ids file: %s\n", err)
+			}
+		}()
+	}
+
+	// A queue URL names its region, which saves a NonExistentQueue error from looking for
+	// it in the shared config's region instead.
+	if *region == "" && len(sources) > 0 {
+		if inferred, ok := sqsmigrate.RegionFromQueueURL(sources[0]); ok {
+			*region = inferred
+			logger.Printf("Using region %s from the -source queue URL\n", inferred)
+		}
+	}
+	if *region != "" && !sqsmigrate.KnownRegion(*region) {
+		logger.Printf("Region %s is not named like an AWS region, assuming the standard endpoint pattern\n", *region)
+	}
+
+	slots := sqsmigrate.NewConcurrencyControllers(*concurrency, *concurrency, false, logger)
+	workers := *concurrency
+	if *adaptive {
+		slots = sqsmigrate.NewConcurrencyControllers(1, *maxConcurrency, true, logger)
+		workers = *maxConcurrency
+	}
+
+	// The SDK quietly skips shared files that don't exist, which would leave an
+	// explicitly given one unused without any sign of it.
+	for _, path := range []string{*credentialsFile, *configFile} {
+		if _, err := os.Stat(path); path != "" && err != nil {
+			logger.Fatal(err)
 		}
+	}
 
-		messagesToProcess := []*sqs.SendMessageBatchRequestEntry{}
-		idsToReceipts := make(map[string]*string)
-		queueReceipt, err := sqsSvc.ReceiveMessage(&sqs.ReceiveMessageInput{
-			QueueUrl:            sourceQueueURL.QueueUrl,
-			AttributeNames:      []*string{aws.String("SentTimestamp")},
-			MaxNumberOfMessages: aws.Int64(int64(curBatch)),
-			VisibilityTimeout:   aws.Int64(60),
+	ctx := context.Background()
+	calls := &sqsmigrate.APICalls{Max: *maxAPICalls}
+	// Explicit files replace the default locations rather than adding to them, so a
+	// stray ~/.aws on a CI runner can't leak into the run.
+	loadOpts := []func(*config.LoadOptions) error{}
+	if *credentialsFile != "" {
+		loadOpts = append(loadOpts, config.WithSharedCredentialsFiles([]string{*credentialsFile}))
+	}
+	if *configFile != "" {
+		loadOpts = append(loadOpts, config.WithSharedConfigFiles([]string{*configFile}))
+	}
+	if isFlagSet("sdk-max-retries") {
+		if *sdkMaxRetries < 0 {
+			logger.Fatal("Need to provide a -sdk-max-retries of 0 or more")
+		}
+		loadOpts = append(loadOpts, config.WithRetryMaxAttempts(*sdkMaxRetries+1))
+	}
+	if isFlagSet("retry-mode") {
+		mode, err := aws.ParseRetryMode(*retryMode)
+		if err != nil {
+			logger.Fatalf("Unknown -retry-mode %q, expected standard or adaptive", *retryMode)
+		}
+		loadOpts = append(loadOpts, config.WithRetryMode(mode))
+	}
+	if *httpTimeout > 0 {
+		client := awshttp.NewBuildableClient().WithTimeout(*httpTimeout).WithDialerOptions(func(d *net.Dialer) {
+			d.Timeout = *httpTimeout
+		}).WithTransportOptions(func(t *http.Transport) {
+			t.TLSHandshakeTimeout = *httpTimeout
 		})
+		loadOpts = append(loadOpts, config.WithHTTPClient(client))
+	}
+	// Receives and deletes always use the source's credentials, only sends and creating
+	// the destination use -dest-profile's.
+	sourceOpts := append([]func(*config.LoadOptions) error{config.WithRegion(*region)}, loadOpts...)
+	if *sourceProfile != "" && *credentialSource != "" && *credentialSource != credentialSourceShared {
+		logger.Fatal("-source-profile reads the shared config, it can only be combined with -credential-source shared")
+	}
+	if *sourceProfile != "" {
+		sourceOpts = append(sourceOpts, config.WithSharedConfigProfile(*sourceProfile))
+	} else if *credentialSource == credentialSourceShared {
+		// Naming a profile is what makes the SDK skip credentials in the environment.
+		profile := os.Getenv("AWS_PROFILE")
+		if profile == "" {
+			profile = "default"
+		}
+		sourceOpts = append(sourceOpts, config.WithSharedConfigProfile(profile))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, sourceOpts...)
+	if err != nil {
+		logger.Errorln("Encountered an error when attempting to load the AWS config")
+		logger.Fatal(err)
+	}
+	// Without a region the SDK only complains once the first request is made, with an
+	// error that doesn't say where a region should come from.
+	if cfg.Region == "" {
+		logger.Fatal("No region is configured, set -region or AWS_REGION, or a region for the profile in the shared config")
+	}
+	if *credentialSource != "" && *credentialSource != credentialSourceShared {
+		provider, err := credentialProvider(cfg, *credentialSource)
 		if err != nil {
-			logger.Println("Error encountered when attempting to make a request to get messages")
 			logger.Fatal(err)
 		}
-		if len(queueReceipt.Messages) == 0 {
-			break
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+	if *credentialSource != "" || *verbose {
+		creds, err := cfg.Credentials.Retrieve(ctx)
+		if err != nil && *credentialSource != "" {
+			logger.Errorf("Encountered an error when attempting to retrieve credentials from -credential-source %s\n", *credentialSource)
+			logger.Fatal(err)
+		}
+		if err == nil {
+			logger.Printf("Using credentials from %s\n", creds.Source)
+		}
+	}
+	// CloudWatch, STS and IAM calls aren't SQS calls, so they don't count towards
+	// -max-api-calls.
+	metricsCfg := cfg.Copy()
+	destAccountCfg := metricsCfg
+	calls.Watch(&cfg)
+	if *adaptive {
+		slots.Watch(&cfg)
+	}
+	sqsSvc := sqs.NewFromConfig(cfg)
+
+	if *destRegion == "" {
+		if inferred, ok := sqsmigrate.RegionFromQueueURL(*dest); ok && inferred != cfg.Region {
+			*destRegion = inferred
+			logger.Printf("Using region %s from the -dest queue URL\n", inferred)
+		}
+	}
+
+	// Sends go through their own client when the destination needs a different region
+	// or credentials, while receives and deletes stay on the source's.
+	destSvc := sqsSvc
+	if *destRegion != "" || *destProfile != "" {
+		destCfg := cfg.Copy()
+		if *destProfile != "" {
+			destCfg, err = config.LoadDefaultConfig(ctx, append(loadOpts, config.WithSharedConfigProfile(*destProfile), config.WithRegion(cfg.Region))...)
+			if err != nil {
+				logger.Errorln("Encountered an error when attempting to load the -dest-profile config")
+				logger.Fatal(err)
+			}
+			destAccountCfg = destCfg.Copy()
+			calls.Watch(&destCfg)
+			if *adaptive {
+				slots.Watch(&destCfg)
+			}
+		}
+		if *destRegion != "" {
+			destCfg.Region = *destRegion
+		}
+		destSvc = sqs.NewFromConfig(destCfg)
+
+		if !sqsmigrate.SamePartition(ctx, cfg.Region, destCfg.Region) && *destProfile == "" {
+			logger.Printf("The destination region %s is in a different partition from the source region %s, credentials are rarely valid in both so -dest-profile is probably needed\n", destCfg.Region, cfg.Region)
+		}
+	}
+
+	if *verifyChecksum {
+		destOptions := destSvc.Options()
+		destOptions.DisableMessageChecksumValidation = true
+		destSvc = sqs.New(destOptions)
+	}
+
+	// Queues picked by their tags, or by -redrive, are found before anything refers to
+	// them by name, and the checks that depend on the -dest being FIFO are made again
+	// once it is known.
+	if len(sourceTags) > 0 {
+		queueURL, err := resolveTaggedQueue(ctx, sqsSvc, sourceTags)
+		if err != nil {
+			logger.Errorln("Encountered an error when attempting to find the -source-tags queue")
+			logger.Fatal(err)
+		}
+		logger.Printf("Using %s as the source, tagged %s: %s\n", sqsmigrate.QueueName(queueURL), sourceTags, queueURL)
+		if *execute && !*yes && !sqsmigrate.Confirm(os.Stdin, os.Stderr, fmt.Sprintf("Migrate matching messages from %s?", sqsmigrate.QueueName(queueURL))) {
+			logger.Fatal("Aborted, no messages were migrated")
+		}
+		sources = append(sources, queueURL)
+	}
+	if len(destTags) > 0 {
+		queueURL, err := resolveTaggedQueue(ctx, destSvc, destTags)
+		if err != nil {
+			logger.Errorln("Encountered an error when attempting to find the -dest-tags queue")
+			logger.Fatal(err)
+		}
+		logger.Printf("Using %s as the dest, tagged %s: %s\n", sqsmigrate.QueueName(queueURL), destTags, queueURL)
+		if *execute && !*yes && !sqsmigrate.Confirm(os.Stdin, os.Stderr, fmt.Sprintf("Migrate matching messages into %s?", sqsmigrate.QueueName(queueURL))) {
+			logger.Fatal("Aborted, no messages were migrated")
+		}
+		*dest, destName = queueURL, sqsmigrate.QueueName(queueURL)
+	}
+	if *redrive && *dest == "" {
+		dlqURL, err := sqsmigrate.ResolveQueueURL(ctx, sqsSvc, sources[0])
+		if err != nil {
+			logger.Errorf("Encountered an error when attempting to identify the source queue %s\n", sources[0])
+			logger.Fatal(err)
+		}
+		queueURL, err := redriveSource(ctx, sqsSvc, dlqURL)
+		if err != nil {
+			logger.Errorln("Encountered an error when attempting to find the queue to redrive to")
+			logger.Fatal(err)
+		}
+		logger.Printf("Redriving %s back to %s: %s\n", sqsmigrate.QueueName(sources[0]), sqsmigrate.QueueName(queueURL), queueURL)
+		if *execute && !*yes && !sqsmigrate.Confirm(os.Stdin, os.Stderr, fmt.Sprintf("Redrive matching messages from %s back to %s?", sqsmigrate.QueueName(sources[0]), sqsmigrate.QueueName(queueURL))) {
+			logger.Fatal("Aborted, no messages were migrated")
+		}
+		*dest, destName = queueURL, sqsmigrate.QueueName(queueURL)
+	}
+	if destLater {
+		if !sqsmigrate.IsFIFO(*dest) && (*dedupFromBody || *dedupFrom != "" || *checkDedupIDs != "" || *fifoSequential) {
+			logger.Fatalf("-dedup-from-body, -dedup-from, -check-dedup-ids and -fifo-sequential only apply to a FIFO destination, which %s is not", destName)
+		}
+		if *delayPerReceive > 0 && sqsmigrate.IsFIFO(*dest) {
+			logger.Fatal("-delay-per-receive sets a per-message delay, which FIFO queues don't support")
+		}
+		for _, source := range sources {
+			if *execute && source == *dest && *destProfile == "" {
+				logger.Fatal("Need to provide different a different queue name for source and destination")
+			}
+		}
+	}
+
+	if *listQueueNames {
+		if err := sqsmigrate.WriteQueueList(ctx, sqsSvc, os.Stdout, *sourcePrefix); err != nil {
+			logger.Errorln("Encountered an error when attempting to list the queues")
+			logger.Fatal(err)
+		}
+		return
+	}
+
+	if *testSendFlag {
+		testQueueURL, err := sqsmigrate.ResolveQueueURL(ctx, destSvc, *dest)
+		if err != nil {
+			logger.Errorln("Encountered an error when attempting to identify the dest queue")
+			logger.Fatal(err)
+		}
+		if err := testSend(ctx, destSvc, logger, testQueueURL, sqsmigrate.IsFIFO(*dest), *testBody, setAttributes, *staticGroupID, *testDelete); err != nil {
+			logger.Errorln("Encountered an error when attempting to send the test message")
+			logger.Fatal(err)
+		}
+		return
+	}
+
+	// Every source is resolved up front so a typo in the last one doesn't surface after
+	// the others have already been migrated.
+	// A pair that can't be resolved is skipped rather than holding up the others.
+	sourceQueueURLs := make([]*string, len(sources))
+	skipped := make([]bool, len(sources))
+	for i, source := range sources {
+		sourceQueueURL, err := sqsmigrate.ResolveQueueURL(ctx, sqsSvc, source)
+		if err != nil && len(pairs) > 0 {
+			logger.Errorf("Skipping the pair %s, its source could not be identified: %s\n", pairs[i], err)
+			skipped[i] = true
+			continue
+		}
+		if err != nil {
+			logger.Errorf("Encountered an error when attempting to identify the source queue %s\n", source)
+			logger.Fatal(err)
+		}
+		sourceQueueURLs[i] = sourceQueueURL
+	}
+
+	if *inspectFlag {
+		if err := inspect(ctx, sqsSvc, os.Stdout, sourceQueueURLs[0]); err != nil {
+			logger.Errorln("Encountered an error when attempting to inspect a message")
+			logger.Fatal(err)
+		}
+		return
+	}
+
+	// The accounts are looked up once the sources are known to exist, so their
+	// confirmations can name them.
+	destLabel := destName
+	if aliases := newAccountAliases(*showAccountAlias); aliases != nil {
+		sourceAccount, err := aliases.lookup(ctx, "source", metricsCfg)
+		if err != nil {
+			logger.Errorln("Encountered an error when attempting to identify the account of the source credentials")
+			logger.Fatal(err)
+		}
+		destAccount := sourceAccount
+		if *destProfile != "" {
+			if destAccount, err = aliases.lookup(ctx, "dest", destAccountCfg); err != nil {
+				logger.Errorln("Encountered an error when attempting to identify the account of the -dest-profile credentials")
+				logger.Fatal(err)
+			}
+		}
+		for _, source := range sources {
+			logger.Printf("Source %s\n", aliases.describeQueue(source, sourceAccount.id))
+		}
+		if *sourcePrefix != "" {
+			logger.Printf("Source queues matching %s in %s\n", *sourcePrefix, aliases.label(sourceAccount.id))
+		}
+		if *dest != "" {
+			destLabel = aliases.describeQueue(*dest, destAccount.id)
+			logger.Printf("Destination %s\n", destLabel)
+		}
+		if *failedDest != "" {
+			logger.Printf("Failed messages go to %s\n", aliases.describeQueue(*failedDest, destAccount.id))
+		}
+	}
+
+	if *sourcePrefix != "" {
+		discovered, err := sqsmigrate.ListQueues(ctx, sqsSvc, *sourcePrefix)
+		if err != nil {
+			logger.Errorln("Encountered an error when attempting to list the source queues")
+			logger.Fatal(err)
+		}
+		found := 0
+		if len(queueFilters) > 0 {
+			logger.Printf("Queues matching the source prefix %s and -queue-filter %s:\n", *sourcePrefix, queueFilters.String())
+		} else {
+			logger.Printf("Queues matching the source prefix %s:\n", *sourcePrefix)
 		}
-		for _, message := range queueReceipt.Messages {
-			sentTimestamp, _ := strconv.ParseInt(*message.Attributes["SentTimestamp"], 10, 64)
-			timeSent := time.Unix(sentTimestamp/1000, 0)
-			hoursSince := runTime.Sub(timeSent)
-			if hoursSince < *maxMessageAge && strings.Contains(*message.Body, *filter) {
-				count++
-				logger.Printf("Staging message Age: %s ID: %s Receipt: %s\n", runTime.Sub(timeSent), *message.MessageId, (*message.ReceiptHandle)[:15])
-				if *verbose {
-					logger.Printf("%s - %s\n", *message.MessageId, *message.Body)
+		for _, queueURL := range discovered {
+			name := path.Base(queueURL)
+			if *dest != "" && name == sqsmigrate.QueueName(*dest) {
+				continue
+			}
+			if len(queueFilters) > 0 {
+				attributes, err := sqsmigrate.QueueAttributes(ctx, sqsSvc, aws.String(queueURL))
+				if err != nil {
+					logger.Errorf("Encountered an error when attempting to read the attributes of %s\n", name)
+					logger.Fatal(err)
 				}
-				messagesToProcess = append(messagesToProcess, &sqs.SendMessageBatchRequestEntry{
-					Id:          message.MessageId,
-					MessageBody: message.Body,
-				})
-				idsToReceipts[*message.MessageId] = message.ReceiptHandle
+				if !queueFilters.Matches(attributes) {
+					continue
+				}
+			}
+			logger.Printf("    %s\n", name)
+			sources = append(sources, name)
+			sourceQueueURLs = append(sourceQueueURLs, aws.String(queueURL))
+			skipped = append(skipped, false)
+			found++
+		}
+		if found == 0 && len(queueFilters) > 0 {
+			logger.Fatalf("No queues other than the destination start with %s and match every -queue-filter", *sourcePrefix)
+		}
+		if found == 0 {
+			logger.Fatalf("No queues other than the destination start with %s", *sourcePrefix)
+		}
+		if *execute && !*yes && !sqsmigrate.Confirm(os.Stdin, os.Stderr, fmt.Sprintf("Migrate matching messages from these %d queues into %s?", found, destLabel)) {
+			logger.Fatal("Aborted, no messages were migrated")
+		}
+	}
+
+	if *detectDLQ || *includeDLQ {
+		dlqs, err := findDeadLetterQueues(ctx, sqsSvc, logger, sources, sourceQueueURLs, skipped, *dest)
+		if err != nil {
+			logger.Errorln("Encountered an error when attempting to find the dead-letter queues of the sources")
+			logger.Fatal(err)
+		}
+		if *includeDLQ && len(dlqs) > 0 {
+			question := fmt.Sprintf("Also migrate matching messages from %d dead-letter queues into %s, removing them from there?", len(dlqs), destLabel)
+			if *execute && !*yes && !sqsmigrate.Confirm(os.Stdin, os.Stderr, question) {
+				logger.Fatal("Aborted, no messages were migrated")
+			}
+			for _, dlq := range dlqs {
+				logger.Printf("Including the dead-letter queue %s\n", dlq.name)
+				sources = append(sources, dlq.name)
+				sourceQueueURLs = append(sourceQueueURLs, dlq.url)
+				skipped = append(skipped, false)
+			}
+		}
+	}
+
+	if *dest != "" {
+		destQueueURL, err = sqsmigrate.ResolveQueueURL(ctx, destSvc, *dest)
+		if err != nil && *createDest && isQueueMissing(err) {
+			if *execute {
+				logger.Printf("Destination queue %s does not exist, creating it\n", *dest)
+				destQueueURL, err = createDestQueue(ctx, sqsSvc, destSvc, sqsmigrate.QueueName(*dest), sourceQueueURLs[0], destSettings)
+			} else {
+				logger.Printf("Destination queue %s does not exist, it would be created on -execute\n", *dest)
+				err = nil
+			}
+		}
+		if err != nil {
+			logger.Errorln("Encountered an error when attempting to identify the dest queue")
+			logger.Fatal(err)
+		}
+	}
+
+	var ageFromSource *sqsmigrate.AgeSource
+	if *ageFrom != "" {
+		if ageFromSource, err = sqsmigrate.ParseAgeSource(*ageFrom, *ageFormat); err != nil {
+			logger.Errorln("Encountered an error when attempting to parse -age-from")
+			logger.Fatal(err)
+		}
+	}
+
+	if *maxRetries < 0 {
+		logger.Fatal("Need to provide a -max-retries of 0 or more")
+	}
+	if *batchTimeout < 0 {
+		logger.Fatal("Need to provide a -batch-timeout of 0 or more")
+	}
+	if *partialRetryDelay < 0 || *partialRetryDelay > 0 && *maxRetries == 0 {
+		logger.Fatal("Need to provide a -partial-retry-delay of 0 or more, and a -max-retries to wait between")
+	}
+	if backoff := sqsmigrate.RetryBackoff(*partialRetryDelay, *maxRetries, *minVisibility); backoff >= *minVisibility && !*heartbeat {
+		logger.Printf("Warning: retrying failed sends can wait %s or more, past the -min-visibility of %s, so a batch may be received again before it is deleted.  Use -heartbeat or a longer -min-visibility\n", backoff, *minVisibility)
+	}
+	if *sendFailureThreshold < 0 || *sendFailureThreshold > 0 && *failedDest == "" {
+		logger.Fatal("Need to provide a -send-failure-threshold of 0 or more, and a -failed-dest to move the messages to")
+	}
+	var failedDestURL *string
+	if *failedDest != "" {
+		if *noDelete {
+			logger.Fatal("-failed-dest removes messages from the source, which can't be combined with -no-delete")
+		}
+		failedDestURL, err = sqsmigrate.ResolveQueueURL(ctx, destSvc, *failedDest)
+		if err != nil {
+			logger.Errorln("Encountered an error when attempting to identify the -failed-dest queue")
+			logger.Fatal(err)
+		}
+	}
+
+	var routes *sqsmigrate.Router
+	if *destPrefix != "" || len(filterRules.Destinations()) > 0 || *invalidDest != "" {
+		routes, err = sqsmigrate.NewRouter(ctx, destSvc, *destPrefix, *routeBy, sourceQueueURLs)
+		if err != nil {
+			logger.Errorln("Encountered an error when attempting to parse -route-by")
+			logger.Fatal(err)
+		}
+		if err := routes.ResolveAll(filterRules.Destinations()); err != nil {
+			logger.Errorln("Encountered an error when attempting to identify a -filter-config route queue")
+			logger.Fatal(err)
+		}
+		if *invalidDest != "" {
+			if err := routes.ResolveAll([]string{*invalidDest}); err != nil {
+				logger.Errorln("Encountered an error when attempting to identify the -invalid-dest queue")
+				logger.Fatal(err)
 			}
 		}
+	}
+
+	destQueueURLs := make([]*string, len(sources))
+	destNames := make([]string, len(sources))
+	for i := range sources {
+		destQueueURLs[i], destNames[i] = destQueueURL, destName
+	}
+	for i, pair := range pairs {
+		if skipped[i] {
+			continue
+		}
+		destNames[i] = pair.dest
+		destQueueURLs[i], err = sqsmigrate.ResolveQueueURL(ctx, destSvc, pair.dest)
+		if err != nil {
+			logger.Errorf("Skipping the pair %s, its destination could not be identified: %s\n", pair, err)
+			skipped[i] = true
+		}
+	}
 
-		if len(messagesToProcess) > 0 {
-			if !*execute {
-				logger.Printf("In Dry-Run mode.  This batch would have attempted to process %d messages\n", len(messagesToProcess))
+	significant := 0
+	destAttributes := map[string]map[string]string{}
+	for i, sourceQueueURL := range sourceQueueURLs {
+		if skipped[i] || destQueueURLs[i] == nil {
+			continue
+		}
+		if _, ok := destAttributes[*destQueueURLs[i]]; !ok {
+			attributes, err := sqsmigrate.QueueAttributes(ctx, destSvc, destQueueURLs[i])
+			if err != nil {
+				logger.Errorln("Encountered an error when attempting to read the attributes of the dest queue")
+				logger.Fatal(err)
+			}
+			destAttributes[*destQueueURLs[i]] = attributes
+		}
+		sourceAttributes, err := sqsmigrate.QueueAttributes(ctx, sqsSvc, sourceQueueURL)
+		if err != nil {
+			logger.Errorln("Encountered an error when attempting to read the attributes of a source queue")
+			logger.Fatal(err)
+		}
+		for _, difference := range diffQueues(sourceAttributes, destAttributes[*destQueueURLs[i]]) {
+			if difference.significant {
+				significant++
+				logger.Printf("Warning: %s: %s\n", sources[i], difference)
+			} else {
+				logger.Printf("%s: %s\n", sources[i], difference)
+			}
+		}
+	}
+	if significant > 0 && *strictAttributes {
+		logger.Fatalf("The destination falls short of the source in %d attributes (-strict-attributes), nothing was migrated", significant)
+	}
+
+	// Messages from a FIFO source keep their group into a FIFO dest unless it is set
+	// some other way.
+	carryFIFO := sqsmigrate.IsFIFO(*dest) && groupID == nil && groupIDFromSource == nil
+	var dedupScope string
+	var dedupIDs *sqsmigrate.DedupTracker
+	if destQueueURL != nil && sqsmigrate.IsFIFO(*dest) {
+		settings, err := sqsmigrate.FIFOQueueSettings(ctx, destSvc, destQueueURL)
+		if err != nil {
+			logger.Errorln("Encountered an error when attempting to read the deduplication settings of the dest queue")
+			logger.Fatal(err)
+		}
+		dedupScope = settings.DedupScope
+		dedupIDs = sqsmigrate.NewDedupTracker(*checkDedupIDs != "", settings)
+		sourceScopes := []string{}
+		for i, source := range sources {
+			if !sqsmigrate.IsFIFO(source) {
 				continue
 			}
-			resp, err := sqsSvc.SendMessageBatch(&sqs.SendMessageBatchInput{
-				QueueUrl: destQueueURL.QueueUrl,
-				Entries:  messagesToProcess,
-			})
+			sourceSettings, err := sqsmigrate.FIFOQueueSettings(ctx, sqsSvc, sourceQueueURLs[i])
 			if err != nil {
-				logger.Printf("Error attempting to batch migrate messages to SQS")
+				logger.Errorln("Encountered an error when attempting to read the deduplication settings of a source queue")
 				logger.Fatal(err)
 			}
+			sourceScopes = append(sourceScopes, sourceSettings.DedupScope)
+		}
+		oneGroup := *staticGroupID != "" && *groupIDFrom == ""
+		carried := (groupID != nil || carryFIFO) && *copyDedupID && len(sourceScopes) > 0
+		for _, conflict := range sqsmigrate.DedupConflicts(settings, *dedupFromBody || *dedupFrom != "", carried, sourceScopes, oneGroup) {
+			logger.Printf("Warning: %s\n", conflict)
+		}
+	}
+
+	if *probeQueues && len(pairs) > 0 {
+		failed := 0
+		for i, pair := range pairs {
+			if skipped[i] {
+				failed++
+				continue
+			}
+			if err := probe(ctx, sqsSvc, destSvc, logger, sourceQueueURLs[i:i+1], destQueueURLs[i], !*noDelete); err != nil {
+				logger.Errorf("Pair %s: %s\n", pair, err)
+				failed++
+			}
+		}
+		if failed > 0 {
+			logger.Fatalf("%d of %d pairs can't be migrated", failed, len(pairs))
+		}
+		return
+	}
+	if *probeQueues {
+		if err := probe(ctx, sqsSvc, destSvc, logger, sourceQueueURLs, destQueueURL, !*noDelete); err != nil {
+			logger.Fatal(err)
+		}
+		return
+	}
+
+	// Nothing is deleted from a source unless the destination has been resolved, which
+	// across partitions is the first time the destination credentials are used.
+	if *execute && destQueueURL == nil && *destPrefix == "" && len(pairs) == 0 {
+		logger.Fatal("The destination queue could not be resolved, nothing was migrated")
+	}
 
-			for _, failedMigration := range resp.Failed {
-				logger.Printf("err with %s - %s", *failedMigration.Id, *failedMigration.Message)
+	if *execute && !*skipPreflight && len(pairs) > 0 {
+		for i, pair := range pairs {
+			if skipped[i] {
+				continue
 			}
+			if err := preflight(ctx, sqsSvc, destSvc, sourceQueueURLs[i:i+1], destQueueURLs[i], !*noDelete); err != nil {
+				logger.Errorf("Skipping the pair %s: %s\n", pair, err)
+				skipped[i] = true
+			}
+		}
+	} else if *execute && !*skipPreflight {
+		if err := preflight(ctx, sqsSvc, destSvc, sourceQueueURLs, destQueueURL, !*noDelete); err != nil {
+			logger.Fatal(err)
+		}
+	}
 
-			logger.Println("\nCompleted transfering messages for this batch, resulting in: ")
-			logger.Printf("    Successes: %d\n", len(resp.Successful))
-			logger.Printf("    Failed: %d\n", len(resp.Failed))
+	if *replayPath != "" {
+		records, err := sqsmigrate.ReadErrorFile(*replayPath)
+		if err != nil {
+			logger.Errorln("Encountered an error when attempting to read the error file to replay")
+			logger.Fatal(err)
+		}
+		if duplicates := sqsmigrate.ReplayErrors(ctx, sqsSvc, destSvc, logger, records, sourceQueueURLs[0], destQueueURL, *execute, errs); duplicates > 0 {
+			os.Exit(exitReplayDuplicates)
+		}
+		return
+	}
 
-			logger.Println("\nRemoving messages from source queue")
-			messagesToDelete := []*sqs.DeleteMessageBatchRequestEntry{}
-			for _, successfullyMigrated := range resp.Successful {
-				logger.Printf("Staging for removal ID: %s Message ID: %s Receipt: %s\n", *successfullyMigrated.Id, *successfullyMigrated.MessageId, (*idsToReceipts[*successfullyMigrated.Id])[:15])
-				messagesToDelete = append(messagesToDelete, &sqs.DeleteMessageBatchRequestEntry{
-					Id:            successfullyMigrated.Id,
-					ReceiptHandle: idsToReceipts[*successfullyMigrated.Id],
-				})
+	if *requireMin > 0 {
+		available := 0
+		for i, sourceQueueURL := range sourceQueueURLs {
+			if skipped[i] {
+				continue
 			}
-			deletionResp, err := sqsSvc.DeleteMessageBatch(&sqs.DeleteMessageBatchInput{
-				QueueUrl: sourceQueueURL.QueueUrl,
-				Entries:  messagesToDelete,
-			})
+			n, err := sqsmigrate.ApproximateMessages(ctx, sqsSvc, sourceQueueURL)
 			if err != nil {
-				logger.Println("Error encountered while attempting to cleanup batch of records")
+				logger.Errorf("Encountered an error when attempting to count the messages on %s\n", *sourceQueueURL)
 				logger.Fatal(err)
 			}
+			available += n
+		}
+		if available < *requireMin {
+			message := fmt.Sprintf("The sources hold roughly %d messages, fewer than the -require-min of %d, nothing was migrated", available, *requireMin)
+			logger.Errorln(message)
+			notifier.Failed(exitTooFewMessages, message)
+			os.Exit(exitTooFewMessages)
+		}
+	}
+
+	remaining := *limit
+	if *all {
+		remaining = math.MaxInt32
+	}
+	if *dryRunSample > 0 {
+		if *execute {
+			logger.Fatal("-dry-run-sample previews the messages a migration would take, which can't be combined with -execute")
+		}
+		remaining = *dryRunSample
+	}
+	if *stats && (*execute || *dryRunSample > 0 || *newestFirst) {
+		logger.Fatal("-stats scans the source without migrating anything, which can't be combined with -execute, -dry-run-sample or -newest-first")
+	}
+	if *rate < 0 {
+		logger.Fatal("Need to provide a -rate of 0 or more")
+	}
+	paced := sqsmigrate.NewRateLimit(*rate)
+
+	var approval *sqsmigrate.Approver
+	if *interactive {
+		if *yes || *newestFirst {
+			logger.Fatal("-interactive asks about every message, which can't be combined with -yes or -newest-first")
+		}
+		if *parallelQueues > 1 {
+			logger.Fatal("-interactive asks about every message, which can't be combined with -queue-concurrency")
+		}
+		approval = sqsmigrate.NewApprover(os.Stdin, os.Stderr)
+		workers = 1
+	}
 
-			logger.Println("\nCompleted removal of messages messages for this batch, resulting in: ")
-			logger.Printf("    Successful Removals: %d\n", len(deletionResp.Successful))
-			logger.Printf("    Failed Removals: %d\n", len(deletionResp.Failed))
+	if *tail {
+		if *once || *newestFirst || *interactive || len(sourceQueueURLs) > 1 {
+			logger.Fatal("-tail keeps migrating a single source until interrupted, which can't be combined with several sources, -once, -newest-first or -interactive")
 		}
+		remaining = math.MaxInt32
 	}
-	logger.Printf("Processed %d messages in total", count)
+	if *exitOnIdle < 0 || *exitOnIdle > 0 && !*tail {
+		logger.Fatal("-exit-on-idle only applies to -tail, and needs a positive duration")
+	}
+	if *timeout < 0 {
+		logger.Fatal("-timeout needs a positive duration, or 0 for no limit")
+	}
+	// An interrupt finishes the batches in hand rather than exiting, so nothing sent is
+	// left undeleted and the summary and final metrics flush still cover them.
+	interrupted := sqsmigrate.WatchInterrupt(ctx, logger, *timeout)
+	events.StopOn(interrupted)
+
+	if *once {
+		if *newestFirst {
+			logger.Fatal("-once processes a single batch, which can't be combined with -newest-first")
+		}
+		remaining = sqsmigrate.BatchSize
+		workers = 1
+		*maxEmptyDuration = 0
+	}
+
+	if *fifoSequential && workers > 1 {
+		logger.Println("-fifo-sequential keeps batches in order with a single worker, ignoring -concurrency")
+		workers = 1
+	}
+
+	if *newestFirst && (workers > 1 || *maxInFlight > 0) {
+		logger.Println("-newest-first scans the source with a single worker, ignoring -concurrency and -max-in-flight")
+		workers = 1
+		*maxInFlight = 0
+	}
+
+	var batchCap *sqsmigrate.BatchLimit
+	if *maxInFlightBatches < 0 {
+		logger.Fatal("Need to provide a -max-in-flight-batches of 0 or more")
+	}
+	if *maxInFlightBatches > 0 {
+		batchCap = sqsmigrate.NewBatchLimit(*maxInFlightBatches)
+	}
+	var holdCap *sqsmigrate.InFlight
+	if *maxInFlight > 0 {
+		holdCap = sqsmigrate.NewInFlight(*maxInFlight)
+	}
+
+	// The budget, API call count and in-flight cap are shared so -limit, -max-api-calls
+	// and -max-in-flight apply to the run as a whole rather than to each source.
+	shared := sqsmigrate.NewBudget(remaining)
+	received := sqsmigrate.NewMessageIDs()
+	progress := sqsmigrate.NewRunProgress(shared, batchCap)
+	notifier.Track(sources, destName, progress)
+	sqsmigrate.WatchProgressSignal(logger, progress)
+	paused := sqsmigrate.WatchPauseSignal(logger)
+	var backpressure *sqsmigrate.Pauser
+	if *destMaxDepth > 0 && *execute {
+		watched := []*string{}
+		seen := map[string]bool{}
+		for i, destQueueURL := range destQueueURLs {
+			if skipped[i] || destQueueURL == nil || seen[*destQueueURL] {
+				continue
+			}
+			seen[*destQueueURL] = true
+			watched = append(watched, destQueueURL)
+		}
+		backpressure = sqsmigrate.WatchDestDepth(ctx, destSvc, logger, watched, *destMaxDepth, *destResumeDepth, *destDepthInterval)
+	}
+	compared := sqsmigrate.NewSentBodies(*compareBodies)
+	var published *sqsmigrate.MetricsPublisher
+	if *cloudWatchNamespace != "" {
+		published = sqsmigrate.StartMetricsPublisher(cloudwatch.NewFromConfig(metricsCfg), *cloudWatchNamespace, logger, progress, *metricsFlushInterval)
+	}
+	var saved *sqsmigrate.Checkpointer
+	if *checkpointFile != "" {
+		saved, err = sqsmigrate.OpenCheckpoint(*checkpointFile, logger, progress)
+		if err != nil {
+			logger.Errorln("Encountered an error when attempting to load the checkpoint file")
+			logger.Fatal(err)
+		}
+		received = saved.IDs
+	}
+	if *dedupeWindow > 0 {
+		received.ForgetAfter(*dedupeWindow)
+	}
+	// Sources are migrated one after the other, unless -queue-concurrency lets several
+	// run at once.  The -limit budget and -max-api-calls count are shared by every
+	// worker of every source.
+	sourceResults := make([]*sqsmigrate.Summary, len(sources))
+	running := make(chan struct{}, *parallelQueues)
+	var pairsRunning sync.WaitGroup
+	for i, source := range sources {
+		if skipped[i] {
+			continue
+		}
+		running <- struct{}{}
+		if *once && i > 0 {
+			logger.Printf("Processed one batch, skipping the remaining %d source queues\n", len(sources)-i)
+			break
+		}
+		if shared.Exhausted() {
+			logger.Printf("Reached the limit, skipping the remaining %d source queues\n", len(sources)-i)
+			break
+		}
+		if *maxMessageAge > 0 {
+			logger.Printf("Attempting to load messages less than %s from source queue of %s\n\n", *maxMessageAge, source)
+		} else {
+			logger.Printf("Attempting to load messages of any age from source queue of %s\n\n", source)
+		}
+		opts := sqsmigrate.Options{
+			DestSvc:                destSvc,
+			Logger:                 logger,
+			SourceQueueURL:         sourceQueueURLs[i],
+			DestQueueURL:           destQueueURLs[i],
+			Routes:                 routes,
+			MaxRetries:             *maxRetries,
+			PartialRetryDelay:      *partialRetryDelay,
+			BatchTimeout:           *batchTimeout,
+			FailedDestURL:          failedDestURL,
+			SendFailureThreshold:   *sendFailureThreshold,
+			FailedDestFIFO:         sqsmigrate.IsFIFO(*failedDest),
+			Sample:                 *dryRunSample > 0,
+			FetchAttributes:        receiveMessageAttributes,
+			ReceiveAttributes:      receiveAttributes,
+			FIFOSequential:         *fifoSequential,
+			VerifyChecksum:         *verifyChecksum,
+			Errs:                   errs,
+			BatchReport:            batches,
+			Output:                 output,
+			Script:                 script,
+			ContinueOnError:        *continueOnError,
+			ListFailures:           detailed,
+			RequireJSON:            *requireJSON,
+			OnInvalidJSON:          *onInvalidJSON,
+			InvalidDest:            *invalidDest,
+			Events:                 events,
+			IDs:                    ids,
+			Execute:                *execute,
+			MaxMessageAge:          *maxMessageAge,
+			DropMatching:           dropPatterns,
+			Filters:                filters,
+			FilterPatterns:         filterPatterns,
+			FilterFields:           filterFields,
+			FiltersAll:             filtersAll,
+			FilterConfig:           filterRules,
+			HashModulo:             selection,
+			SkipUntil:              until,
+			SkipIDs:                skipIDs,
+			AttrFilters:            attrFilters,
+			JSONFilters:            jsonFilters,
+			Received:               received,
+			SkipDuplicateIDs:       *skipDuplicateIDs,
+			ForceText:              *forceText,
+			Compat:                 sqsmigrate.CompatMode(*compat),
+			SenderID:               *senderID,
+			MinBodyBytes:           *minBodyBytes,
+			MaxBodyBytes:           *maxBodyBytes,
+			SizeIncludesAttributes: *sizeIncludesAttributes,
+			Verbose:                *verbose,
+			Histogram:              *histogram,
+			Stats:                  *stats,
+			Rate:                   paced,
+			TTL:                    *ttl,
+			SLAAge:                 *slaAge,
+			RunTime:                runTime,
+			Workers:                workers,
+			Budget:                 shared,
+			ExitOnIdle:             *exitOnIdle,
+			Tail:                   *tail,
+			Backpressure:           backpressure,
+			Paused:                 paused,
+			Interrupted:            interrupted,
+			WaitTime:               waitSeconds,
+			PollDelay:              *pollDelay,
+			AgeFrom:                ageFromSource,
+			MaxEmptyDuration:       *maxEmptyDuration,
+			NewestFirst:            *newestFirst,
+			Heartbeat:              *heartbeat,
+			HeartbeatInterval:      *heartbeatInterval,
+			HeartbeatExtend:        *heartbeatExtend,
+			MinVisibility:          *minVisibility,
+			MaxVisibility:          *maxVisibility,
+			ReleaseNonmatching:     *releaseNonmatching,
+			SentBodies:             compared,
+			NoDelete:               *noDelete,
+			Approval:               approval,
+			Once:                   *once,
+			BatchDelay:             *batchDelay,
+			Calls:                  calls,
+			Slots:                  slots.Next(),
+			Accumulate:             *accumulate,
+			DeleteConcurrency:      *deleteConcurrency,
+			BatchSlots:             batchCap,
+			InFlight:               holdCap,
+			Delay:                  delaySeconds,
+			DelayPerReceive:        *delayPerReceive,
+			PreserveDelay:          *preserveDelay,
+			PreserveTimestamp:      *preserveTimestamp,
+			Transform:              transform,
+			TransformExec:          execTransformer,
+			OnTransformError:       *onTransformError,
+			Pipeline:               steps,
+			Plugins:                plugins,
+			CompressOver:           *compressOver,
+			BodyPrefix:             *bodyPrefix,
+			BodySuffix:             *bodySuffix,
+			ShowDiff:               *showDiff,
+			EmptyBody:              *onEmptyBody,
+			EmptyPlaceholder:       *emptyPlaceholder,
+			OnOversize:             *onOversize,
+			CopyAttributes:         *copyAttributes,
+			CarryGroupID:           carryFIFO && sqsmigrate.IsFIFO(source),
+			CarryDedupID:           *copyDedupID,
+			SetAttributes:          setAttributes,
+			RenameAttributes:       renameAttributes,
+			DropAttributes:         dropAttributes,
+			UnwrapSNS:              *unwrapSNS,
+			PromoteSNSAttributes:   *promoteSNSAttributes,
+			SendUnwrapped:          *sendUnwrapped,
+			GroupID:                groupID,
+			GroupIDFrom:            groupIDFromSource,
+			DedupFromBody:          *dedupFromBody,
+			DedupFrom:              dedupFromPath,
+			DedupScope:             dedupScope,
+			DedupIDs:               dedupIDs,
+			FailOnDedupCollision:   *checkDedupIDs == sqsmigrate.DedupCheckError,
+			SourceName:             sqsmigrate.QueueName(source),
+			ProgressReport:         progress,
+			PricePerMillion:        *pricePerMillion,
+		}
+		pairsRunning.Add(1)
+		go func(i int, source string) {
+			defer pairsRunning.Done()
+			// An error is reported through interrupted once every source has stopped.
+			result, _ := sqsmigrate.Migrate(ctx, sqsSvc, opts)
+			result.Source, result.Dest = source, destNames[i]
+			sourceResults[i] = &result
+			<-running
+		}(i, source)
+	}
+	pairsRunning.Wait()
+	interrupted.Finish()
+	if err := events.Close(); err != nil {
+		logger.Errorln("Encountered an error when attempting to write the -csv-outcome file")
+		logger.Fatal(err)
+	}
+	results := []sqsmigrate.Summary{}
+	for _, result := range sourceResults {
+		if result != nil {
+			results = append(results, *result)
+		}
+	}
+
+	if len(results) > 1 && *format == sqsmigrate.SummaryText {
+		for _, r := range results {
+			if len(pairs) > 0 {
+				logger.Printf("\nSummary for the pair %s=%s:\n", r.Source, r.Dest)
+			} else {
+				logger.Printf("\nSummary for source queue %s:\n", r.Source)
+			}
+			r.Print(logger, *onEmptyBody)
+		}
+		logger.Printf("\nTotal across %d source queues:\n", len(results))
+	}
+	combined := sqsmigrate.CombineSummaries(results, calls.Made(), time.Since(runTime))
+	combined.StoppedBy = interrupted.StoppedBy()
+	published.Close(ctx, combined)
+	result := saved.Close(combined)
+	result.EstimateCost(*pricePerMillion)
+	result.Batches, result.BatchesOmitted = batches.Records()
+	switch *format {
+	case sqsmigrate.SummaryJSON:
+		if err := result.WriteJSON(os.Stdout); err != nil {
+			logger.Fatal(err)
+		}
+	case sqsmigrate.SummaryPrometheus:
+		result.WritePrometheus(os.Stdout)
+	default:
+		result.Print(logger, *onEmptyBody)
+	}
+	if *reportFile != "" {
+		if err := result.WriteReport(*reportFile); err != nil {
+			logger.Errorln("Encountered an error when attempting to write the report file")
+			logger.Fatal(err)
+		}
+	}
+
+	// A run stopped by an error has drained its deletes and printed its summary, only
+	// now does it fail.
+	if err := interrupted.Err(); err != nil {
+		notifier.Finished(result, 1)
+		logger.Errorf("Stopped by an error: %s\n", err)
+		os.Exit(1)
+	}
+
+	// Interrupting a -tail is how it normally ends, any other run stopped early exits
+	// without the checks that expect it to have finished.
+	if result.StoppedBy != "" && !*tail {
+		notifier.Finished(result, exitInterrupted)
+		os.Exit(exitInterrupted)
+	}
+
+	if !until.Found() {
+		logger.Printf("Warning: -skip-until-id %s was never received, so nothing was migrated\n", *skipUntilID)
+	}
+
+	if compared != nil {
+		missing := 0
+		for _, queueURL := range compared.Queues() {
+			c, err := compared.Compare(ctx, destSvc, logger, queueURL, *compareSample, *compareTimeout)
+			if err != nil {
+				logger.Errorf("Encountered an error when attempting to compare the bodies on %s\n", queueURL)
+				logger.Fatal(err)
+			}
+			logger.Printf("Compared %d messages received from %s: %d matched, %d sent bodies not found, %d unexpected\n", c.Received, sqsmigrate.QueueName(queueURL), c.Matched, c.Missing, c.Unexpected)
+			if c.Missing > 0 && *compareSample > 0 && c.Received >= *compareSample {
+				logger.Printf("Warning: stopped at the -compare-sample of %d, the missing bodies may be further back on %s\n", *compareSample, sqsmigrate.QueueName(queueURL))
+			}
+			missing += c.Missing
+		}
+		if missing > 0 {
+			logger.Errorf("%d sent bodies were not found on their destination (-compare-bodies)\n", missing)
+			notifier.Finished(result, sqsmigrate.ExitBodiesMissing)
+			os.Exit(sqsmigrate.ExitBodiesMissing)
+		}
+	}
+
+	if *assertEmpty {
+		left := 0
+		for i, sourceQueueURL := range sourceQueueURLs {
+			if skipped[i] {
+				continue
+			}
+			n, err := sqsmigrate.AwaitEmpty(ctx, sqsSvc, sourceQueueURL, *assertEmptyGrace)
+			if err != nil {
+				logger.Errorf("Encountered an error when attempting to count the messages on %s\n", *sourceQueueURL)
+				logger.Fatal(err)
+			}
+			if n > 0 {
+				logger.Errorf("Source %s still holds roughly %d messages (-assert-empty)\n", sources[i], n)
+			}
+			left += n
+		}
+		if left > 0 {
+			notifier.Finished(result, exitSourceNotEmpty)
+			os.Exit(exitSourceNotEmpty)
+		}
+		logger.Println("Every source queue is empty")
+	}
+	if result.BatchErrors > 0 {
+		notifier.Finished(result, sqsmigrate.ExitBatchErrors)
+		os.Exit(sqsmigrate.ExitBatchErrors)
+	}
+	notifier.Finished(result, 0)
+}
+
+// exitTooFewMessages is the exit status when -require-min isn't met, so orchestration can
+// tell it apart from a failed run.
+const exitTooFewMessages = 3
+
+// exitSourceNotEmpty is the exit status when -assert-empty finds messages left on a
+// source, so a cutover can be held back without mistaking it for a failed run.
+const exitSourceNotEmpty = 4
+
+// exitInterrupted is the exit status of a run stopped early by SIGINT, SIGTERM or
+// -timeout, whose sources may still hold messages to migrate.
+const exitInterrupted = 7
+
+// exitReplayDuplicates is the exit status of a -replay-errors that re-sent messages it
+// then couldn't remove from the source, leaving them to be migrated twice.
+const exitReplayDuplicates = 8
+
+// isFlagSet reports whether the named flag was given on the command line, as opposed to
+// holding its default.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// queuePair is a source queue and the destination it is migrated to with -pair.
+type queuePair struct {
+	source string
+	dest   string
+}
+
+func (p queuePair) String() string {
+	return p.source + "=" + p.dest
+}
+
+// pairList is a flag.Value collecting the source=dest pairs of a repeatable -pair flag,
+// each occurrence holding one or more comma separated pairs.
+type pairList []queuePair
+
+func (p *pairList) String() string {
+	pairs := []string{}
+	for _, pair := range *p {
+		pairs = append(pairs, pair.String())
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (p *pairList) Set(value string) error {
+	for _, setting := range strings.Split(value, ",") {
+		if setting = strings.TrimSpace(setting); setting == "" {
+			continue
+		}
+		eq := strings.Index(setting, "=")
+		if eq < 1 || eq == len(setting)-1 {
+			return fmt.Errorf("%q is not of the form source=dest", setting)
+		}
+		*p = append(*p, queuePair{source: setting[:eq], dest: setting[eq+1:]})
+	}
+	return nil
+}
+
+// readPairsFile adds the pairs in a -pairs-file, one source=dest per line.  Blank lines
+// and lines starting with # are ignored.
+func readPairsFile(path string, pairs *pairList) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := pairs.Set(text); err != nil {
+			return fmt.Errorf("line %d: %s", line, err)
+		}
+	}
+	return scanner.Err()
+}
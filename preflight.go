@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+	"github.com/jrnt30/aws-utils/sqsmigrate"
+)
+
+// preflight checks that the credentials may receive from and, unless deletes is false,
+// delete on every source and send to the destination before anything is moved, so a missing sqs:DeleteMessage
+// doesn't only show up once messages have already been sent.  Destinations picked by
+// -route-by, for which destQueueURL is nil, are only known once messages arrive.
+//
+// SQS has no dry-run mode, so each permission is probed with a request that SQS rejects
+// as invalid without acting on it: an empty batch for sends and deletes, and an out of
+// range message count for receives.  IAM is evaluated first, so anything other than an
+// access denied error means the action is allowed.
+func preflight(ctx context.Context, sqsSvc, destSvc *sqs.Client, sourceQueueURLs []*string, destQueueURL *string, deletes bool) error {
+	denied := []string{}
+	check := func(action string, queueURL *string, err error) {
+		if isAccessDenied(err) {
+			denied = append(denied, fmt.Sprintf("%s on %s", action, aws.ToString(queueURL)))
+		}
+	}
+
+	for _, queueURL := range sourceQueueURLs {
+		_, err := sqsSvc.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+			QueueUrl:       queueURL,
+			AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameQueueArn},
+		})
+		check("sqs:GetQueueAttributes", queueURL, err)
+		_, err = sqsSvc.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            queueURL,
+			MaxNumberOfMessages: sqsmigrate.BatchSize + 1,
+		})
+		check("sqs:ReceiveMessage", queueURL, err)
+		if deletes {
+			_, err = sqsSvc.DeleteMessageBatch(ctx, &sqs.DeleteMessageBatchInput{
+				QueueUrl: queueURL,
+				Entries:  []types.DeleteMessageBatchRequestEntry{},
+			})
+			check("sqs:DeleteMessage", queueURL, err)
+		}
+	}
+
+	if destQueueURL != nil {
+		_, err := destSvc.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+			QueueUrl:       destQueueURL,
+			AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameQueueArn},
+		})
+		check("sqs:GetQueueAttributes", destQueueURL, err)
+		_, err = destSvc.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+			QueueUrl: destQueueURL,
+			Entries:  []types.SendMessageBatchRequestEntry{},
+		})
+		check("sqs:SendMessage", destQueueURL, err)
+	}
+
+	if len(denied) > 0 {
+		return fmt.Errorf("missing permissions, nothing was migrated: %s", strings.Join(denied, ", "))
+	}
+	return nil
+}
+
+// isAccessDenied reports whether err is IAM refusing the request.  The code differs
+// between the query and JSON protocols, so any code mentioning AccessDenied counts.
+func isAccessDenied(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && strings.Contains(apiErr.ErrorCode(), "AccessDenied")
+}
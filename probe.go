@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/jrnt30/aws-utils/sqsmigrate"
+)
+
+// probe logs the settings and depth of every source and the destination, then checks
+// the permissions a migration needs on them the same way preflight does, for -probe.  It
+// returns the preflight error, if any.
+func probe(ctx context.Context, sqsSvc, destSvc *sqs.Client, logger *sqsmigrate.Logger, sourceQueueURLs []*string, destQueueURL *string, deletes bool) error {
+	for _, queueURL := range sourceQueueURLs {
+		describeQueue(ctx, sqsSvc, logger, "Source", queueURL)
+	}
+	if destQueueURL != nil {
+		describeQueue(ctx, destSvc, logger, "Destination", destQueueURL)
+	} else {
+		logger.Println("Destination: none resolved")
+	}
+
+	if err := preflight(ctx, sqsSvc, destSvc, sourceQueueURLs, destQueueURL, deletes); err != nil {
+		return err
+	}
+	logger.Println("Permissions: receive, send and delete are all allowed")
+	return nil
+}
+
+// describeQueue logs the attributes of a queue that matter to a migration.
+func describeQueue(ctx context.Context, sqsSvc *sqs.Client, logger *sqsmigrate.Logger, role string, queueURL *string) {
+	attrs, err := sqsmigrate.QueueAttributes(ctx, sqsSvc, queueURL)
+	if err != nil {
+		logger.Errorf("%s %s: unable to read its attributes - %s\n", role, *queueURL, err)
+		return
+	}
+	kind := "standard"
+	if attrs[string(types.QueueAttributeNameFifoQueue)] == "true" {
+		kind = "FIFO"
+	}
+	logger.Printf("%s %s:\n", role, *queueURL)
+	logger.Printf("    Type: %s\n", kind)
+	logger.Printf("    Messages: %s available, %s in flight, %s delayed\n",
+		attrs[string(types.QueueAttributeNameApproximateNumberOfMessages)],
+		attrs[string(types.QueueAttributeNameApproximateNumberOfMessagesNotVisible)],
+		attrs[string(types.QueueAttributeNameApproximateNumberOfMessagesDelayed)])
+	logger.Printf("    Retention: %s\n", attributeSeconds(attrs[string(types.QueueAttributeNameMessageRetentionPeriod)]))
+	logger.Printf("    Visibility timeout: %s\n", attributeSeconds(attrs[string(types.QueueAttributeNameVisibilityTimeout)]))
+
+	target, maxReceives, ok := redriveTarget(attrs)
+	if !ok {
+		logger.Println("    Dead-letter queue: none")
+		return
+	}
+	logger.Printf("    Dead-letter queue: %s after %s receives\n", target, maxReceives)
+}
+
+// redriveTarget reads the dead-letter queue ARN and maximum receive count out of a
+// queue's RedrivePolicy, reporting false when it has none.
+func redriveTarget(attrs map[string]string) (target, maxReceives string, ok bool) {
+	var redrive struct {
+		DeadLetterTargetArn string      `json:"deadLetterTargetArn"`
+		MaxReceiveCount     json.Number `json:"maxReceiveCount"`
+	}
+	policy := attrs[string(types.QueueAttributeNameRedrivePolicy)]
+	if policy == "" || json.Unmarshal([]byte(policy), &redrive) != nil || redrive.DeadLetterTargetArn == "" {
+		return "", "", false
+	}
+	return redrive.DeadLetterTargetArn, redrive.MaxReceiveCount.String(), true
+}
+
+// attributeSeconds formats a queue attribute holding a number of seconds.
+func attributeSeconds(value string) string {
+	seconds, err := strconv.Atoi(value)
+	if err != nil {
+		return value
+	}
+	return (time.Duration(seconds) * time.Second).String()
+}
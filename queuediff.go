@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// comparedAttributes are the queue attributes compared between a source and the
+// destination, in the order differences are reported.
+var comparedAttributes = []types.QueueAttributeName{
+	types.QueueAttributeNameMessageRetentionPeriod,
+	types.QueueAttributeNameVisibilityTimeout,
+	types.QueueAttributeNameMaximumMessageSize,
+	types.QueueAttributeNameDelaySeconds,
+	types.QueueAttributeNameReceiveMessageWaitTimeSeconds,
+	types.QueueAttributeNameRedrivePolicy,
+	types.QueueAttributeNameFifoQueue,
+	types.QueueAttributeNameContentBasedDeduplication,
+	types.QueueAttributeNameDeduplicationScope,
+	types.QueueAttributeNameFifoThroughputLimit,
+}
+
+// queueDifference is an attribute that differs between a source and the destination.
+// significant is set for the differences likely to surprise the migration, such as a
+// destination that keeps messages for less time than the source.
+type queueDifference struct {
+	name        types.QueueAttributeName
+	source      string
+	dest        string
+	significant bool
+}
+
+func (d queueDifference) String() string {
+	return fmt.Sprintf("%s is %s on the source but %s on the destination", d.name, attributeOrUnset(d.source), attributeOrUnset(d.dest))
+}
+
+func attributeOrUnset(value string) string {
+	if value == "" {
+		return "unset"
+	}
+	return value
+}
+
+// diffQueues compares the attributes of a source and the destination.  Redrive policies
+// are compared by their maxReceiveCount, as the dead-letter queues are expected to
+// differ.
+func diffQueues(source, dest map[string]string) []queueDifference {
+	differences := []queueDifference{}
+	for _, name := range comparedAttributes {
+		s, d := source[string(name)], dest[string(name)]
+		if name == types.QueueAttributeNameRedrivePolicy {
+			s, d = maxReceiveCount(s), maxReceiveCount(d)
+		}
+		if s == d {
+			continue
+		}
+		difference := queueDifference{name: name, source: s, dest: d}
+		switch name {
+		case types.QueueAttributeNameMessageRetentionPeriod, types.QueueAttributeNameMaximumMessageSize, types.QueueAttributeNameVisibilityTimeout:
+			difference.significant = lessNumber(d, s)
+		case types.QueueAttributeNameRedrivePolicy:
+			difference.significant = d == ""
+		case types.QueueAttributeNameFifoQueue:
+			difference.significant = true
+		}
+		differences = append(differences, difference)
+	}
+	return differences
+}
+
+// maxReceiveCount describes a redrive policy by its maxReceiveCount, or is empty when
+// there is no policy.
+func maxReceiveCount(policy string) string {
+	var redrive struct {
+		MaxReceiveCount json.Number `json:"maxReceiveCount"`
+	}
+	if policy == "" || json.Unmarshal([]byte(policy), &redrive) != nil {
+		return ""
+	}
+	return "maxReceiveCount " + redrive.MaxReceiveCount.String()
+}
+
+// lessNumber reports whether a is a smaller number than b, treating an unset value as
+// nothing to compare.
+func lessNumber(a, b string) bool {
+	x, errA := strconv.Atoi(a)
+	y, errB := strconv.Atoi(b)
+	return errA == nil && errB == nil && x < y
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/jrnt30/aws-utils/sqsmigrate"
+)
+
+// redriveByQueue is the RedriveAllowPolicy permission naming the source queues allowed to
+// use a dead-letter queue.
+const redriveByQueue = "byQueue"
+
+// sourceDeadLetterQueue is the dead-letter queue a source's RedrivePolicy points at.
+type sourceDeadLetterQueue struct {
+	name     string
+	url      *string
+	messages int
+}
+
+// findDeadLetterQueues looks up the dead-letter queue of every source for -detect-dlq,
+// logging its depth next to the source's.  A queue shared by several sources is only
+// returned once, and one that is already a source or is the destination not at all.
+func findDeadLetterQueues(ctx context.Context, sqsSvc *sqs.Client, logger *sqsmigrate.Logger, sources []string, sourceQueueURLs []*string, skipped []bool, dest string) ([]sourceDeadLetterQueue, error) {
+	seen := map[string]bool{}
+	for i, sourceQueueURL := range sourceQueueURLs {
+		if !skipped[i] {
+			seen[*sourceQueueURL] = true
+		}
+	}
+	found := []sourceDeadLetterQueue{}
+	for i, sourceQueueURL := range sourceQueueURLs {
+		if skipped[i] {
+			continue
+		}
+		attrs, err := sqsmigrate.QueueAttributes(ctx, sqsSvc, sourceQueueURL)
+		if err != nil {
+			return nil, err
+		}
+		target, _, ok := redriveTarget(attrs)
+		if !ok {
+			logger.Printf("Source %s: %s messages, no dead-letter queue\n", sources[i], attrs[string(types.QueueAttributeNameApproximateNumberOfMessages)])
+			continue
+		}
+		dlqURL, err := sqsmigrate.ResolveQueueURL(ctx, sqsSvc, target)
+		if err != nil {
+			return nil, err
+		}
+		n, err := sqsmigrate.ApproximateMessages(ctx, sqsSvc, dlqURL)
+		if err != nil {
+			return nil, err
+		}
+		name := path.Base(*dlqURL)
+		logger.Printf("Source %s: %s messages, dead-letter queue %s: %d messages\n", sources[i], attrs[string(types.QueueAttributeNameApproximateNumberOfMessages)], name, n)
+		if seen[*dlqURL] || (dest != "" && name == sqsmigrate.QueueName(dest)) {
+			continue
+		}
+		seen[*dlqURL] = true
+		found = append(found, sourceDeadLetterQueue{name: name, url: dlqURL, messages: n})
+	}
+	return found, nil
+}
+
+// redriveSource finds the queue a dead-letter queue should be redriven back to with
+// -redrive: the one source queue its RedriveAllowPolicy lets in or, failing that, the one
+// queue whose RedrivePolicy targets it.  A dead-letter queue shared by several sources
+// can't be told apart, so that is an error naming them.
+func redriveSource(ctx context.Context, sqsSvc *sqs.Client, dlqURL *string) (string, error) {
+	attrs, err := sqsmigrate.QueueAttributes(ctx, sqsSvc, dlqURL)
+	if err != nil {
+		return "", err
+	}
+	var allow struct {
+		RedrivePermission string   `json:"redrivePermission"`
+		SourceQueueArns   []string `json:"sourceQueueArns"`
+	}
+	if policy := attrs[string(types.QueueAttributeNameRedriveAllowPolicy)]; policy != "" && json.Unmarshal([]byte(policy), &allow) == nil {
+		if allow.RedrivePermission == redriveByQueue && len(allow.SourceQueueArns) == 1 {
+			queueURL, err := sqsmigrate.ResolveQueueURL(ctx, sqsSvc, allow.SourceQueueArns[0])
+			if err != nil {
+				return "", err
+			}
+			return aws.ToString(queueURL), nil
+		}
+	}
+
+	sources := []string{}
+	pages := sqs.NewListDeadLetterSourceQueuesPaginator(sqsSvc, &sqs.ListDeadLetterSourceQueuesInput{QueueUrl: dlqURL})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return "", err
+		}
+		sources = append(sources, page.QueueUrls...)
+	}
+	name := path.Base(aws.ToString(dlqURL))
+	switch len(sources) {
+	case 0:
+		return "", fmt.Errorf("no queue has %s as its dead-letter queue, so there is nothing to redrive it to; give a -dest", name)
+	case 1:
+		return sources[0], nil
+	}
+	names := []string{}
+	for _, queueURL := range sources {
+		names = append(names, path.Base(queueURL))
+	}
+	return "", fmt.Errorf("%s is the dead-letter queue of %d queues, give one of %s as the -dest", name, len(sources), strings.Join(names, ", "))
+}
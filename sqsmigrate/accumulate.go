@@ -0,0 +1,90 @@
+package sqsmigrate
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// accumulator holds the messages staged from under-full receives with -accumulate until
+// there are enough for a full batch, or the oldest has been held for the -accumulate
+// duration, so a sparse queue or a picky filter doesn't mean a send for every couple of
+// messages.  Their receipt handles and receive times are held with them so they can still
+// be deleted once sent, and the hold is measured from the oldest receive still held.  A
+// nil accumulator holds nothing.
+type accumulator struct {
+	holdFor time.Duration
+
+	mu       sync.Mutex
+	entries  []*types.SendMessageBatchRequestEntry
+	receipts map[string]*string
+	added    []time.Time
+}
+
+func newAccumulator(holdFor time.Duration) *accumulator {
+	if holdFor <= 0 {
+		return nil
+	}
+	return &accumulator{holdFor: holdFor, receipts: map[string]*string{}}
+}
+
+// add holds staged messages until they are taken.
+func (a *accumulator) add(entries []*types.SendMessageBatchRequestEntry, idsToReceipts map[string]*string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	for _, entry := range entries {
+		a.entries = append(a.entries, entry)
+		a.added = append(a.added, now)
+		a.receipts[*entry.Id] = idsToReceipts[*entry.Id]
+	}
+}
+
+// take removes the messages that are due to be sent: every full batch, plus whatever is
+// left once it has been held for too long or when all is set.
+func (a *accumulator) take(all bool) ([]*types.SendMessageBatchRequestEntry, map[string]*string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	n := len(a.entries) - len(a.entries)%BatchSize
+	if all || len(a.entries) > 0 && time.Since(a.added[0]) >= a.holdFor {
+		n = len(a.entries)
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	taken := a.entries[:n:n]
+	receipts := map[string]*string{}
+	for _, entry := range taken {
+		receipts[*entry.Id] = a.receipts[*entry.Id]
+		delete(a.receipts, *entry.Id)
+	}
+	a.entries = append([]*types.SendMessageBatchRequestEntry(nil), a.entries[n:]...)
+	a.added = append([]time.Time(nil), a.added[n:]...)
+	return taken, receipts
+}
+
+// flush sends the held messages that are due, every one of them when all is set, in
+// batches of up to 10, each under a heartbeat as an unheld batch would be.  Held messages
+// count against -max-in-flight until they are deleted, so the ones that aren't queued for
+// removal are released here.
+func (m *migrator) flush(all bool, record *BatchRecord) {
+	if m.accumulated == nil {
+		return
+	}
+	entries, receipts := m.accumulated.take(all)
+	for start := 0; start < len(entries); start += BatchSize {
+		end := start + BatchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		batch := map[string]*string{}
+		for _, entry := range entries[start:end] {
+			batch[*entry.Id] = receipts[*entry.Id]
+		}
+		beat := m.startHeartbeat(batch)
+		queued := m.migrate(entries[start:end], receipts, record)
+		beat.stop()
+		m.InFlight.release(end - start - queued)
+	}
+}
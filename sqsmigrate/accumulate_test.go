@@ -0,0 +1,50 @@
+package sqsmigrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func accumulatedEntries(ids ...string) ([]*types.SendMessageBatchRequestEntry, map[string]*string) {
+	entries := []*types.SendMessageBatchRequestEntry{}
+	receipts := map[string]*string{}
+	for _, id := range ids {
+		entries = append(entries, &types.SendMessageBatchRequestEntry{Id: aws.String(id)})
+		receipts[id] = aws.String("receipt-" + id)
+	}
+	return entries, receipts
+}
+
+func TestAccumulatorHoldsFromOldestReceive(t *testing.T) {
+	a := newAccumulator(50 * time.Millisecond)
+	ids := []string{}
+	for i := 0; i < BatchSize+1; i++ {
+		ids = append(ids, string(rune('a'+i)))
+	}
+	a.add(accumulatedEntries(ids...))
+	time.Sleep(30 * time.Millisecond)
+
+	// The full batch goes, leaving one that has already been held for 30ms.
+	if taken, _ := a.take(false); len(taken) != BatchSize {
+		t.Fatalf("took %d, want a full batch", len(taken))
+	}
+	time.Sleep(30 * time.Millisecond)
+	taken, receipts := a.take(false)
+	if len(taken) != 1 || *taken[0].Id != ids[BatchSize] || *receipts[ids[BatchSize]] != "receipt-"+ids[BatchSize] {
+		t.Errorf("took %d, want the one left held 60ms since it was received", len(taken))
+	}
+}
+
+func TestAccumulatorKeepsHoldingNewerMessages(t *testing.T) {
+	a := newAccumulator(time.Hour)
+	a.add(accumulatedEntries("a", "b"))
+	if taken, _ := a.take(false); len(taken) != 0 {
+		t.Errorf("took %d before the hold was up", len(taken))
+	}
+	if taken, _ := a.take(true); len(taken) != 2 {
+		t.Errorf("took %d at the end of the run, want all of them", len(taken))
+	}
+}
@@ -0,0 +1,72 @@
+package sqsmigrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Formats for -age-format besides a Go time layout.
+const (
+	AgeFormatRFC3339 = "rfc3339"
+	ageFormatUnix    = "unix"
+	ageFormatUnixMs  = "unix-ms"
+)
+
+// AgeSource reads when a message was created from a field of its JSON body with -age-from,
+// for messages whose SentTimestamp was reset by an earlier migration.
+type AgeSource struct {
+	path   []interface{}
+	format string
+}
+
+func ParseAgeSource(from, format string) (*AgeSource, error) {
+	path, err := ParseJSONPath(from)
+	if err != nil {
+		return nil, err
+	}
+	return &AgeSource{path: path, format: format}, nil
+}
+
+// created returns the time held in the field of body, after any -unwrap-sns, with ok
+// false when the body isn't JSON or the field is missing or can't be parsed.
+func (a *AgeSource) created(body string) (time.Time, bool) {
+	var doc interface{}
+	if json.Unmarshal([]byte(body), &doc) != nil {
+		return time.Time{}, false
+	}
+	value, ok := lookupJSONPath(doc, a.path)
+	if !ok {
+		return time.Time{}, false
+	}
+	text, ok := jsonScalar(value)
+	if !ok {
+		return time.Time{}, false
+	}
+	created, err := parseTimestamp(text, a.format)
+	return created, err == nil
+}
+
+// parseTimestamp parses text in an -age-format: epoch seconds or milliseconds, RFC 3339
+// or any other Go time layout.
+func parseTimestamp(text, format string) (time.Time, error) {
+	switch format {
+	case ageFormatUnix, ageFormatUnixMs:
+		if ms, err := strconv.ParseInt(text, 10, 64); err == nil && format == ageFormatUnixMs {
+			return time.UnixMilli(ms), nil
+		}
+		n, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%q is not a number", text)
+		}
+		if format == ageFormatUnixMs {
+			n /= 1000
+		}
+		return time.Unix(0, int64(n*float64(time.Second))), nil
+	case AgeFormatRFC3339:
+		return time.Parse(time.RFC3339Nano, text)
+	default:
+		return time.Parse(format, text)
+	}
+}
@@ -0,0 +1,48 @@
+package sqsmigrate
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// callsPerBatch is the most requests one receive, send and delete cycle makes, ignoring
+// SDK retries.
+const callsPerBatch = 3
+
+// APICalls counts every request attempt the client makes, retries included, since each
+// one is billed.  With a max set, no new batch is started once it could go over.
+type APICalls struct {
+	count int64
+	Max   int64
+}
+
+// Watch counts the attempts of every client later created from cfg.
+func (c *APICalls) Watch(cfg *aws.Config) {
+	cfg.APIOptions = append(cfg.APIOptions, onAttempt("CountAPICalls", func(error) {
+		atomic.AddInt64(&c.count, 1)
+	}))
+}
+
+func (c *APICalls) Made() int64 {
+	return atomic.LoadInt64(&c.count)
+}
+
+// exhausted reports whether starting another batch could take the run past -max-api-calls.
+func (c *APICalls) exhausted() bool {
+	return c.Max > 0 && c.Made()+callsPerBatch > c.Max
+}
+
+// onAttempt builds an API option calling fn with the outcome of each request attempt.
+// It runs after the SDK's retry middleware, so attempts that are retried are seen too.
+func onAttempt(id string, fn func(err error)) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc(id, func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			out, metadata, err := next.HandleFinalize(ctx, in)
+			fn(err)
+			return out, metadata, err
+		}), middleware.After)
+	}
+}
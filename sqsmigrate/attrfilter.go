@@ -0,0 +1,124 @@
+package sqsmigrate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// attributeOperators are the comparisons of an -attr-filter, longest first so that >=
+// isn't taken for >.
+var attributeOperators = []string{">=", "<=", "==", "!=", ">", "<", "=", "~"}
+
+// numericOperator reports whether op only compares Number attributes.
+func numericOperator(op string) bool {
+	return op != "=" && op != "~"
+}
+
+// AttributeFilter keeps messages by one of their message attributes with -attr-filter.
+type AttributeFilter struct {
+	text string
+	name string
+	op   string
+	// value is what the attribute is compared with, and number the same value parsed
+	// for a numeric comparison.
+	value  string
+	number float64
+}
+
+// parseAttributeFilter parses an -attr-filter such as priority>=5.  = and ~ match the
+// value exactly or as a substring, ==, !=, <, <=, > and >= compare Number attributes
+// numerically.
+func parseAttributeFilter(text string) (AttributeFilter, error) {
+	at := strings.IndexAny(text, "<>=!~")
+	if at < 1 {
+		return AttributeFilter{}, fmt.Errorf("%q is not of the form name=value, name~value or a comparison such as name>=5", text)
+	}
+	f := AttributeFilter{text: text, name: text[:at]}
+	for _, op := range attributeOperators {
+		if strings.HasPrefix(text[at:], op) {
+			f.op = op
+			break
+		}
+	}
+	if f.op == "" {
+		return AttributeFilter{}, fmt.Errorf("%q has no operator, expected one of %s", text, strings.Join(attributeOperators, " "))
+	}
+	if err := validAttributeName(f.name); err != nil {
+		return AttributeFilter{}, err
+	}
+	f.value = text[at+len(f.op):]
+	if numericOperator(f.op) {
+		number, err := strconv.ParseFloat(f.value, 64)
+		if err != nil {
+			return AttributeFilter{}, fmt.Errorf("%q compares with %q, which is not a number", text, f.value)
+		}
+		f.number = number
+	}
+	return f, nil
+}
+
+// matches reports whether the message carries the attribute with a matching value.  A
+// comparison only matches Number attributes, and = compares those numerically too, so
+// priority=5 matches a value of 5.0.  A missing or Binary attribute matches nothing.
+func (f AttributeFilter) matches(attributes map[string]types.MessageAttributeValue) bool {
+	attribute, ok := attributes[f.name]
+	if !ok || attribute.StringValue == nil {
+		return false
+	}
+	value := *attribute.StringValue
+	isNumber := strings.HasPrefix(aws.ToString(attribute.DataType), "Number")
+	switch {
+	case f.op == "~":
+		return strings.Contains(value, f.value)
+	case f.op == "=" && !isNumber:
+		return value == f.value
+	case !isNumber:
+		return false
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return false
+	}
+	switch f.op {
+	case "=":
+		want, err := strconv.ParseFloat(f.value, 64)
+		return err == nil && number == want
+	case "==":
+		return number == f.number
+	case "!=":
+		return number != f.number
+	case ">":
+		return number > f.number
+	case ">=":
+		return number >= f.number
+	case "<":
+		return number < f.number
+	default:
+		return number <= f.number
+	}
+}
+
+// AttributeFilterList is a flag.Value collecting a repeatable -attr-filter, every one
+// of which a message has to match.
+type AttributeFilterList []AttributeFilter
+
+func (l *AttributeFilterList) String() string {
+	texts := []string{}
+	for _, f := range *l {
+		texts = append(texts, f.text)
+	}
+	return strings.Join(texts, ",")
+}
+
+func (l *AttributeFilterList) Set(text string) error {
+	f, err := parseAttributeFilter(text)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, f)
+	return nil
+}
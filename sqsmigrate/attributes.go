@@ -0,0 +1,299 @@
+package sqsmigrate
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	// maxMessageAttributes is how many message attributes SQS allows on a single message.
+	maxMessageAttributes = 10
+	// firstReceiveAttribute is the custom attribute -preserve-timestamp copies the
+	// ApproximateFirstReceiveTimestamp system attribute into, as epoch milliseconds.
+	firstReceiveAttribute = "ApproximateFirstReceiveTimestamp"
+	// MovedToDLQAttribute carries the -dlq-reason of messages moved with -move-to-dlq.
+	MovedToDLQAttribute = "MovedToDLQReason"
+	// sendFailedAttribute carries the last error of messages moved to -failed-dest.
+	sendFailedAttribute = "SendFailedReason"
+)
+
+// AttributeList is a flag.Value collecting the message attributes given to a repeatable
+// -set-attr flag, each in the form name=value:Type.
+type AttributeList map[string]types.MessageAttributeValue
+
+func (a AttributeList) String() string {
+	settings := []string{}
+	for name, value := range a {
+		settings = append(settings, name+"="+aws.ToString(value.StringValue)+":"+aws.ToString(value.DataType))
+	}
+	sort.Strings(settings)
+	return strings.Join(settings, ",")
+}
+
+func (a AttributeList) Set(setting string) error {
+	name, value, dataType, err := parseAttribute(setting)
+	if err != nil {
+		return err
+	}
+	if _, ok := a[name]; !ok && len(a) == maxMessageAttributes {
+		return fmt.Errorf("SQS allows at most %d message attributes", maxMessageAttributes)
+	}
+	a[name] = types.MessageAttributeValue{DataType: aws.String(dataType), StringValue: aws.String(value)}
+	return nil
+}
+
+// parseAttribute splits a name=value:Type setting.  The type is the text after the last
+// colon so values may contain colons of their own, and may carry a custom suffix such as
+// Number.int as long as the base type is String or Number.
+func parseAttribute(setting string) (name, value, dataType string, err error) {
+	eq := strings.Index(setting, "=")
+	colon := strings.LastIndex(setting, ":")
+	if eq < 1 || colon < eq {
+		return "", "", "", fmt.Errorf("%q is not of the form name=value:Type", setting)
+	}
+	name, value, dataType = setting[:eq], setting[eq+1:colon], setting[colon+1:]
+
+	if err := validAttributeName(name); err != nil {
+		return "", "", "", err
+	}
+	switch strings.SplitN(dataType, ".", 2)[0] {
+	case "String":
+		if value == "" {
+			return "", "", "", fmt.Errorf("attribute %s needs a non-empty value", name)
+		}
+	case "Number":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsInf(n, 0) || math.IsNaN(n) {
+			return "", "", "", fmt.Errorf("attribute %s has type Number but %q is not a number", name, value)
+		}
+	default:
+		return "", "", "", fmt.Errorf("attribute %s has unsupported type %q, expected String or Number", name, dataType)
+	}
+	return name, value, dataType, nil
+}
+
+// validAttributeName applies the SQS naming rules so a bad name is caught before any
+// message is received rather than failing every send.
+func validAttributeName(name string) error {
+	if len(name) > 256 {
+		return fmt.Errorf("attribute name %s is longer than 256 characters", name)
+	}
+	lower := strings.ToLower(name)
+	if strings.HasPrefix(lower, "aws.") || strings.HasPrefix(lower, "amazon.") {
+		return fmt.Errorf("attribute name %s uses a prefix reserved by AWS", name)
+	}
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") || strings.Contains(name, "..") {
+		return fmt.Errorf("attribute name %s can't start or end with a period or contain consecutive periods", name)
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return fmt.Errorf("attribute name %s may only contain letters, digits, hyphens, underscores and periods", name)
+		}
+	}
+	return nil
+}
+
+// Apply merges the attributes onto a staged entry's attributes, replacing any with the
+// same name.
+func (a AttributeList) Apply(entry *types.SendMessageBatchRequestEntry) {
+	if len(a) == 0 {
+		return
+	}
+	if entry.MessageAttributes == nil {
+		entry.MessageAttributes = map[string]types.MessageAttributeValue{}
+	}
+	for name, value := range a {
+		entry.MessageAttributes[name] = value
+	}
+}
+
+// preserveFirstReceive carries the time a message was first received on the source over
+// to the destination, which would otherwise reset it on the first receive there.  A
+// message that had never been received is stamped with this tool's own receive.
+func (m *migrator) preserveFirstReceive(message *types.Message, entry *types.SendMessageBatchRequestEntry) {
+	if !m.PreserveTimestamp {
+		return
+	}
+	received, ok := message.Attributes[string(types.MessageSystemAttributeNameApproximateFirstReceiveTimestamp)]
+	if !ok {
+		return
+	}
+	if entry.MessageAttributes == nil {
+		entry.MessageAttributes = map[string]types.MessageAttributeValue{}
+	}
+	entry.MessageAttributes[firstReceiveAttribute] = types.MessageAttributeValue{
+		DataType:    aws.String("Number"),
+		StringValue: aws.String(received),
+	}
+}
+
+// AttributeRenames is a flag.Value collecting the old=new pairs of a repeatable
+// -rename-attr flag.
+type AttributeRenames map[string]string
+
+func (r AttributeRenames) String() string {
+	settings := []string{}
+	for from, to := range r {
+		settings = append(settings, from+"="+to)
+	}
+	sort.Strings(settings)
+	return strings.Join(settings, ",")
+}
+
+func (r AttributeRenames) Set(setting string) error {
+	eq := strings.Index(setting, "=")
+	if eq < 1 || eq == len(setting)-1 {
+		return fmt.Errorf("%q is not of the form old=new", setting)
+	}
+	if err := validAttributeName(setting[eq+1:]); err != nil {
+		return err
+	}
+	r[setting[:eq]] = setting[eq+1:]
+	return nil
+}
+
+// AttributeNames is a flag.Value collecting the names given to a repeatable -drop-attr
+// or -receive-message-attributes flag, each occurrence holding one or more comma
+// separated names.
+type AttributeNames map[string]bool
+
+func (a AttributeNames) String() string {
+	return strings.Join(a.sorted(), ",")
+}
+
+func (a AttributeNames) sorted() []string {
+	names := []string{}
+	for name := range a {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (a AttributeNames) Set(value string) error {
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			a[name] = true
+		}
+	}
+	return nil
+}
+
+// remapAttributes renames and drops a staged entry's attributes for -rename-attr and
+// -drop-attr.  A renamed attribute replaces any other of its new name.
+func (m *migrator) remapAttributes(entry *types.SendMessageBatchRequestEntry) {
+	for name := range m.DropAttributes {
+		delete(entry.MessageAttributes, name)
+	}
+	for from, to := range m.RenameAttributes {
+		if value, ok := entry.MessageAttributes[from]; ok {
+			delete(entry.MessageAttributes, from)
+			entry.MessageAttributes[to] = value
+		}
+	}
+}
+
+// SystemAttributeList is a flag.Value collecting the system attributes given to
+// -receive-attributes, each occurrence holding one or more comma separated names.
+type SystemAttributeList []types.MessageSystemAttributeName
+
+func (s *SystemAttributeList) String() string {
+	names := []string{}
+	for _, name := range *s {
+		names = append(names, string(name))
+	}
+	return strings.Join(names, ",")
+}
+
+func (s *SystemAttributeList) Set(value string) error {
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		known := false
+		for _, valid := range types.MessageSystemAttributeName("").Values() {
+			known = known || string(valid) == name
+		}
+		if !known {
+			return fmt.Errorf("unknown system attribute %s", name)
+		}
+		*s = append(*s, types.MessageSystemAttributeName(name))
+	}
+	return nil
+}
+
+// describeSystemAttributes lists a message's system attributes in name order for
+// -verbose logging.
+func describeSystemAttributes(attributes map[string]string) string {
+	settings := []string{}
+	for name, value := range attributes {
+		settings = append(settings, name+"="+value)
+	}
+	sort.Strings(settings)
+	return strings.Join(settings, " ")
+}
+
+// attributeDiff describes how a message's attributes change on the way to the
+// destination for -show-diff, one line each for those added (+), removed (-), renamed by
+// -rename-attr or given a new value (~), in name order.  It is empty when nothing
+// changes.
+func attributeDiff(before, after map[string]types.MessageAttributeValue, renames AttributeRenames) string {
+	lines := []string{}
+	renamed := map[string]bool{}
+	for from, to := range renames {
+		if _, ok := before[from]; !ok {
+			continue
+		}
+		if _, ok := after[from]; ok {
+			continue
+		}
+		if _, ok := after[to]; ok {
+			renamed[from], renamed[to] = true, true
+			lines = append(lines, fmt.Sprintf("~ %s renamed to %s", from, to))
+		}
+	}
+	for name, value := range before {
+		if renamed[name] {
+			continue
+		}
+		if changed, ok := after[name]; !ok {
+			lines = append(lines, "- "+DescribeAttribute(name, value))
+		} else if !sameAttribute(value, changed) {
+			lines = append(lines, fmt.Sprintf("~ %s -> %s", DescribeAttribute(name, value), describeAttributeValue(changed)))
+		}
+	}
+	for name, value := range after {
+		if _, ok := before[name]; !ok && !renamed[name] {
+			lines = append(lines, "+ "+DescribeAttribute(name, value))
+		}
+	}
+	// Sort on the names rather than the markers in front of them.
+	sort.Slice(lines, func(i, j int) bool { return lines[i][2:] < lines[j][2:] })
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func sameAttribute(a, b types.MessageAttributeValue) bool {
+	return aws.ToString(a.DataType) == aws.ToString(b.DataType) &&
+		aws.ToString(a.StringValue) == aws.ToString(b.StringValue) &&
+		string(a.BinaryValue) == string(b.BinaryValue)
+}
+
+func DescribeAttribute(name string, value types.MessageAttributeValue) string {
+	return name + " " + describeAttributeValue(value)
+}
+
+func describeAttributeValue(value types.MessageAttributeValue) string {
+	if value.StringValue != nil {
+		return fmt.Sprintf("(%s) %q", aws.ToString(value.DataType), *value.StringValue)
+	}
+	return fmt.Sprintf("(%s) <%d bytes>", aws.ToString(value.DataType), len(value.BinaryValue))
+}
@@ -0,0 +1,50 @@
+package sqsmigrate
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// WatchDestDepth holds off new receives while any destination holds more than high
+// messages, going by ApproximateNumberOfMessages checked every interval, and lets them
+// carry on once every destination is back down to low.  It begins paused if the
+// destination is already too deep.
+func WatchDestDepth(ctx context.Context, destSvc *sqs.Client, logger *Logger, queueURLs []*string, high, low int, interval time.Duration) *Pauser {
+	p := &Pauser{}
+	check := func() {
+		depth := 0
+		for _, queueURL := range queueURLs {
+			n, err := ApproximateMessages(ctx, destSvc, queueURL)
+			if err != nil {
+				// Missing one check only delays the next transition.
+				logger.Errorf("Encountered an error when attempting to check the depth of %s: %s\n", *queueURL, err)
+				return
+			}
+			if n > depth {
+				depth = n
+			}
+		}
+		switch {
+		case depth > high && p.set(true):
+			logger.Printf("Paused, the destination holds %d messages, over -dest-max-depth %d, resuming at %d\n", depth, high, low)
+		case depth <= low && p.set(false):
+			logger.Printf("Resuming, the destination is down to %d messages\n", depth)
+		}
+	}
+	check()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				check()
+			}
+		}
+	}()
+	return p
+}
@@ -0,0 +1,121 @@
+package sqsmigrate
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	// maxConsecutiveBatchErrors is how many receive, send or delete requests in a row
+	// may fail with -continue-on-error before the run gives up anyway, as by then the
+	// problem is unlikely to be transient.
+	maxConsecutiveBatchErrors = 10
+	// batchErrorPause is how long a worker waits after a failed request before its next
+	// batch with -continue-on-error.
+	batchErrorPause = time.Second
+)
+
+// ExitBatchErrors is the exit status of a run that finished with -continue-on-error
+// despite some of its batch requests failing.
+const ExitBatchErrors = 5
+
+func newBatchErrors(continueOnError bool) *batchErrors {
+	if !continueOnError {
+		return nil
+	}
+	return &batchErrors{}
+}
+
+// batchErrors counts the receive, send and delete requests that failed outright with
+// -continue-on-error.  A nil *batchErrors is the default -fail-fast, where any such
+// error stops the run.
+type batchErrors struct {
+	count       int64
+	consecutive int64
+}
+
+// fail handles an error that failed a whole request, logging context before it.  Either
+// way the request's messages are left on the source, and unless the run carries on it
+// is stopped through stop.
+func (b *batchErrors) fail(logger *Logger, stop *Interrupt, context string, err error) {
+	logger.Errorln(context)
+	if b == nil {
+		stop.fail(logger, err)
+		return
+	}
+	atomic.AddInt64(&b.count, 1)
+	if n := atomic.AddInt64(&b.consecutive, 1); n >= maxConsecutiveBatchErrors {
+		logger.Errorf("%d requests in a row have failed, giving up despite -continue-on-error\n", n)
+		stop.fail(logger, err)
+		return
+	}
+	logger.Errorf("%s, carrying on with the next batch (-continue-on-error)\n", err)
+}
+
+// succeeded resets the run of consecutive failures.
+func (b *batchErrors) succeeded() {
+	if b == nil {
+		return
+	}
+	atomic.StoreInt64(&b.consecutive, 0)
+}
+
+// failed is how many requests have failed so far.
+func (b *batchErrors) failed() int64 {
+	if b == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&b.count)
+}
+
+// requestFailure is the failed batch entry recorded for every message of a request that
+// failed as a whole.
+func requestFailure(id *string, err error) types.BatchResultErrorEntry {
+	code := errorCode(err)
+	if code == "" {
+		code = "RequestFailed"
+	}
+	return types.BatchResultErrorEntry{
+		Id:      id,
+		Code:    aws.String(code),
+		Message: aws.String(err.Error()),
+	}
+}
+
+// failedSends is the response of a send request that failed as a whole, with every entry
+// failed so the messages are recorded and left on the source like any other failure.
+// The part of a -fifo-sequential batch sent before the failure is in partial, and only
+// the entries it has no result for are failed, so what was sent is still deleted.
+func failedSends(partial *sqs.SendMessageBatchOutput, entries []types.SendMessageBatchRequestEntry, failure func(id *string) types.BatchResultErrorEntry) *sqs.SendMessageBatchOutput {
+	resp := &sqs.SendMessageBatchOutput{}
+	done := map[string]bool{}
+	if partial != nil {
+		resp.Successful = partial.Successful
+		resp.Failed = partial.Failed
+		for _, sent := range partial.Successful {
+			done[*sent.Id] = true
+		}
+		for _, failed := range partial.Failed {
+			done[*failed.Id] = true
+		}
+	}
+	for _, entry := range entries {
+		if !done[*entry.Id] {
+			resp.Failed = append(resp.Failed, failure(entry.Id))
+		}
+	}
+	return resp
+}
+
+// failedDeletes is the response of a delete request that failed as a whole.
+func failedDeletes(entries []types.DeleteMessageBatchRequestEntry, err error) *sqs.DeleteMessageBatchOutput {
+	resp := &sqs.DeleteMessageBatchOutput{}
+	for _, entry := range entries {
+		resp.Failed = append(resp.Failed, requestFailure(entry.Id, err))
+	}
+	return resp
+}
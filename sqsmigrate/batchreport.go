@@ -0,0 +1,167 @@
+package sqsmigrate
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxKeptBatches caps the batches listed in a JSON summary, so a long run doesn't hold
+// on to every one of them.
+const maxKeptBatches = 10000
+
+// BatchReport writes a JSON line for every batch to the -batch-report as the run goes,
+// for following a migration from a log pipeline, and with keep set holds on to the
+// records for a JSON summary.  A nil *BatchReport discards everything.
+type BatchReport struct {
+	mu      sync.Mutex
+	f       *os.File
+	keep    bool
+	kept    []*BatchRecord
+	omitted int64
+}
+
+// OpenBatchReport creates the -batch-report, with - meaning stdout and "" for keeping
+// the records alone.
+func OpenBatchReport(path string, keep bool) (*BatchReport, error) {
+	switch path {
+	case "":
+		return &BatchReport{keep: keep}, nil
+	case "-":
+		return &BatchReport{f: os.Stdout, keep: keep}, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &BatchReport{f: f, keep: keep}, nil
+}
+
+func (r *BatchReport) write(record *BatchRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.keep {
+		if len(r.kept) < maxKeptBatches {
+			r.kept = append(r.kept, record)
+		} else {
+			r.omitted++
+		}
+	}
+	if r.f == nil {
+		return nil
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = r.f.Write(append(line, '\n'))
+	return err
+}
+
+// Records returns the batches kept for the summary, and how many more there were past
+// maxKeptBatches.  The deletes have all finished by then, so the records are complete.
+func (r *BatchReport) Records() ([]*BatchRecord, int64) {
+	if r == nil {
+		return nil, 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.kept, r.omitted
+}
+
+func (r *BatchReport) Close() error {
+	if r == nil || r.f == nil || r.f == os.Stdout {
+		return nil
+	}
+	return r.f.Close()
+}
+
+// BatchRecord is one line of the -batch-report.  Deletes finish in the background, so a
+// record is only written once the last of its deletes is done: pending starts at one
+// for the batch itself and goes up by one for every delete queued on its behalf.
+type BatchRecord struct {
+	Batch        int64     `json:"batch"`
+	Source       string    `json:"source"`
+	Time         time.Time `json:"time"`
+	Received     int       `json:"received"`
+	Matched      int       `json:"matched"`
+	Staged       int       `json:"staged"`
+	Sent         int       `json:"sent"`
+	SendFailed   int       `json:"send_failed"`
+	Deleted      int       `json:"deleted"`
+	DeleteFailed int       `json:"delete_failed"`
+	ReceiveMs    float64   `json:"receive_ms"`
+	SendMs       float64   `json:"send_ms"`
+	DeleteMs     float64   `json:"delete_ms"`
+
+	report  *BatchReport
+	batches *BatchLimit
+	mu      sync.Mutex
+	pending int32
+}
+
+// newBatchRecord starts the record of the next batch, or returns nil without a
+// -batch-report or -max-in-flight-batches.
+func (m *migrator) newBatchRecord() *BatchRecord {
+	if m.BatchReport == nil && m.BatchSlots == nil {
+		return nil
+	}
+	return &BatchRecord{
+		Batch:   atomic.AddInt64(&m.batches, 1),
+		Source:  *m.SourceQueueURL,
+		Time:    time.Now(),
+		report:  m.BatchReport,
+		batches: m.BatchSlots,
+		pending: 1,
+	}
+}
+
+// sent adds the result of one SendMessageBatch to the record.
+func (r *BatchRecord) sent(sent, failed int, elapsed time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Sent += sent
+	r.SendFailed += failed
+	r.SendMs += float64(elapsed) / float64(time.Millisecond)
+}
+
+// deleting notes a delete queued on behalf of the batch.
+func (r *BatchRecord) deleting() {
+	if r != nil {
+		atomic.AddInt32(&r.pending, 1)
+	}
+}
+
+// deleted adds the result of one DeleteMessageBatch to the record.
+func (r *BatchRecord) deleted(deleted, failed int, elapsed time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.Deleted += deleted
+	r.DeleteFailed += failed
+	r.DeleteMs += float64(elapsed) / float64(time.Millisecond)
+	r.mu.Unlock()
+}
+
+// done releases one hold on the record, writing it and freeing the batch's
+// -max-in-flight-batches slot once nothing is pending.
+func (r *BatchRecord) done(logger *Logger) {
+	if r == nil || atomic.AddInt32(&r.pending, -1) > 0 {
+		return
+	}
+	r.batches.release()
+	if r.report == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.report.write(r); err != nil {
+		logger.Errorf("Encountered an error when attempting to write the batch report: %s\n", err)
+	}
+}
@@ -0,0 +1,46 @@
+package sqsmigrate
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// batchTimeoutCode is the failure code of every entry of a send or delete request cut
+// off by -batch-timeout.
+const batchTimeoutCode = "BatchTimeout"
+
+// callContext bounds a single send or delete request by -batch-timeout, or leaves it
+// unbounded when that is 0.
+func callContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// timedOut reports whether a request failed because its -batch-timeout ran out, rather
+// than the run being cancelled or the request failing by itself.
+func timedOut(ctx, callCtx context.Context) bool {
+	return ctx.Err() == nil && callCtx.Err() == context.DeadlineExceeded
+}
+
+// timeoutFailure is the failed batch entry recorded for every message of a request that
+// ran past -batch-timeout.  The request may have gone through regardless, so a timed out
+// send can still show up on the destination.
+func timeoutFailure(id *string, timeout time.Duration) types.BatchResultErrorEntry {
+	return types.BatchResultErrorEntry{
+		Id:      id,
+		Code:    aws.String(batchTimeoutCode),
+		Message: aws.String(fmt.Sprintf("request took longer than the %s -batch-timeout", timeout)),
+	}
+}
+
+// isTimeoutFailure reports whether a failure is a message of a request cut off by
+// -batch-timeout, which may already be on the destination.
+func isTimeoutFailure(failure types.BatchResultErrorEntry) bool {
+	return aws.ToString(failure.Code) == batchTimeoutCode
+}
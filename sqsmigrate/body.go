@@ -0,0 +1,22 @@
+package sqsmigrate
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"unicode/utf8"
+)
+
+// isBinary reports whether a body should be treated as opaque bytes rather than text.
+// Binary bodies are passed through untouched and never fed to text filters or printed.
+func isBinary(body string) bool {
+	return !utf8.ValidString(body)
+}
+
+// DescribeBody renders a body for logging, replacing binary payloads with their length
+// and a hash so they don't garble the terminal.
+func DescribeBody(body string) string {
+	if isBinary(body) {
+		return fmt.Sprintf("<binary body, %d bytes, sha256 %x>", len(body), sha256.Sum256([]byte(body)))
+	}
+	return body
+}
@@ -0,0 +1,59 @@
+package sqsmigrate
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// binaryBody is a payload that isn't valid UTF-8, such as a protobuf or gzip body.
+const binaryBody = "\x1f\x8b\x08\x00order\xff\xfe\x00shipped"
+
+func TestDescribeBody(t *testing.T) {
+	if !isBinary(binaryBody) || isBinary("order shipped") {
+		t.Fatal("expected only the invalid UTF-8 body to count as binary")
+	}
+	described := DescribeBody(binaryBody)
+	if !strings.HasPrefix(described, fmt.Sprintf("<binary body, %d bytes, sha256 ", len(binaryBody))) || strings.Contains(described, "order") {
+		t.Errorf("got %q, want the length and hash in place of the bytes", described)
+	}
+	if described := DescribeBody("order shipped"); described != "order shipped" {
+		t.Errorf("got %q, want a text body logged as it is", described)
+	}
+}
+
+func TestBinaryBodyRoundTrip(t *testing.T) {
+	svc := newFakeSQS("bin")
+	svc.source[0].Body = aws.String(binaryBody)
+	m := newTestMigrator(svc)
+
+	m.run(1)
+
+	if len(svc.sent) != 1 || aws.ToString(svc.sent[0].MessageBody) != binaryBody {
+		t.Fatalf("expected the binary body sent byte for byte, sent %v", svc.sent)
+	}
+	if svc.onSource("bin") {
+		t.Error("the binary message sent was left on the source")
+	}
+}
+
+func TestTextFiltersSkipBinaryBodies(t *testing.T) {
+	message := &types.Message{MessageId: aws.String("bin"), Body: aws.String(binaryBody)}
+	m := newTestMigrator(newFakeSQS())
+	m.Filters = []string{"shipped"}
+
+	if reason := m.skipReason(message); !strings.HasPrefix(reason, "binary body") {
+		t.Errorf("got %q, want a binary body skipped by a text filter", reason)
+	}
+	m.ForceText = true
+	if reason := m.skipReason(message); reason != "" {
+		t.Errorf("got %q, want -force-text to match the filter against the bytes", reason)
+	}
+	m.Filters = []string{"cancelled"}
+	if reason := m.skipReason(message); reason == "" {
+		t.Error("expected -force-text to still skip a binary body the filter misses")
+	}
+}
@@ -0,0 +1,133 @@
+package sqsmigrate
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// checkpointInterval is how often a -checkpoint-file is saved while migrating.
+const checkpointInterval = 30 * time.Second
+
+// checkpoint is the state kept in a -checkpoint-file.  SQS has no offset to resume from,
+// so what carries over is the set of MessageIds already seen, and whether each was sent,
+// along with the totals of every run so far.
+type checkpoint struct {
+	MessageIDs map[string]bool `json:"message_ids"`
+	Summary    Summary         `json:"summary"`
+}
+
+// Checkpointer periodically saves a run's progress on top of the checkpoint it resumed
+// from.  A nil checkpointer does nothing.
+type Checkpointer struct {
+	path     string
+	logger   *Logger
+	previous Summary
+	IDs      *MessageIDs
+	progress *RunProgress
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// OpenCheckpoint resumes from the checkpoint at path, if there is one, and starts saving
+// to it.
+func OpenCheckpoint(path string, logger *Logger, progress *RunProgress) (*Checkpointer, error) {
+	previous := checkpoint{MessageIDs: map[string]bool{}}
+	contents, err := ioutil.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(contents, &previous); err != nil {
+			return nil, err
+		}
+		logger.Printf("Resuming from checkpoint %s with %d messages processed and %d message IDs seen\n", path, previous.Summary.Processed, len(previous.MessageIDs))
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	ids := NewMessageIDs()
+	for id, sent := range previous.MessageIDs {
+		ids.sent[id] = sent
+	}
+	c := &Checkpointer{
+		path:     path,
+		logger:   logger,
+		previous: previous.Summary,
+		IDs:      ids,
+		progress: progress,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go c.run()
+	return c, nil
+}
+
+func (c *Checkpointer) run() {
+	defer close(c.done)
+	ticker := time.NewTicker(checkpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			if err := c.save(c.snapshot()); err != nil {
+				c.logger.Errorf("Encountered an error when attempting to save the checkpoint: %s\n", err)
+			}
+		}
+	}
+}
+
+// snapshot totals the previous runs with the progress so far.
+func (c *Checkpointer) snapshot() Summary {
+	current := c.progress.snapshot()
+	total := c.previous
+	total.add(current)
+	total.DurationSeconds = c.previous.DurationSeconds + current.DurationSeconds
+	if total.DurationSeconds > 0 {
+		total.MessagesPerSecond = float64(total.Processed) / total.DurationSeconds
+	}
+	return total
+}
+
+// Close stops the periodic saves and saves the run's final result on top of the previous
+// runs, returning the cumulative summary.
+func (c *Checkpointer) Close(result Summary) Summary {
+	if c == nil {
+		return result
+	}
+	close(c.stop)
+	<-c.done
+
+	if c.previous.Processed > 0 {
+		c.logger.Printf("Totals below include the %d messages processed by earlier runs in the checkpoint\n", c.previous.Processed)
+	}
+	total := result
+	total.add(c.previous)
+	total.APICalls += c.previous.APICalls
+	total.DurationSeconds += c.previous.DurationSeconds
+	if total.DurationSeconds > 0 {
+		total.MessagesPerSecond = float64(total.Processed) / total.DurationSeconds
+	}
+	if err := c.save(total); err != nil {
+		c.logger.Errorln("Encountered an error when attempting to save the checkpoint")
+		c.logger.Fatal(err)
+	}
+	return total
+}
+
+// save writes the checkpoint to a temporary file and renames it into place, so a crash
+// part way through a save leaves the previous checkpoint intact.
+func (c *Checkpointer) save(s Summary) error {
+	s.Sources = nil
+	c.IDs.mu.Lock()
+	contents, err := json.Marshal(checkpoint{MessageIDs: c.IDs.sent, Summary: s})
+	c.IDs.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(c.path+".tmp", contents, 0644); err != nil {
+		return err
+	}
+	return os.Rename(c.path+".tmp", c.path)
+}
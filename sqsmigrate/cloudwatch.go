@@ -0,0 +1,125 @@
+package sqsmigrate
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// cloudWatchMetric is a summary count published with -cloudwatch-namespace.
+type cloudWatchMetric struct {
+	name  string
+	value func(s Summary) int64
+}
+
+// cloudWatchMetrics are published on every flush as the count since the flush before,
+// so summing a metric over any period gives the messages handled in it.
+var cloudWatchMetrics = []cloudWatchMetric{
+	{"ProcessedMessages", func(s Summary) int64 { return int64(s.Processed) }},
+	{"SentMessages", func(s Summary) int64 { return s.Sent }},
+	{"SendFailedMessages", func(s Summary) int64 { return s.SendFailed }},
+	{"DeletedMessages", func(s Summary) int64 { return int64(s.Deleted) }},
+	{"DeleteFailedMessages", func(s Summary) int64 { return int64(s.DeleteFailed) }},
+	{"OversizeMessages", func(s Summary) int64 { return s.Oversize }},
+	{"DuplicateMessages", func(s Summary) int64 { return s.Duplicates }},
+	{"BatchErrors", func(s Summary) int64 { return s.BatchErrors }},
+}
+
+// MetricsPublisher pushes the run's counts to CloudWatch every -metrics-flush-interval,
+// all of them in a single PutMetricData call.  A nil *MetricsPublisher publishes
+// nothing.
+type MetricsPublisher struct {
+	svc       *cloudwatch.Client
+	namespace string
+	logger    *Logger
+	progress  *RunProgress
+
+	// mu keeps the periodic and final flushes apart, and guards flushed: the totals
+	// CloudWatch already has.  A flush that fails leaves them alone, so its counts go
+	// out with the next one instead of being lost.
+	mu      sync.Mutex
+	flushed map[string]int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func StartMetricsPublisher(svc *cloudwatch.Client, namespace string, logger *Logger, progress *RunProgress, interval time.Duration) *MetricsPublisher {
+	p := &MetricsPublisher{
+		svc:       svc,
+		namespace: namespace,
+		logger:    logger,
+		progress:  progress,
+		flushed:   map[string]int64{},
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go p.run(interval)
+	return p
+}
+
+func (p *MetricsPublisher) run(interval time.Duration) {
+	defer close(p.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			if err := p.flush(context.Background(), p.progress.snapshot()); err != nil {
+				p.logger.Errorf("Encountered an error when attempting to publish metrics to CloudWatch, retrying with the next flush: %s\n", err)
+			}
+		}
+	}
+}
+
+// flush publishes what each count has grown by since the last successful flush.  A
+// count the progress snapshot has yet to catch up on, such as the deletes of a source
+// still running, is published as zero until it does.
+func (p *MetricsPublisher) flush(ctx context.Context, s Summary) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	data := []cwtypes.MetricDatum{}
+	totals := map[string]int64{}
+	for _, metric := range cloudWatchMetrics {
+		total := metric.value(s)
+		delta := total - p.flushed[metric.name]
+		if delta < 0 {
+			delta, total = 0, p.flushed[metric.name]
+		}
+		totals[metric.name] = total
+		data = append(data, cwtypes.MetricDatum{
+			MetricName: aws.String(metric.name),
+			Timestamp:  aws.Time(now),
+			Unit:       cwtypes.StandardUnitCount,
+			Value:      aws.Float64(float64(delta)),
+		})
+	}
+	if _, err := p.svc.PutMetricData(ctx, &cloudwatch.PutMetricDataInput{
+		Namespace:  aws.String(p.namespace),
+		MetricData: data,
+	}); err != nil {
+		return err
+	}
+	p.flushed = totals
+	return nil
+}
+
+// Close stops the periodic flushes and publishes whatever the run did since the last
+// one, from its final result.
+func (p *MetricsPublisher) Close(ctx context.Context, result Summary) {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	<-p.done
+	if err := p.flush(ctx, result); err != nil {
+		p.logger.Errorf("Encountered an error when attempting to publish the final metrics to CloudWatch, the counts since the last flush are missing: %s\n", err)
+	}
+}
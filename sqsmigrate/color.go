@@ -0,0 +1,74 @@
+package sqsmigrate
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+// Settings for -color.
+const (
+	ColorAuto   = "auto"
+	ColorAlways = "always"
+	ColorNever  = "never"
+)
+
+const (
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiReset  = "\x1b[0m"
+)
+
+// UseColor decides whether logs are colored.  auto colors them when stderr, where the
+// logs go, is a terminal, and nothing is colored when NO_COLOR is set
+// (https://no-color.org) unless always is asked for.
+func UseColor(setting string) bool {
+	switch setting {
+	case ColorAlways:
+		return true
+	case ColorNever:
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := os.Stderr.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorWriter colors each log line written through it with the color pick returns for
+// it, leaving lines it returns no color for alone.
+type colorWriter struct {
+	w    io.Writer
+	pick func(line string) string
+}
+
+func (c colorWriter) Write(p []byte) (int, error) {
+	line := string(p)
+	color := c.pick(line)
+	if color == "" {
+		return c.w.Write(p)
+	}
+	trimmed := strings.TrimSuffix(line, "\n")
+	if _, err := io.WriteString(c.w, color+trimmed+ansiReset+line[len(trimmed):]); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// infoColor picks green for progress that went through and yellow for messages that
+// were skipped or need attention.
+func infoColor(line string) string {
+	switch {
+	case strings.Contains(line, "Successes:"), strings.Contains(line, "Successful Removals:"), strings.Contains(line, "Recovered "):
+		return ansiGreen
+	case strings.Contains(line, "Skipping"), strings.Contains(line, "Not migrating"), strings.Contains(line, "Warning:"):
+		return ansiYellow
+	}
+	return ""
+}
+
+func errorColor(string) string {
+	return ansiRed
+}
@@ -0,0 +1,164 @@
+package sqsmigrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// ExitBodiesMissing is the exit status when -compare-bodies doesn't find every body
+// that was sent on its destination.
+const ExitBodiesMissing = 6
+
+// CompareVisibilityMargin is how much longer than -compare-timeout the messages received
+// by -compare-bodies stay hidden, so none comes back to be counted twice before the
+// pass releases them.
+const CompareVisibilityMargin = 30 * time.Second
+
+// maxWaitTime is the longest long poll SQS allows.
+const maxWaitTime = 20 * time.Second
+
+// SentBodies tracks the SHA-256 of every body sent to each destination for
+// -compare-bodies.  A nil *SentBodies tracks nothing.
+type SentBodies struct {
+	mu     sync.Mutex
+	hashes map[string]map[string]int
+}
+
+func NewSentBodies(enabled bool) *SentBodies {
+	if !enabled {
+		return nil
+	}
+	return &SentBodies{hashes: map[string]map[string]int{}}
+}
+
+func bodyHash(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+// add counts a body sent to queueURL.
+func (s *SentBodies) add(queueURL, body string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.hashes[queueURL] == nil {
+		s.hashes[queueURL] = map[string]int{}
+	}
+	s.hashes[queueURL][bodyHash(body)]++
+}
+
+// Queues lists the destinations bodies were sent to, in a stable order.
+func (s *SentBodies) Queues() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queues := []string{}
+	for queueURL := range s.hashes {
+		queues = append(queues, queueURL)
+	}
+	sort.Strings(queues)
+	return queues
+}
+
+// BodyComparison is the outcome of comparing one destination with the bodies sent to it.
+type BodyComparison struct {
+	Received   int
+	Matched    int
+	Missing    int
+	Unexpected int
+}
+
+// Compare receives from a destination for -compare-bodies until every body sent
+// to it has turned up, sample messages have been received or the timeout passes,
+// whichever comes first.  Everything received is hidden for the length of the pass so
+// each message is only counted once, then released straight away.
+func (s *SentBodies) Compare(ctx context.Context, destSvc *sqs.Client, logger *Logger, queueURL string, sample int, timeout time.Duration) (BodyComparison, error) {
+	s.mu.Lock()
+	expected := map[string]int{}
+	for hash, n := range s.hashes[queueURL] {
+		expected[hash] = n
+	}
+	s.mu.Unlock()
+	remaining := 0
+	for _, n := range expected {
+		remaining += n
+	}
+
+	result := BodyComparison{}
+	received := []*types.Message{}
+	defer func() {
+		releaseMessages(ctx, destSvc, logger, queueURL, received)
+	}()
+	deadline := time.Now().Add(timeout)
+	for remaining > 0 && (sample == 0 || result.Received < sample) && time.Now().Before(deadline) {
+		wait := time.Until(deadline)
+		if wait > maxWaitTime {
+			wait = maxWaitTime
+		}
+		resp, err := destSvc.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(queueURL),
+			MaxNumberOfMessages: int32(BatchSize),
+			VisibilityTimeout:   int32((timeout + CompareVisibilityMargin) / time.Second),
+			WaitTimeSeconds:     int32(wait / time.Second),
+		})
+		if err != nil {
+			return result, err
+		}
+		for i := range resp.Messages {
+			message := &resp.Messages[i]
+			received = append(received, message)
+			result.Received++
+			hash := bodyHash(aws.ToString(message.Body))
+			if expected[hash] > 0 {
+				expected[hash]--
+				remaining--
+				result.Matched++
+				continue
+			}
+			result.Unexpected++
+			logger.Printf("Unexpected message %s on %s, its body wasn't sent by this run\n", aws.ToString(message.MessageId), QueueName(queueURL))
+		}
+	}
+	result.Missing = remaining
+	return result, nil
+}
+
+// releaseMessages makes messages received by -compare-bodies visible on the destination
+// again.  Failing to release one only delays it, so errors are logged rather than fatal.
+func releaseMessages(ctx context.Context, svc *sqs.Client, logger *Logger, queueURL string, messages []*types.Message) {
+	for start := 0; start < len(messages); start += BatchSize {
+		end := start + BatchSize
+		if end > len(messages) {
+			end = len(messages)
+		}
+		entries := []types.ChangeMessageVisibilityBatchRequestEntry{}
+		for i, message := range messages[start:end] {
+			entries = append(entries, types.ChangeMessageVisibilityBatchRequestEntry{
+				Id:                aws.String(strconv.Itoa(i)),
+				ReceiptHandle:     message.ReceiptHandle,
+				VisibilityTimeout: 0,
+			})
+		}
+		resp, err := svc.ChangeMessageVisibilityBatch(ctx, &sqs.ChangeMessageVisibilityBatchInput{
+			QueueUrl: aws.String(queueURL),
+			Entries:  entries,
+		})
+		if err != nil {
+			logger.Errorf("Encountered an error when attempting to release the compared messages on %s, they reappear once their visibility timeout expires: %s\n", QueueName(queueURL), err)
+			return
+		}
+		for _, failed := range resp.Failed {
+			logger.Errorf("err releasing message - %s", aws.ToString(failed.Message))
+		}
+	}
+}
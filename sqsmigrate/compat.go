@@ -0,0 +1,29 @@
+package sqsmigrate
+
+// CompatMode selects how strictly the tool relies on AWS specific SQS behaviour.
+type CompatMode string
+
+const (
+	CompatAWS        CompatMode = ""
+	CompatElasticMQ  CompatMode = "elasticmq"
+	CompatLocalStack CompatMode = "localstack"
+)
+
+// relaxed reports whether responses may be missing attributes AWS always returns, such
+// as SentTimestamp.  Messages without a timestamp then skip age filtering entirely
+// rather than being treated as too old.
+func (c CompatMode) relaxed() bool {
+	return c != CompatAWS
+}
+
+// shortHandle abbreviates a receipt handle for logging.  Handles from SQS-compatible
+// servers can be much shorter than AWS ones, so they are never sliced blindly.
+func shortHandle(receiptHandle *string) string {
+	if receiptHandle == nil {
+		return ""
+	}
+	if len(*receiptHandle) <= 15 {
+		return *receiptHandle
+	}
+	return (*receiptHandle)[:15]
+}
@@ -0,0 +1,128 @@
+package sqsmigrate
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+)
+
+// decreaseCooldown keeps a single burst of throttled requests from collapsing the
+// concurrency all the way to 1 before the previous decrease has had a chance to help.
+const decreaseCooldown = time.Second
+
+// ConcurrencyController bounds how many workers may be processing a batch at once.  In
+// adaptive mode the bound starts at 1, grows by one each time a full round of batches
+// succeeds, and is halved whenever SQS throttles a request (AIMD), never exceeding max.
+type ConcurrencyController struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	limit int
+	max   int
+	inUse int
+
+	adaptive     bool
+	successes    int
+	lastDecrease time.Time
+	logger       *Logger
+}
+
+func newConcurrencyController(limit, max int, adaptive bool, logger *Logger) *ConcurrencyController {
+	c := &ConcurrencyController{limit: limit, max: max, adaptive: adaptive, logger: logger}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// ConcurrencyControllers hands every source migrated at once its own controller, so
+// -queue-concurrency runs up to -concurrency batches for each of them.  The sources
+// share their clients, so a throttled request slows all of them down.
+type ConcurrencyControllers struct {
+	limit    int
+	max      int
+	adaptive bool
+	logger   *Logger
+
+	mu          sync.Mutex
+	controllers []*ConcurrencyController
+}
+
+func NewConcurrencyControllers(limit, max int, adaptive bool, logger *Logger) *ConcurrencyControllers {
+	return &ConcurrencyControllers{limit: limit, max: max, adaptive: adaptive, logger: logger}
+}
+
+// Next returns a new controller for the next source.
+func (cs *ConcurrencyControllers) Next() *ConcurrencyController {
+	c := newConcurrencyController(cs.limit, cs.max, cs.adaptive, cs.logger)
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.controllers = append(cs.controllers, c)
+	return c
+}
+
+// Watch counts throttled attempts made by clients later created from cfg against every
+// controller, including the ones the SDK goes on to retry successfully.
+func (cs *ConcurrencyControllers) Watch(cfg *aws.Config) {
+	throttles := retry.IsErrorThrottles(retry.DefaultThrottles)
+	cfg.APIOptions = append(cfg.APIOptions, onAttempt("AdaptiveConcurrency", func(err error) {
+		if err == nil || throttles.IsErrorThrottle(err) != aws.TrueTernary {
+			return
+		}
+		cs.mu.Lock()
+		controllers := cs.controllers
+		cs.mu.Unlock()
+		for _, c := range controllers {
+			c.throttled()
+		}
+	}))
+}
+
+func (c *ConcurrencyController) acquire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.inUse >= c.limit {
+		c.cond.Wait()
+	}
+	c.inUse++
+}
+
+func (c *ConcurrencyController) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inUse--
+	c.cond.Broadcast()
+}
+
+func (c *ConcurrencyController) succeeded() {
+	if !c.adaptive {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.successes++
+	if c.successes < c.limit || c.limit >= c.max {
+		return
+	}
+	c.successes = 0
+	c.limit++
+	c.logger.Printf("Increasing concurrency to %d\n", c.limit)
+	c.cond.Broadcast()
+}
+
+func (c *ConcurrencyController) throttled() {
+	if !c.adaptive {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.lastDecrease) < decreaseCooldown {
+		return
+	}
+	c.lastDecrease = time.Now()
+	c.successes = 0
+	if c.limit > 1 {
+		c.limit /= 2
+		c.logger.Printf("Throttled by SQS, reducing concurrency to %d\n", c.limit)
+	}
+}
@@ -0,0 +1,99 @@
+package sqsmigrate
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// dedupInterval is how long a FIFO queue remembers a MessageDeduplicationId, dropping
+// any other message sent with it in the meantime.
+const dedupInterval = 5 * time.Minute
+
+// Policies for -check-dedup-ids.
+const (
+	DedupCheckWarn  = "warn"
+	DedupCheckError = "error"
+)
+
+// dedupUse is the message a MessageDeduplicationId was last staged for, and when.
+type dedupUse struct {
+	messageID string
+	at        time.Time
+}
+
+// DedupTracker remembers the MessageDeduplicationIds staged for a FIFO destination with
+// -check-dedup-ids, to catch two different messages given the same ID within the
+// deduplication interval, which SQS accepts but silently drops the second of.  The
+// same message staged again, such as after a failed send, isn't a collision.  IDs are
+// forgotten once the interval has passed.  A nil *DedupTracker checks nothing.
+type DedupTracker struct {
+	// perGroup keys IDs by message group, for a destination deduplicating by group, and
+	// contentBased takes the SHA-256 of the body SQS uses for a message sent without one.
+	perGroup     bool
+	contentBased bool
+
+	mu    sync.Mutex
+	seen  map[string]dedupUse
+	order []string
+}
+
+func NewDedupTracker(enabled bool, settings FIFOSettings) *DedupTracker {
+	if !enabled {
+		return nil
+	}
+	return &DedupTracker{
+		perGroup:     settings.DedupScope == DedupScopeMessageGroup,
+		contentBased: settings.contentBased,
+		seen:         map[string]dedupUse{},
+	}
+}
+
+// collides records the deduplication ID a staged message is sent with, returning the
+// ID of a different message already staged with it within the interval.
+func (t *DedupTracker) collides(entry *types.SendMessageBatchRequestEntry, messageID string, now time.Time) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	dedupID := aws.ToString(entry.MessageDeduplicationId)
+	if dedupID == "" && t.contentBased {
+		dedupID = bodyDeduplicationID(aws.ToString(entry.MessageBody), "", DedupScopeMessageGroup)
+	}
+	if dedupID == "" {
+		return "", false
+	}
+	key := dedupID
+	if t.perGroup {
+		key = aws.ToString(entry.MessageGroupId) + "\x00" + dedupID
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.evict(now)
+	previous, ok := t.seen[key]
+	if ok && previous.messageID != messageID {
+		return previous.messageID, true
+	}
+	if !ok {
+		t.order = append(t.order, key)
+	}
+	t.seen[key] = dedupUse{messageID: messageID, at: now}
+	return "", false
+}
+
+// evict forgets the IDs staged longer than the interval ago.  A key refreshed since it
+// was queued stays behind in order and is looked at again later on.
+func (t *DedupTracker) evict(now time.Time) {
+	for len(t.order) > 0 {
+		key := t.order[0]
+		use, ok := t.seen[key]
+		if ok && now.Sub(use.at) < dedupInterval {
+			return
+		}
+		t.order = t.order[1:]
+		if ok {
+			delete(t.seen, key)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package sqsmigrate
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	// delayAttribute is the custom message attribute read by -preserve-delay.
+	delayAttribute = "DelaySeconds"
+	// MaxDelaySeconds is the longest delivery delay SQS accepts.
+	MaxDelaySeconds = 900
+)
+
+// messageDelay returns the DelaySeconds to send a message with.  An explicit -delay always
+// wins, otherwise with -preserve-delay the message's own DelaySeconds attribute is used.
+// A nil result leaves the destination queue's default delay in place.
+func (m *migrator) messageDelay(message *types.Message) (*int32, error) {
+	if m.Delay != nil {
+		return m.Delay, nil
+	}
+	if !m.PreserveDelay {
+		return nil, nil
+	}
+	attr, ok := message.MessageAttributes[delayAttribute]
+	if !ok {
+		return nil, nil
+	}
+	seconds, err := strconv.ParseInt(aws.ToString(attr.StringValue), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%s attribute %q is not a whole number of seconds", delayAttribute, aws.ToString(attr.StringValue))
+	}
+	if seconds < 0 || seconds > MaxDelaySeconds {
+		return nil, fmt.Errorf("%s attribute %d is outside of 0-%d", delayAttribute, seconds, MaxDelaySeconds)
+	}
+	return aws.Int32(int32(seconds)), nil
+}
+
+// receiveDelay adds -delay-per-receive to the delay of a message for every time it was
+// received before this run's receive, spreading messages that keep failing out on the
+// destination.  The total is capped at the 15 minutes SQS allows.  A message received
+// for the first time keeps the delay it had.
+func (m *migrator) receiveDelay(message *types.Message, delay *int32) *int32 {
+	if m.DelayPerReceive == 0 {
+		return delay
+	}
+	count, err := strconv.Atoi(message.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)])
+	if err != nil || count <= 1 {
+		return delay
+	}
+	seconds := int64(aws.ToInt32(delay)) + int64(count-1)*int64(m.DelayPerReceive/time.Second)
+	if seconds > MaxDelaySeconds {
+		seconds = MaxDelaySeconds
+	}
+	return aws.Int32(int32(seconds))
+}
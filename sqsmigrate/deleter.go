@@ -0,0 +1,167 @@
+package sqsmigrate
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// receiptHandleIsInvalid is the batch result code for a receipt handle that has expired.
+const receiptHandleIsInvalid = "ReceiptHandleIsInvalid"
+
+// deleter removes migrated messages from the source queue in the background so the next
+// receive doesn't have to wait on the previous batch's cleanup, with up to
+// -delete-concurrency batches being deleted at once.  Only entries taken from a
+// successful send response should ever be queued, which keeps an unsent message from
+// being deleted.
+type deleter struct {
+	ctx            context.Context
+	sqsSvc         API
+	logger         *Logger
+	sourceQueueURL *string
+	errs           *ErrorFile
+	inFlight       *InFlight
+	latency        *latencyHistogram
+	failures       *failureCauses
+	batchErrors    *batchErrors
+	// stop ends the run over an error the deletes can't carry on from.
+	stop *Interrupt
+	// timeout is the -batch-timeout of each delete request, and timeouts the count of
+	// requests of the run that ran past it.
+	timeout  time.Duration
+	timeouts *int64
+
+	batches chan deleteBatch
+	done    chan struct{}
+
+	// mu guards the counts while several batches are being deleted.
+	mu         sync.Mutex
+	successful int
+	failed     int
+	// expired counts failures down to an expired receipt handle, each of which means a
+	// duplicate on the destination once the message is migrated again.
+	expired int
+}
+
+// deleteBatch is a batch of migrated messages to remove, along with the -batch-report
+// record of the batch they came from.
+type deleteBatch struct {
+	entries []types.DeleteMessageBatchRequestEntry
+	record  *BatchRecord
+}
+
+// startDeleter launches the background delete goroutines.  At most one batch is buffered
+// while the others are being deleted, so receives stall rather than letting an unbounded
+// number of migrated messages sit on the source.
+func startDeleter(ctx context.Context, sqsSvc API, logger *Logger, sourceQueueURL *string, errs *ErrorFile, inFlight *InFlight, latency *latencyHistogram, failures *failureCauses, batchErrors *batchErrors, stop *Interrupt, timeout time.Duration, timeouts *int64, workers int) *deleter {
+	d := &deleter{
+		ctx:            ctx,
+		sqsSvc:         sqsSvc,
+		logger:         logger,
+		sourceQueueURL: sourceQueueURL,
+		errs:           errs,
+		inFlight:       inFlight,
+		latency:        latency,
+		failures:       failures,
+		batchErrors:    batchErrors,
+		stop:           stop,
+		timeout:        timeout,
+		timeouts:       timeouts,
+		batches:        make(chan deleteBatch, 1),
+		done:           make(chan struct{}),
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.run()
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(d.done)
+	}()
+	return d
+}
+
+func (d *deleter) enqueue(entries []types.DeleteMessageBatchRequestEntry, record *BatchRecord) {
+	if len(entries) > 0 {
+		record.deleting()
+		d.batches <- deleteBatch{entries: entries, record: record}
+	}
+}
+
+// wait drains any queued batches and blocks until they have all been deleted.  The
+// removal counts are only safe to read once wait has returned.
+func (d *deleter) wait() {
+	close(d.batches)
+	<-d.done
+}
+
+func (d *deleter) run() {
+	for batch := range d.batches {
+		messagesToDelete := batch.entries
+		start := time.Now()
+		callCtx, cancel := callContext(d.ctx, d.timeout)
+		deletionResp, err := d.sqsSvc.DeleteMessageBatch(callCtx, &sqs.DeleteMessageBatchInput{
+			QueueUrl: d.sourceQueueURL,
+			Entries:  messagesToDelete,
+		})
+		elapsed := time.Since(start)
+		d.latency.observe(elapsed)
+		switch {
+		case err != nil && timedOut(d.ctx, callCtx):
+			// The messages were sent, so like an expired receipt handle this means
+			// duplicates on the destination once they are redelivered.
+			d.logger.Errorf("Deleting %d migrated messages took longer than the %s -batch-timeout, they stay on the source and will be redelivered\n", len(messagesToDelete), d.timeout)
+			atomic.AddInt64(d.timeouts, 1)
+			deletionResp = &sqs.DeleteMessageBatchOutput{}
+			for _, entry := range messagesToDelete {
+				deletionResp.Failed = append(deletionResp.Failed, timeoutFailure(entry.Id, d.timeout))
+			}
+		case err != nil:
+			d.batchErrors.fail(d.logger, d.stop, "Error encountered while attempting to cleanup batch of records", err)
+			deletionResp = failedDeletes(messagesToDelete, err)
+		default:
+			d.batchErrors.succeeded()
+		}
+		cancel()
+
+		for _, failedRemoval := range deletionResp.Failed {
+			d.failures.record("delete", failedRemoval)
+			if aws.ToString(failedRemoval.Code) == receiptHandleIsInvalid {
+				// The visibility timeout ran out before the delete, so the message has
+				// already been sent and will be received again from the source.
+				d.logger.Printf("Receipt handle for %s expired, the message was sent but will be redelivered, not deleted\n", *failedRemoval.Id)
+				d.mu.Lock()
+				d.expired++
+				d.mu.Unlock()
+				continue
+			}
+			d.logger.Errorf("err removing %s - %s", *failedRemoval.Id, *failedRemoval.Message)
+			for i, entry := range messagesToDelete {
+				if *entry.Id == *failedRemoval.Id {
+					if err := d.errs.recordDelete(d.sourceQueueURL, &messagesToDelete[i], failedRemoval); err != nil {
+						d.stop.fail(d.logger, err)
+					}
+				}
+			}
+		}
+
+		d.inFlight.release(len(messagesToDelete))
+		d.mu.Lock()
+		d.successful += len(deletionResp.Successful)
+		d.failed += len(deletionResp.Failed)
+		d.mu.Unlock()
+		batch.record.deleted(len(deletionResp.Successful), len(deletionResp.Failed), elapsed)
+		batch.record.done(d.logger)
+		// One write per batch, as with the sends, so concurrent deletes don't interleave.
+		d.logger.Printf("\nCompleted removal of messages for a batch, resulting in: \n    Successful Removals: %d\n    Failed Removals: %d\n", len(deletionResp.Successful), len(deletionResp.Failed))
+	}
+}
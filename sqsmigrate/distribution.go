@@ -0,0 +1,58 @@
+package sqsmigrate
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// distribution counts observations into labelled buckets.  Each bound is the exclusive
+// upper limit of its bucket and the final bucket, which has no bound, catches the rest.
+type distribution struct {
+	labels []string
+	bounds []int64
+	counts []int64
+}
+
+func newDistribution(labels []string, bounds []int64) *distribution {
+	return &distribution{labels: labels, bounds: bounds, counts: make([]int64, len(labels))}
+}
+
+func (d *distribution) observe(value int64) {
+	for i, bound := range d.bounds {
+		if value < bound {
+			atomic.AddInt64(&d.counts[i], 1)
+			return
+		}
+	}
+	atomic.AddInt64(&d.counts[len(d.counts)-1], 1)
+}
+
+func (d *distribution) values() map[string]int64 {
+	values := map[string]int64{}
+	for i, label := range d.labels {
+		values[label] = atomic.LoadInt64(&d.counts[i])
+	}
+	return values
+}
+
+// merge adds the counts of o, which must have the same buckets, to d.
+func (d *distribution) merge(o *distribution) {
+	for i := range d.counts {
+		atomic.AddInt64(&d.counts[i], atomic.LoadInt64(&o.counts[i]))
+	}
+}
+
+// print logs each bucket in order.
+func (d *distribution) print(logger *Logger) {
+	for i, label := range d.labels {
+		logger.Printf("    %-8s %d\n", label, atomic.LoadInt64(&d.counts[i]))
+	}
+}
+
+// newAgeDistribution buckets matched messages by how long ago they were sent.  Messages
+// without a SentTimestamp are counted separately by the migrator.
+func newAgeDistribution() *distribution {
+	return newDistribution(
+		[]string{"<1m", "<5m", "<1h", "<12h", "older"},
+		[]int64{int64(time.Minute), int64(5 * time.Minute), int64(time.Hour), int64(12 * time.Hour)})
+}
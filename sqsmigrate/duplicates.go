@@ -0,0 +1,78 @@
+package sqsmigrate
+
+import (
+	"sync"
+	"time"
+)
+
+// MessageIDs remembers every MessageId received during a run, and whether that message
+// has been sent to the destination, to spot the same message arriving more than once.
+type MessageIDs struct {
+	mu   sync.Mutex
+	sent map[string]bool
+	// window, when set with -dedupe-window, forgets IDs first received longer ago than
+	// that, keeping order oldest first to evict them.
+	window time.Duration
+	order  []receivedID
+}
+
+type receivedID struct {
+	id string
+	at time.Time
+}
+
+func NewMessageIDs() *MessageIDs {
+	return &MessageIDs{sent: map[string]bool{}}
+}
+
+// receive records a received MessageId, reporting whether it has been received before
+// in this run and if so whether an earlier copy was sent.
+func (ids *MessageIDs) receive(id string) (repeat, sent bool) {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+	now := time.Now()
+	ids.evict(now)
+	sent, repeat = ids.sent[id]
+	if !repeat {
+		ids.sent[id] = false
+		if ids.window > 0 {
+			ids.order = append(ids.order, receivedID{id: id, at: now})
+		}
+	}
+	return repeat, sent
+}
+
+// markSent records that the message with this MessageId made it to the destination,
+// unless it has already been forgotten.
+func (ids *MessageIDs) markSent(id string) {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+	if _, ok := ids.sent[id]; ok {
+		ids.sent[id] = true
+	}
+}
+
+// ForgetAfter bounds how long IDs are remembered for -dedupe-window.  Those already
+// known, such as from a checkpoint, count as received now.
+func (ids *MessageIDs) ForgetAfter(window time.Duration) {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+	ids.window = window
+	now := time.Now()
+	for id := range ids.sent {
+		ids.order = append(ids.order, receivedID{id: id, at: now})
+	}
+}
+
+// evict forgets the IDs received before the window.  ids.mu must be held.
+func (ids *MessageIDs) evict(now time.Time) {
+	if ids.window <= 0 {
+		return
+	}
+	n := 0
+	for n < len(ids.order) && now.Sub(ids.order[n].at) > ids.window {
+		delete(ids.sent, ids.order[n].id)
+		n++
+	}
+	ids.order = ids.order[n:]
+}
@@ -0,0 +1,191 @@
+package sqsmigrate
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+)
+
+// Causes the summary breaks failures down into.
+const (
+	causeThrottling     = "throttling"
+	causeAccessDenied   = "access_denied"
+	causeKMS            = "kms"
+	causeOversized      = "oversized"
+	causeInvalidMessage = "invalid_message"
+	causeExpiredReceipt = "expired_receipt"
+	causeService        = "service"
+	causeOther          = "other"
+)
+
+// classifyCode buckets an SQS error code by its likely cause.  Codes differ between the
+// query and JSON protocols, so most are matched on the part they have in common.
+func classifyCode(code string) string {
+	switch {
+	case strings.Contains(code, "Throttl"):
+		return causeThrottling
+	case strings.HasPrefix(code, "Kms"):
+		return causeKMS
+	case strings.Contains(code, "AccessDenied"):
+		return causeAccessDenied
+	case strings.Contains(code, "TooLong"), strings.Contains(code, "TooBig"):
+		return causeOversized
+	case code == receiptHandleIsInvalid:
+		return causeExpiredReceipt
+	case strings.HasPrefix(code, "Invalid"), code == checksumMismatch, code == attributeChecksumMismatch:
+		return causeInvalidMessage
+	case strings.Contains(code, "InternalError"), strings.Contains(code, "ServiceUnavailable"):
+		return causeService
+	default:
+		return causeOther
+	}
+}
+
+// errorCode is the AWS error code of err, or empty when it isn't an API error.
+func errorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
+
+// maxFailedMessages caps the failed messages listed in a JSON summary, so a run
+// failing wholesale doesn't hold on to every one of them.  The rest are only counted.
+const maxFailedMessages = 1000
+
+// FailedMessage is a message a send or delete failed for, as listed in a JSON summary.
+type FailedMessage struct {
+	MessageID string `json:"message_id"`
+	Operation string `json:"operation"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+}
+
+// failureCauses counts failures by operation and cause for the summary, and with list
+// set keeps the failed messages for a JSON summary too.  A nil *failureCauses counts
+// nothing.
+type failureCauses struct {
+	mu      sync.Mutex
+	counts  map[string]map[string]int64
+	list    bool
+	failed  []FailedMessage
+	omitted int64
+}
+
+func newFailureCauses(list bool) *failureCauses {
+	return &failureCauses{counts: map[string]map[string]int64{}, list: list}
+}
+
+// record counts the failure of one entry of a send or delete batch, keeping the message
+// it failed for when listing them.
+func (f *failureCauses) record(operation string, failed types.BatchResultErrorEntry) {
+	f.add(operation, aws.ToString(failed.Code))
+	if f == nil || !f.list {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failed, f.omitted = appendFailed(f.failed, f.omitted, FailedMessage{
+		MessageID: aws.ToString(failed.Id),
+		Operation: operation,
+		Code:      aws.ToString(failed.Code),
+		Message:   aws.ToString(failed.Message),
+	})
+}
+
+// messages copies the failed messages kept for the summary, and how many more there
+// were past maxFailedMessages.
+func (f *failureCauses) messages() ([]FailedMessage, int64) {
+	if f == nil {
+		return nil, 0
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FailedMessage(nil), f.failed...), f.omitted
+}
+
+// appendFailed adds failed messages to a list up to maxFailedMessages, counting the
+// rest as omitted.
+func appendFailed(list []FailedMessage, omitted int64, failed ...FailedMessage) ([]FailedMessage, int64) {
+	for _, message := range failed {
+		if len(list) >= maxFailedMessages {
+			omitted++
+			continue
+		}
+		list = append(list, message)
+	}
+	return list, omitted
+}
+
+// add counts a failure of operation, such as send or delete, with the given error code.
+func (f *failureCauses) add(operation, code string) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.counts[operation] == nil {
+		f.counts[operation] = map[string]int64{}
+	}
+	f.counts[operation][classifyCode(code)]++
+}
+
+// values copies the counts for the summary, or returns nil when nothing failed.
+func (f *failureCauses) values() map[string]map[string]int64 {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.counts) == 0 {
+		return nil
+	}
+	copied := map[string]map[string]int64{}
+	for operation, causes := range f.counts {
+		copied[operation] = map[string]int64{}
+		for cause, n := range causes {
+			copied[operation][cause] = n
+		}
+	}
+	return copied
+}
+
+// addFailures returns the total of two breakdowns of failures.  Summaries are copied by
+// value, so neither is modified.
+func addFailures(a, b map[string]map[string]int64) map[string]map[string]int64 {
+	if len(a) == 0 && len(b) == 0 {
+		return nil
+	}
+	total := map[string]map[string]int64{}
+	for _, failures := range []map[string]map[string]int64{a, b} {
+		for operation, causes := range failures {
+			if total[operation] == nil {
+				total[operation] = map[string]int64{}
+			}
+			for cause, n := range causes {
+				total[operation][cause] += n
+			}
+		}
+	}
+	return total
+}
+
+// failureLines describes a breakdown of failures, one line per operation and cause in a
+// stable order.
+func failureLines(failures map[string]map[string]int64) []string {
+	lines := []string{}
+	for operation, causes := range failures {
+		for cause, n := range causes {
+			lines = append(lines, operation+" "+cause+": "+strconv.FormatInt(n, 10))
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
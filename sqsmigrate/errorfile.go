@@ -0,0 +1,109 @@
+package sqsmigrate
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	sendFailure   = "send"
+	deleteFailure = "delete"
+)
+
+// ErrorRecord is a single failed send or delete captured in an error file.  It carries
+// enough of the original message that the operation can be attempted again later.
+type ErrorRecord struct {
+	Kind          string                                 `json:"kind"`
+	Source        string                                 `json:"source,omitempty"`
+	ID            string                                 `json:"id"`
+	ReceiptHandle string                                 `json:"receipt_handle"`
+	Body          string                                 `json:"body,omitempty"`
+	Attributes    map[string]types.MessageAttributeValue `json:"attributes,omitempty"`
+	GroupID       string                                 `json:"group_id,omitempty"`
+	DedupID       string                                 `json:"dedup_id,omitempty"`
+	Code          string                                 `json:"code,omitempty"`
+	Message       string                                 `json:"message,omitempty"`
+}
+
+// ErrorFile appends failures as JSON lines, from any number of workers and deleters.  A
+// nil *ErrorFile discards everything so callers don't need to check whether an error
+// file was requested.
+type ErrorFile struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+func OpenErrorFile(path string) (*ErrorFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &ErrorFile{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (e *ErrorFile) recordSend(sourceQueueURL *string, entry *types.SendMessageBatchRequestEntry, receiptHandle *string, failure types.BatchResultErrorEntry) error {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.enc.Encode(ErrorRecord{
+		Kind:          sendFailure,
+		Source:        aws.ToString(sourceQueueURL),
+		ID:            *entry.Id,
+		ReceiptHandle: aws.ToString(receiptHandle),
+		Body:          *entry.MessageBody,
+		Attributes:    entry.MessageAttributes,
+		GroupID:       aws.ToString(entry.MessageGroupId),
+		DedupID:       aws.ToString(entry.MessageDeduplicationId),
+		Code:          aws.ToString(failure.Code),
+		Message:       aws.ToString(failure.Message),
+	})
+}
+
+func (e *ErrorFile) recordDelete(sourceQueueURL *string, entry *types.DeleteMessageBatchRequestEntry, failure types.BatchResultErrorEntry) error {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.enc.Encode(ErrorRecord{
+		Kind:          deleteFailure,
+		Source:        aws.ToString(sourceQueueURL),
+		ID:            *entry.Id,
+		ReceiptHandle: *entry.ReceiptHandle,
+		Code:          aws.ToString(failure.Code),
+		Message:       aws.ToString(failure.Message),
+	})
+}
+
+func (e *ErrorFile) Close() error {
+	if e == nil {
+		return nil
+	}
+	return e.f.Close()
+}
+
+func ReadErrorFile(path string) ([]ErrorRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records := []ErrorRecord{}
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var record ErrorRecord
+		if err := dec.Decode(&record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
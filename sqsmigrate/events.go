@@ -0,0 +1,112 @@
+package sqsmigrate
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Events written with -stream-events.
+const (
+	eventReceived = "received"
+	eventSkipped  = "skipped"
+	eventMigrated = "migrated"
+	eventFailed   = "failed"
+)
+
+// messageEvent is one line of the -stream-events output.
+type messageEvent struct {
+	Time      time.Time `json:"time"`
+	Event     string    `json:"event"`
+	MessageId string    `json:"message_id"`
+	Queue     string    `json:"queue"`
+	Dest      string    `json:"dest,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Code      string    `json:"code,omitempty"`
+	// AgeSeconds is the age of a received message, when it is known.
+	AgeSeconds *float64 `json:"age_seconds,omitempty"`
+	// DryRun marks the migrated events of a dry run, which only would have been sent.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// EventStream writes an ndjson event to stdout for every message received, skipped,
+// migrated or failed with -stream-events, for a process consuming the run as it goes,
+// and hands the same events to the -csv-outcome ledger.  Either may be left out.  The
+// logs stay on stderr.  A nil *EventStream writes nothing.
+type EventStream struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	ledger *OutcomeLedger
+	logger *Logger
+	// stop ends the run once an event can't be written, set when the run starts.
+	stop *Interrupt
+}
+
+// NewEventStream streams events to w when it isn't nil, and to the ledger when there
+// is one.
+func NewEventStream(w io.Writer, ledger *OutcomeLedger, logger *Logger) *EventStream {
+	s := &EventStream{ledger: ledger, logger: logger}
+	if w != nil {
+		s.enc = json.NewEncoder(w)
+	}
+	return s
+}
+
+// StopOn has a failed write stop the run through stop.
+func (s *EventStream) StopOn(stop *Interrupt) {
+	if s != nil {
+		s.stop = stop
+	}
+}
+
+func (s *EventStream) emit(event messageEvent) {
+	if s == nil {
+		return
+	}
+	event.Time = time.Now().UTC()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.enc != nil {
+		if err := s.enc.Encode(event); err != nil {
+			s.logger.Errorln("Encountered an error when attempting to write to the event stream")
+			s.stop.fail(s.logger, err)
+		}
+	}
+	if err := s.ledger.record(event); err != nil {
+		s.logger.Errorln("Encountered an error when attempting to write to the -csv-outcome file")
+		s.stop.fail(s.logger, err)
+	}
+}
+
+// Close finishes the -csv-outcome ledger once the run is over.
+func (s *EventStream) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ledger.close()
+}
+
+// received is emitted for every message received, with its age when known.
+func (s *EventStream) received(id, queue string, age time.Duration, known bool) {
+	event := messageEvent{Event: eventReceived, MessageId: id, Queue: queue}
+	if known {
+		seconds := age.Seconds()
+		event.AgeSeconds = &seconds
+	}
+	s.emit(event)
+}
+
+func (s *EventStream) skipped(id, queue, reason string) {
+	s.emit(messageEvent{Event: eventSkipped, MessageId: id, Queue: queue, Reason: reason})
+}
+
+func (s *EventStream) migrated(id, queue, dest string, dryRun bool) {
+	s.emit(messageEvent{Event: eventMigrated, MessageId: id, Queue: queue, Dest: dest, DryRun: dryRun})
+}
+
+func (s *EventStream) failed(id, queue, dest, code, reason string) {
+	s.emit(messageEvent{Event: eventFailed, MessageId: id, Queue: queue, Dest: dest, Code: code, Reason: reason})
+}
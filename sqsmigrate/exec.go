@@ -0,0 +1,63 @@
+package sqsmigrate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ExecTransform rewrites bodies with an external -transform-exec command, which reads
+// the original body on stdin and writes the new one to stdout.
+type ExecTransform struct {
+	args    []string
+	timeout time.Duration
+	// slots bounds how many copies of the command run at once across every worker.
+	slots chan struct{}
+}
+
+// NewExecTransform prepares a -transform-exec command.  The command is split on spaces
+// and run directly rather than through a shell.
+func NewExecTransform(command string, concurrency int, timeout time.Duration) (*ExecTransform, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no command given")
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return nil, err
+	}
+	return &ExecTransform{args: args, timeout: timeout, slots: make(chan struct{}, concurrency)}, nil
+}
+
+// run pipes the body through the command, which is also given the message ID and source
+// queue name in SQS_MESSAGE_ID and SQS_QUEUE.  A non-zero exit, or running past the
+// timeout, is an error carrying whatever the command wrote to stderr.
+func (t *ExecTransform) run(ctx context.Context, data transformData) (string, error) {
+	t.slots <- struct{}{}
+	defer func() { <-t.slots }()
+
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, t.args[0], t.args[1:]...)
+	cmd.Env = append(os.Environ(), "SQS_MESSAGE_ID="+data.MessageId, "SQS_QUEUE="+data.Queue)
+	cmd.Stdin = strings.NewReader(data.Body)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s", t.timeout)
+		}
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("%s: %s", err, message)
+		}
+		return "", err
+	}
+	if stdout.Len() == 0 {
+		return "", fmt.Errorf("the command wrote an empty body, which SQS does not accept")
+	}
+	return stdout.String(), nil
+}
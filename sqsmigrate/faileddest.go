@@ -0,0 +1,235 @@
+package sqsmigrate
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// send makes one attempt at sending entries to a destination queue, once -rate allows,
+// recording its latency to the -batch-report record.
+func (m *migrator) send(destQueueURL *string, entries []types.SendMessageBatchRequestEntry) *sqs.SendMessageBatchOutput {
+	m.Rate.wait(len(entries))
+	sendStart := time.Now()
+	var resp *sqs.SendMessageBatchOutput
+	var err error
+	callCtx, cancel := callContext(m.ctx, m.BatchTimeout)
+	defer cancel()
+	if m.FIFOSequential {
+		resp, err = m.sendSequential(callCtx, destQueueURL, entries)
+	} else {
+		resp, err = m.DestSvc.SendMessageBatch(callCtx, &sqs.SendMessageBatchInput{
+			QueueUrl: destQueueURL,
+			Entries:  entries,
+		})
+	}
+	m.latency.send.since(sendStart)
+	switch {
+	case err != nil && timedOut(m.ctx, callCtx):
+		m.Logger.Errorf("Sending %d messages to %s took longer than the %s -batch-timeout, recording them as failed sends\n", len(entries), QueueName(aws.ToString(destQueueURL)), m.BatchTimeout)
+		atomic.AddInt64(&m.batchTimeouts, 1)
+		resp = failedSends(resp, entries, func(id *string) types.BatchResultErrorEntry {
+			return timeoutFailure(id, m.BatchTimeout)
+		})
+	case err != nil:
+		m.batchErrors.fail(m.Logger, m.Interrupted, "Error attempting to batch migrate messages to SQS", err)
+		resp = failedSends(resp, entries, func(id *string) types.BatchResultErrorEntry {
+			return requestFailure(id, err)
+		})
+	default:
+		m.batchErrors.succeeded()
+	}
+	for _, failed := range resp.Failed {
+		m.sendFailures.add(*failed.Id)
+	}
+	for _, sent := range resp.Successful {
+		m.sendFailures.forget(*sent.Id)
+	}
+	return resp
+}
+
+// sendFailures counts the failed send attempts of each message across the run with
+// -send-failure-threshold, retries and later receives alike, so a message only goes to
+// -failed-dest once it has failed that many times.  Only messages still failing are
+// remembered.  A nil *sendFailures holds nothing back.
+type sendFailures struct {
+	threshold int
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newSendFailures(threshold int) *sendFailures {
+	if threshold == 0 {
+		return nil
+	}
+	return &sendFailures{threshold: threshold, counts: map[string]int{}}
+}
+
+func (f *sendFailures) add(id string) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.counts[id]++
+}
+
+func (f *sendFailures) forget(id string) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.counts, id)
+}
+
+// reached reports whether a message has failed often enough to be diverted, and how
+// many times it has failed.
+func (f *sendFailures) reached(id string) (bool, int) {
+	if f == nil {
+		return true, 0
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.counts[id] >= f.threshold, f.counts[id]
+}
+
+// RetryBackoff is the total -partial-retry-delay waited across every retry of a batch,
+// counted no further than limit.
+func RetryBackoff(delay time.Duration, retries int, limit time.Duration) time.Duration {
+	total := time.Duration(0)
+	for attempt := 0; attempt < retries && delay > 0 && total < limit; attempt++ {
+		total += delay
+		delay *= 2
+	}
+	return total
+}
+
+// retryFailed sends the failed entries of resp again up to -max-retries times, folding
+// each attempt's results into resp.  Checksum mismatches and sends cut off by
+// -batch-timeout are left alone, as those messages are or may already be on the
+// destination and sending them again would duplicate them.
+// With -partial-retry-delay each attempt waits first, twice as long as the one before.
+func (m *migrator) retryFailed(destQueueURL *string, byID map[string]*types.SendMessageBatchRequestEntry, resp *sqs.SendMessageBatchOutput) {
+	retried := map[string]bool{}
+	defer func() {
+		if len(retried) == 0 {
+			return
+		}
+		exhausted := 0
+		for _, failure := range resp.Failed {
+			if retried[*failure.Id] {
+				exhausted++
+			}
+		}
+		atomic.AddInt64(&m.retryRecovered, int64(len(retried)-exhausted))
+		atomic.AddInt64(&m.retryExhausted, int64(exhausted))
+	}()
+	delay := m.PartialRetryDelay
+	for attempt := 1; attempt <= m.MaxRetries; attempt++ {
+		retry := []*types.SendMessageBatchRequestEntry{}
+		failed := resp.Failed[:0]
+		for _, failure := range resp.Failed {
+			if isChecksumMismatch(failure) || isTimeoutFailure(failure) {
+				failed = append(failed, failure)
+				continue
+			}
+			retry = append(retry, byID[*failure.Id])
+		}
+		if len(retry) == 0 {
+			return
+		}
+		if delay > 0 {
+			m.Logger.Printf("Retrying %d failed sends in %s, attempt %d of %d\n", len(retry), delay, attempt, m.MaxRetries)
+			time.Sleep(delay)
+			delay *= 2
+		} else {
+			m.Logger.Printf("Retrying %d failed sends, attempt %d of %d\n", len(retry), attempt, m.MaxRetries)
+		}
+		entries := make([]types.SendMessageBatchRequestEntry, len(retry))
+		for i, entry := range retry {
+			entries[i] = *entry
+			retried[*entry.Id] = true
+		}
+		result := m.send(destQueueURL, entries)
+		m.verifyChecksums(retry, result)
+		resp.Successful = append(resp.Successful, result.Successful...)
+		resp.Failed = append(failed, result.Failed...)
+	}
+}
+
+// moveToFailedDest sends the messages that still failed after -max-retries to
+// -failed-dest, tagged with the last error in a SendFailedReason attribute, so they can
+// be removed from the source rather than received and failed again on every run.
+// Checksum mismatches and timed out sends stay on the source like without -failed-dest.
+// It returns the results of the messages it moved and the failures that remain.
+func (m *migrator) moveToFailedDest(byID map[string]*types.SendMessageBatchRequestEntry, failures []types.BatchResultErrorEntry) ([]types.SendMessageBatchResultEntry, []types.BatchResultErrorEntry) {
+	if m.FailedDestURL == nil || len(failures) == 0 {
+		return nil, failures
+	}
+	reasons := map[string]types.BatchResultErrorEntry{}
+	entries := []types.SendMessageBatchRequestEntry{}
+	remaining := []types.BatchResultErrorEntry{}
+	for _, failure := range failures {
+		if isChecksumMismatch(failure) || isTimeoutFailure(failure) {
+			remaining = append(remaining, failure)
+			continue
+		}
+		if reached, count := m.sendFailures.reached(*failure.Id); !reached {
+			m.Logger.Printf("Leaving %s on the source after %d of %d failed sends (-send-failure-threshold)\n", *failure.Id, count, m.sendFailures.threshold)
+			remaining = append(remaining, failure)
+			continue
+		}
+		entry := *byID[*failure.Id]
+		entry.MessageAttributes = map[string]types.MessageAttributeValue{}
+		for name, value := range byID[*failure.Id].MessageAttributes {
+			entry.MessageAttributes[name] = value
+		}
+		entry.MessageAttributes[sendFailedAttribute] = types.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(aws.ToString(failure.Code) + ": " + aws.ToString(failure.Message)),
+		}
+		if !m.FailedDestFIFO {
+			entry.MessageGroupId = nil
+			entry.MessageDeduplicationId = nil
+		}
+		reasons[*failure.Id] = failure
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return nil, remaining
+	}
+	callCtx, cancel := callContext(m.ctx, m.BatchTimeout)
+	defer cancel()
+	resp, err := m.DestSvc.SendMessageBatch(callCtx, &sqs.SendMessageBatchInput{
+		QueueUrl: m.FailedDestURL,
+		Entries:  entries,
+	})
+	if err != nil && timedOut(m.ctx, callCtx) {
+		m.Logger.Errorf("Moving %d messages to -failed-dest took longer than the %s -batch-timeout, leaving them on the source\n", len(entries), m.BatchTimeout)
+		atomic.AddInt64(&m.batchTimeouts, 1)
+		return nil, failures
+	}
+	if err != nil {
+		m.batchErrors.fail(m.Logger, m.Interrupted, "Error attempting to move failed sends to the -failed-dest queue", err)
+		return nil, failures
+	}
+	m.batchErrors.succeeded()
+	for _, failed := range resp.Failed {
+		m.Logger.Errorf("err moving %s to -failed-dest - %s", *failed.Id, aws.ToString(failed.Message))
+		remaining = append(remaining, reasons[*failed.Id])
+	}
+	for _, moved := range resp.Successful {
+		m.sendFailures.forget(*moved.Id)
+	}
+	atomic.AddInt64(&m.movedToFailedDest, int64(len(resp.Successful)))
+	if len(resp.Successful) > 0 {
+		m.Logger.Printf("Moved %d messages that failed to send to -failed-dest\n", len(resp.Successful))
+	}
+	return resp.Successful, remaining
+}
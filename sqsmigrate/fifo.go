@@ -0,0 +1,228 @@
+package sqsmigrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// groupIDData is what a -group-id-template is rendered against for each message.
+type groupIDData struct {
+	GroupID string
+	Queue   string
+}
+
+func ParseGroupIDTemplate(text string) (*template.Template, error) {
+	return template.New("group-id").Option("missingkey=error").Parse(text)
+}
+
+// remapGroupID computes the destination MessageGroupId for a FIFO message.  With dedupID
+// set the original MessageDeduplicationId is carried over untouched, so a message SQS
+// has already deduplicated on the source won't be let through twice on the destination.
+func remapGroupID(tmpl *template.Template, queue string, message *types.Message, entry *types.SendMessageBatchRequestEntry, dedupID bool) error {
+	var groupID strings.Builder
+	err := tmpl.Execute(&groupID, groupIDData{
+		GroupID: message.Attributes[string(types.MessageSystemAttributeNameMessageGroupId)],
+		Queue:   queue,
+	})
+	if err != nil {
+		return err
+	}
+	if groupID.Len() > 0 {
+		entry.MessageGroupId = aws.String(groupID.String())
+	}
+	if id := message.Attributes[string(types.MessageSystemAttributeNameMessageDeduplicationId)]; dedupID && id != "" {
+		entry.MessageDeduplicationId = aws.String(id)
+	}
+	return nil
+}
+
+// carryGroupID copies the MessageGroupId of a message from a FIFO source onto its entry
+// for a FIFO destination, which rejects messages without one, along with its
+// MessageDeduplicationId when dedupID is set.
+func carryGroupID(message *types.Message, entry *types.SendMessageBatchRequestEntry, dedupID bool) {
+	if groupID := message.Attributes[string(types.MessageSystemAttributeNameMessageGroupId)]; groupID != "" {
+		entry.MessageGroupId = aws.String(groupID)
+	}
+	if id := message.Attributes[string(types.MessageSystemAttributeNameMessageDeduplicationId)]; dedupID && id != "" {
+		entry.MessageDeduplicationId = aws.String(id)
+	}
+}
+
+// GroupIDSource derives a MessageGroupId for each message with -group-id-from, from a
+// message attribute or, for a value starting with $, a field of the JSON body.  Messages
+// without it fall back to the -group-id.
+type GroupIDSource struct {
+	attribute string
+	path      []interface{}
+	fallback  string
+}
+
+func ParseGroupIDSource(from, fallback string) (*GroupIDSource, error) {
+	source := &GroupIDSource{fallback: fallback}
+	if !strings.HasPrefix(from, "$") {
+		source.attribute = from
+		return source, nil
+	}
+	path, err := ParseJSONPath(from)
+	if err != nil {
+		return nil, err
+	}
+	source.path = path
+	return source, nil
+}
+
+// groupID returns the group a message belongs in, given its body after any -unwrap-sns.
+func (g *GroupIDSource) groupID(message *types.Message, body string) string {
+	if g.attribute != "" {
+		if value, ok := message.MessageAttributes[g.attribute]; ok && aws.ToString(value.StringValue) != "" {
+			return aws.ToString(value.StringValue)
+		}
+	}
+	if g.path != nil {
+		var doc interface{}
+		if json.Unmarshal([]byte(body), &doc) == nil {
+			if value, ok := lookupJSONPath(doc, g.path); ok {
+				if groupID, ok := jsonScalar(value); ok && groupID != "" {
+					return groupID
+				}
+			}
+		}
+	}
+	return g.fallback
+}
+
+// bodyDeduplicationID derives a MessageDeduplicationId from the body as sent, so sending
+// the same message again within the queue's 5 minute deduplication interval, such as
+// when retrying a partially failed run, is dropped by SQS instead of duplicated.  A
+// destination deduplicating across the whole queue would also collapse identical bodies
+// in different groups, so for those the group is hashed in too, keeping the IDs unique
+// per group as they are on a queue deduplicating by message group.
+func bodyDeduplicationID(body, groupID, scope string) string {
+	if scope == DedupScopeQueue && groupID != "" {
+		return fmt.Sprintf("%x", sha256.Sum256([]byte(groupID+"\x00"+body)))
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(body)))
+}
+
+// keyDeduplicationID turns a -dedup-from business key into a MessageDeduplicationId.
+// Keys SQS would reject, being over 128 characters or holding anything but letters,
+// digits and punctuation, are hashed instead, which keeps them just as stable.
+func keyDeduplicationID(key string) string {
+	valid := len(key) <= 128
+	for _, r := range key {
+		if r < '!' || r > '~' {
+			valid = false
+			break
+		}
+	}
+	if valid {
+		return key
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(key)))
+}
+
+// dedupKey returns the -dedup-from field of a body after any -unwrap-sns, if it is there
+// and not empty.
+func dedupKey(path []interface{}, body string) (string, bool) {
+	var doc interface{}
+	if json.Unmarshal([]byte(body), &doc) != nil {
+		return "", false
+	}
+	value, ok := lookupJSONPath(doc, path)
+	if !ok {
+		return "", false
+	}
+	key, ok := jsonScalar(value)
+	return key, ok && key != ""
+}
+
+// Values of the DeduplicationScope and FifoThroughputLimit attributes of a FIFO queue.
+const (
+	DedupScopeQueue         = "queue"
+	DedupScopeMessageGroup  = "messageGroup"
+	ThroughputPerQueue      = "perQueue"
+	ThroughputPerGroupLimit = "perMessageGroupId"
+)
+
+// FIFOSettings are the deduplication settings of a FIFO queue.
+type FIFOSettings struct {
+	contentBased    bool
+	DedupScope      string
+	throughputLimit string
+}
+
+func FIFOQueueSettings(ctx context.Context, sqsSvc *sqs.Client, queueURL *string) (FIFOSettings, error) {
+	resp, err := sqsSvc.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl: queueURL,
+		AttributeNames: []types.QueueAttributeName{
+			types.QueueAttributeNameContentBasedDeduplication,
+			types.QueueAttributeNameDeduplicationScope,
+			types.QueueAttributeNameFifoThroughputLimit,
+		},
+	})
+	if err != nil {
+		return FIFOSettings{}, err
+	}
+	settings := FIFOSettings{
+		contentBased:    resp.Attributes[string(types.QueueAttributeNameContentBasedDeduplication)] == "true",
+		DedupScope:      resp.Attributes[string(types.QueueAttributeNameDeduplicationScope)],
+		throughputLimit: resp.Attributes[string(types.QueueAttributeNameFifoThroughputLimit)],
+	}
+	if settings.DedupScope == "" {
+		settings.DedupScope = DedupScopeQueue
+	}
+	if settings.throughputLimit == "" {
+		settings.throughputLimit = ThroughputPerQueue
+	}
+	return settings, nil
+}
+
+// DedupConflicts lists the ways the deduplication strategy of a migration doesn't fit
+// the destination's settings.  carried is whether the sources' MessageDeduplicationIds
+// are carried over, by -group-id-template or along with their groups, sourceScopes holds the scopes of the FIFO
+// sources, and oneGroup is whether every message ends up in the same group.
+func DedupConflicts(dest FIFOSettings, dedupFromBody, carried bool, sourceScopes []string, oneGroup bool) []string {
+	conflicts := []string{}
+	if !dest.contentBased && !dedupFromBody && !carried {
+		conflicts = append(conflicts, "the destination has no ContentBasedDeduplication and nothing sets a MessageDeduplicationId, so every send will be rejected; use -dedup-from-body")
+	}
+	if carried && dest.DedupScope == DedupScopeQueue {
+		for _, scope := range sourceScopes {
+			if scope == DedupScopeMessageGroup {
+				conflicts = append(conflicts, "a source deduplicates by message group but the destination across the whole queue, so carried over deduplication IDs repeated in different groups will be dropped")
+				break
+			}
+		}
+	}
+	if dest.throughputLimit == ThroughputPerGroupLimit && oneGroup {
+		conflicts = append(conflicts, "the destination is set up for high throughput per message group, but every message goes into the same group, which caps the migration at a single group's throughput")
+	}
+	return conflicts
+}
+
+// IsFIFO reports whether a -source/-dest value names a FIFO queue.
+func IsFIFO(queue string) bool {
+	return strings.HasSuffix(QueueName(queue), ".fifo")
+}
+
+// QueueName returns the bare queue name for a -source/-dest value, which may be an ARN or
+// a queue URL.
+func QueueName(queue string) string {
+	if IsQueueURL(queue) {
+		return path.Base(queue)
+	}
+	if queueARN, err := arn.Parse(queue); err == nil {
+		return queueARN.Resource
+	}
+	return queue
+}
@@ -0,0 +1,31 @@
+package sqsmigrate
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func TestRemapGroupIDDedupID(t *testing.T) {
+	tmpl, err := ParseGroupIDTemplate("{{.Queue}}-{{.GroupID}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := &types.Message{Attributes: map[string]string{
+		string(types.MessageSystemAttributeNameMessageGroupId):         "orders",
+		string(types.MessageSystemAttributeNameMessageDeduplicationId): "dedup",
+	}}
+	for _, dedupID := range []bool{true, false} {
+		entry := &types.SendMessageBatchRequestEntry{}
+		if err := remapGroupID(tmpl, "source.fifo", message, entry, dedupID); err != nil {
+			t.Fatal(err)
+		}
+		if aws.ToString(entry.MessageGroupId) != "source.fifo-orders" {
+			t.Errorf("got group ID %q", aws.ToString(entry.MessageGroupId))
+		}
+		if carried := entry.MessageDeduplicationId != nil; carried != dedupID {
+			t.Errorf("with -copy-dedup-id=%t the deduplication ID was carried: %t", dedupID, carried)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package sqsmigrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// age is how long ago the message was originally sent to the source queue, or created
+// according to its body with -age-from.  Some SQS-compatible servers don't return
+// SentTimestamp, in which case ok is false.
+func (m *migrator) age(message *types.Message) (age time.Duration, ok bool) {
+	if m.AgeFrom != nil {
+		body, _ := m.payload(aws.ToString(message.Body))
+		if created, ok := m.AgeFrom.created(body); ok {
+			return m.RunTime.Sub(created), true
+		}
+	}
+	sentTimestamp, err := strconv.ParseInt(message.Attributes[string(types.MessageSystemAttributeNameSentTimestamp)], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	timeSent := time.Unix(sentTimestamp/1000, 0)
+	return m.RunTime.Sub(timeSent), true
+}
+
+// matches reports whether a received message passes every configured filter, logging
+// why it didn't in verbose mode.
+func (m *migrator) matches(message *types.Message) bool {
+	reason := m.skipReason(message)
+	if reason != "" && m.Verbose {
+		m.Logger.Printf("Not migrating %s: %s\n", *message.MessageId, reason)
+	}
+	if reason != "" {
+		m.Events.skipped(*message.MessageId, m.SourceName, reason)
+	}
+	return reason == ""
+}
+
+// skipReason describes the first filter a message fails, or is empty if it passes them
+// all.
+func (m *migrator) skipReason(message *types.Message) string {
+	// The -skip-until-id has to be seen whatever the other filters make of it.
+	if !m.SkipUntil.passes(aws.ToString(message.MessageId)) {
+		return "received before -skip-until-id"
+	}
+	if m.SkipIDs[aws.ToString(message.MessageId)] {
+		return "listed in -skip-ids-file"
+	}
+	if m.HashModulo != nil {
+		if !m.HashModulo.selects(aws.ToString(message.MessageId)) {
+			atomic.AddInt64(&m.hashSkipped, 1)
+			return "outside -hash-modulo " + m.HashModulo.String()
+		}
+		atomic.AddInt64(&m.hashSelected, 1)
+	}
+
+	// A -max-age of 0 turns the age check off altogether.
+	age, ok := m.age(message)
+	if m.MaxMessageAge > 0 && ok && age >= m.MaxMessageAge {
+		return fmt.Sprintf("too old: %s >= %s", age.Round(time.Second), m.MaxMessageAge)
+	}
+	if m.MaxMessageAge > 0 && !ok && !m.Compat.relaxed() {
+		return "no SentTimestamp to check its age against"
+	}
+
+	if m.SenderID != "" {
+		sender := message.Attributes[string(types.MessageSystemAttributeNameSenderId)]
+		if !matchesSender(sender, m.SenderID) {
+			return fmt.Sprintf("sender mismatch: %q", sender)
+		}
+	}
+
+	for _, f := range m.AttrFilters {
+		if !f.matches(message.MessageAttributes) {
+			return "attr filter miss: " + f.text
+		}
+	}
+
+	body := aws.ToString(message.Body)
+	size := messageSize(body, message.MessageAttributes, m.SizeIncludesAttributes)
+	if size < m.MinBodyBytes {
+		return fmt.Sprintf("too small: %d < %d bytes", size, m.MinBodyBytes)
+	}
+	if m.MaxBodyBytes > 0 && size > m.MaxBodyBytes {
+		return fmt.Sprintf("too large: %d > %d bytes", size, m.MaxBodyBytes)
+	}
+
+	body, _ = m.payload(body)
+	if len(m.JSONFilters) > 0 {
+		var doc interface{}
+		if err := json.Unmarshal([]byte(body), &doc); err != nil {
+			return "body is not JSON"
+		}
+		for _, f := range m.JSONFilters {
+			if !f.matches(doc) {
+				return "json filter miss: " + f.text
+			}
+		}
+	}
+
+	if len(m.Filters) == 0 && len(m.FilterPatterns) == 0 && len(m.FilterFields) == 0 && len(m.FiltersAll) == 0 && len(m.FilterConfig) == 0 {
+		return ""
+	}
+	if isBinary(body) && !m.ForceText {
+		// Text filters can't meaningfully match binary payloads.
+		return "binary body, filters only match text without -force-text"
+	}
+	if reason := m.FilterConfig.skipReason(body); reason != "" {
+		return reason
+	}
+	for _, filter := range m.FiltersAll {
+		if !strings.Contains(body, filter) {
+			return fmt.Sprintf("filter-all miss: %q", filter)
+		}
+	}
+	return m.filterMiss(body)
+}
+
+// filterMiss matches a body against the -filter patterns the way -filter-mode says, any
+// one of them matching being enough.
+func (m *migrator) filterMiss(body string) string {
+	switch {
+	case len(m.FilterPatterns) > 0:
+		for _, pattern := range m.FilterPatterns {
+			if pattern.MatchString(body) {
+				return ""
+			}
+		}
+	case len(m.FilterFields) > 0:
+		var doc interface{}
+		if err := json.Unmarshal([]byte(body), &doc); err != nil {
+			return "body is not JSON"
+		}
+		for _, f := range m.FilterFields {
+			if f.matches(doc) {
+				return ""
+			}
+		}
+	case len(m.Filters) > 0:
+		for _, filter := range m.Filters {
+			if strings.Contains(body, filter) {
+				return ""
+			}
+		}
+	default:
+		return ""
+	}
+	return "filter miss"
+}
+
+// drops reports whether a message's body contains one of the -drop-matching patterns.
+func (m *migrator) drops(message *types.Message) bool {
+	if len(m.DropMatching) == 0 {
+		return false
+	}
+	body, _ := m.payload(aws.ToString(message.Body))
+	if isBinary(body) && !m.ForceText {
+		return false
+	}
+	for _, pattern := range m.DropMatching {
+		if strings.Contains(body, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesSender compares a message's SenderId against -sender-id.  Messages sent from an
+// assumed role carry "<role id>:<session name>", so the role ID alone also matches.
+func matchesSender(senderID, want string) bool {
+	return senderID == want || strings.HasPrefix(senderID, want+":")
+}
+
+// Ways -filter-mode matches the -filter patterns against a body.
+const (
+	FilterModeContains = "contains"
+	FilterModeRegex    = "regex"
+	FilterModeJSONPath = "jsonpath"
+)
+
+// CompileFilters turns the -filter patterns into regular expressions or $.path=value
+// fields for -filter-mode regex or jsonpath.  Commas are common in both, so -filter is
+// taken whole rather than split, with any -filter-file lines after it.
+func CompileFilters(mode, filter, filterFile string) ([]*regexp.Regexp, []JSONFilter, error) {
+	patterns, err := ParseFilters("", filterFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if filter != "" {
+		patterns = append([]string{filter}, patterns...)
+	}
+	regexps := []*regexp.Regexp{}
+	fields := []JSONFilter{}
+	for _, pattern := range patterns {
+		if mode == FilterModeRegex {
+			compiled, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, nil, fmt.Errorf("-filter %q is not a valid regular expression: %w", pattern, err)
+			}
+			regexps = append(regexps, compiled)
+			continue
+		}
+		field, err := parseJSONFilter(pattern)
+		if err != nil {
+			return nil, nil, err
+		}
+		fields = append(fields, field)
+	}
+	return regexps, fields, nil
+}
+
+// ParseFilters combines the comma separated -filter value with the patterns in
+// -filter-file, one per line.  A message matches if its body contains any of them.
+func ParseFilters(filter, filterFile string) ([]string, error) {
+	filters := []string{}
+	for _, pattern := range strings.Split(filter, ",") {
+		if pattern != "" {
+			filters = append(filters, pattern)
+		}
+	}
+	if filterFile == "" {
+		return filters, nil
+	}
+
+	contents, err := ioutil.ReadFile(filterFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, pattern := range strings.Split(string(contents), "\n") {
+		if pattern = strings.TrimRight(pattern, "\r"); pattern != "" {
+			filters = append(filters, pattern)
+		}
+	}
+	return filters, nil
+}
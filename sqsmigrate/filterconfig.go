@@ -0,0 +1,133 @@
+package sqsmigrate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// Actions of a -filter-config rule.
+const (
+	filterInclude = "include"
+	filterExclude = "exclude"
+	filterRoute   = "route"
+)
+
+// filterRule is one line of a -filter-config file: a named regular expression and what
+// to do with the messages whose body it matches.
+type filterRule struct {
+	name    string
+	action  string
+	pattern *regexp.Regexp
+	// dest is the queue a route rule sends its messages to.
+	dest string
+}
+
+// FilterConfig is the rules of a -filter-config file in the order they are evaluated.
+// The first rule matching a body decides its fate.
+type FilterConfig []filterRule
+
+// LoadFilterConfig reads a -filter-config file.  Each line is a rule name, an action of
+// include, exclude or route=QUEUE, and a regular expression taking up the rest of the
+// line, separated by whitespace.  Blank lines and lines starting with # are ignored.
+// Every pattern is compiled up front so a bad one fails the run before anything moves.
+func LoadFilterConfig(path string) (FilterConfig, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := FilterConfig{}
+	names := map[string]bool{}
+	for i, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: need a rule name, an action and a pattern", i+1)
+		}
+		rule := filterRule{name: fields[0], action: fields[1]}
+		if names[rule.name] {
+			return nil, fmt.Errorf("line %d: rule %s is defined twice", i+1, rule.name)
+		}
+		names[rule.name] = true
+		if strings.HasPrefix(rule.action, filterRoute+"=") {
+			rule.action, rule.dest = filterRoute, strings.TrimPrefix(rule.action, filterRoute+"=")
+		}
+		switch {
+		case rule.action == filterRoute && rule.dest == "":
+			return nil, fmt.Errorf("line %d: rule %s needs a queue to route to, as route=QUEUE", i+1, rule.name)
+		case rule.action != filterInclude && rule.action != filterExclude && rule.action != filterRoute:
+			return nil, fmt.Errorf("line %d: rule %s has an action of %q rather than include, exclude or route=QUEUE", i+1, rule.name, fields[1])
+		}
+		// The pattern is the rest of the line, so it may contain spaces of its own.
+		pattern := strings.TrimSpace(line[len(fields[0]):])
+		pattern = strings.TrimSpace(pattern[len(fields[1]):])
+		if rule.pattern, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("line %d: rule %s: %s", i+1, rule.name, err)
+		}
+		config = append(config, rule)
+	}
+	return config, nil
+}
+
+// match is the first rule whose pattern matches the body, or nil when none does.
+func (c FilterConfig) match(body string) *filterRule {
+	for i := range c {
+		if c[i].pattern.MatchString(body) {
+			return &c[i]
+		}
+	}
+	return nil
+}
+
+// allowlist reports whether the config has include or route rules, in which case a body
+// matching no rule at all isn't migrated, as with -filter.
+func (c FilterConfig) allowlist() bool {
+	for _, rule := range c {
+		if rule.action != filterExclude {
+			return true
+		}
+	}
+	return false
+}
+
+// skipReason describes why the config keeps a body from being migrated, or is empty if
+// it doesn't.
+func (c FilterConfig) skipReason(body string) string {
+	if len(c) == 0 {
+		return ""
+	}
+	rule := c.match(body)
+	switch {
+	case rule == nil && c.allowlist():
+		return "no filter-config rule matches"
+	case rule != nil && rule.action == filterExclude:
+		return "excluded by filter-config rule " + rule.name
+	}
+	return ""
+}
+
+// destination is the queue the first matching route rule sends the body to, or empty
+// when it goes to the usual destination.
+func (c FilterConfig) destination(body string) string {
+	if rule := c.match(body); rule != nil && rule.action == filterRoute {
+		return rule.dest
+	}
+	return ""
+}
+
+// Destinations lists the queues the config routes to, each once.
+func (c FilterConfig) Destinations() []string {
+	seen := map[string]bool{}
+	dests := []string{}
+	for _, rule := range c {
+		if rule.action == filterRoute && !seen[rule.dest] {
+			seen[rule.dest] = true
+			dests = append(dests, rule.dest)
+		}
+	}
+	return dests
+}
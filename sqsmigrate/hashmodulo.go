@@ -0,0 +1,49 @@
+package sqsmigrate
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// HashModulo is a -hash-modulo N/M selection: a message is migrated when the hash of its
+// MessageId modulo M is below N.  The same MessageId always lands on the same side, so
+// a canary of N/M of a queue stays the same subset across runs.  A nil *HashModulo
+// selects everything.
+type HashModulo struct {
+	n, m uint64
+}
+
+func ParseHashModulo(text string) (*HashModulo, error) {
+	parts := strings.Split(text, "/")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("-hash-modulo %q is not of the form N/M", text)
+	}
+	n, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("-hash-modulo %q has an N that isn't a whole number", text)
+	}
+	m, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil || m == 0 {
+		return nil, fmt.Errorf("-hash-modulo %q has an M that isn't a positive whole number", text)
+	}
+	if n > m {
+		return nil, fmt.Errorf("-hash-modulo %q selects more than all of the messages", text)
+	}
+	return &HashModulo{n: n, m: m}, nil
+}
+
+// selects reports whether the message with this MessageId is part of the subset.
+func (h *HashModulo) selects(id string) bool {
+	if h == nil {
+		return true
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(id))
+	return hash.Sum64()%h.m < h.n
+}
+
+func (h *HashModulo) String() string {
+	return fmt.Sprintf("%d/%d", h.n, h.m)
+}
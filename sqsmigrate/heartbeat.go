@@ -0,0 +1,101 @@
+package sqsmigrate
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// heartbeat keeps a batch of received messages invisible on the source while it is being
+// sent, extending their visibility timeout every -heartbeat-interval by
+// -heartbeat-extend.  A nil *heartbeat does nothing.
+type heartbeat struct {
+	stopped chan struct{}
+	done    chan struct{}
+}
+
+// heartbeatTimings works out the interval and extension of a heartbeat for a batch
+// received with the given visibility timeout.  Unset, the visibility timeout is extended
+// by its own length each time half of it has passed.
+func (m *migrator) heartbeatTimings(visibility time.Duration) (interval, extend time.Duration) {
+	interval, extend = m.HeartbeatInterval, m.HeartbeatExtend
+	if extend == 0 {
+		extend = visibility
+	}
+	if interval == 0 {
+		interval = extend / 2
+	}
+	return interval, extend
+}
+
+// startHeartbeat begins extending the visibility of the staged messages of a batch until
+// stop is called.
+func (m *migrator) startHeartbeat(idsToReceipts map[string]*string) *heartbeat {
+	if !m.Heartbeat || !m.Execute || len(idsToReceipts) == 0 {
+		return nil
+	}
+	interval, extend := m.heartbeatTimings(time.Duration(m.visibilityTimeout()) * time.Second)
+	entries := []types.ChangeMessageVisibilityBatchRequestEntry{}
+	for _, receipt := range idsToReceipts {
+		entries = append(entries, types.ChangeMessageVisibilityBatchRequestEntry{
+			Id:                aws.String(strconv.Itoa(len(entries))),
+			ReceiptHandle:     receipt,
+			VisibilityTimeout: int32(extend.Seconds()),
+		})
+	}
+
+	h := &heartbeat{stopped: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(h.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-h.stopped:
+				return
+			case <-ticker.C:
+				m.extendVisibility(entries)
+			}
+		}
+	}()
+	return h
+}
+
+// extendVisibility sends one heartbeat.  A failed heartbeat only risks the batch being
+// received again, so it is logged rather than ending the run.
+func (m *migrator) extendVisibility(entries []types.ChangeMessageVisibilityBatchRequestEntry) {
+	for start := 0; start < len(entries); start += BatchSize {
+		end := start + BatchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		resp, err := m.sqsSvc.ChangeMessageVisibilityBatch(m.ctx, &sqs.ChangeMessageVisibilityBatchInput{
+			QueueUrl: m.SourceQueueURL,
+			Entries:  entries[start:end],
+		})
+		if err != nil {
+			m.failures.add("visibility", errorCode(err))
+			m.Logger.Errorf("Encountered an error when attempting to extend the visibility of a batch: %s\n", err)
+			continue
+		}
+		for _, failed := range resp.Failed {
+			m.failures.add("visibility", aws.ToString(failed.Code))
+			m.Logger.Errorf("err extending visibility - %s", aws.ToString(failed.Message))
+		}
+		if m.Verbose {
+			m.Logger.Printf("Extended the visibility of %d messages by %ds\n", len(resp.Successful), entries[start].VisibilityTimeout)
+		}
+	}
+}
+
+// stop ends the heartbeat and waits for one in progress to finish.
+func (h *heartbeat) stop() {
+	if h == nil {
+		return
+	}
+	close(h.stopped)
+	<-h.done
+}
@@ -0,0 +1,44 @@
+package sqsmigrate
+
+import (
+	"bufio"
+	"os"
+	"sync"
+)
+
+// IDFile writes the IDs of matching messages to the -ids-file, one per line, so they
+// can be cross-referenced with other logs.  A nil *IDFile discards everything.
+type IDFile struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *bufio.Writer
+}
+
+func CreateIDFile(path string) (*IDFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &IDFile{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+func (i *IDFile) record(id string) error {
+	if i == nil {
+		return nil
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	_, err := i.w.WriteString(id + "\n")
+	return err
+}
+
+func (i *IDFile) Close() error {
+	if i == nil {
+		return nil
+	}
+	if err := i.w.Flush(); err != nil {
+		i.f.Close()
+		return err
+	}
+	return i.f.Close()
+}
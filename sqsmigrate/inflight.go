@@ -0,0 +1,80 @@
+package sqsmigrate
+
+import "sync"
+
+// InFlight caps how many received messages may be held across all workers before they
+// are either deleted from the source or let go.  Holding too many at once risks them all
+// passing their visibility timeout together if sends stall.  A nil *InFlight imposes no
+// cap.
+type InFlight struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	max  int
+	held int
+}
+
+func NewInFlight(max int) *InFlight {
+	f := &InFlight{max: max}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+// acquire blocks until there is room for at least one more message and returns how many
+// of the n requested may be received.
+func (f *InFlight) acquire(n int) int {
+	if f == nil {
+		return n
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for f.held >= f.max {
+		f.cond.Wait()
+	}
+	if available := f.max - f.held; n > available {
+		n = available
+	}
+	f.held += n
+	return n
+}
+
+func (f *InFlight) release(n int) {
+	if f == nil || n == 0 {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.held -= n
+	f.cond.Broadcast()
+}
+
+// BatchLimit caps how many batches are in the send and delete pipeline at once across
+// all workers with -max-in-flight-batches, from their receive until the last of their
+// deletes is done.  A worker blocks before its next receive while the pipeline is full.
+// A nil *BatchLimit imposes no cap.
+type BatchLimit struct {
+	slots chan struct{}
+}
+
+func NewBatchLimit(max int) *BatchLimit {
+	return &BatchLimit{slots: make(chan struct{}, max)}
+}
+
+func (l *BatchLimit) acquire() {
+	if l != nil {
+		l.slots <- struct{}{}
+	}
+}
+
+func (l *BatchLimit) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+// held is how many batches are in the pipeline right now.
+func (l *BatchLimit) held() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.slots)
+}
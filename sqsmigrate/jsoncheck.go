@@ -0,0 +1,43 @@
+package sqsmigrate
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// Policies for -on-invalid-json.
+const (
+	InvalidJSONSkip  = "skip"
+	InvalidJSONError = "error"
+)
+
+// invalidJSON handles a message whose body fails -require-json.  With -invalid-dest it
+// is moved there as it was received, skipping any transform, otherwise it is left on
+// the source or ends the run depending on -on-invalid-json.
+func (m *migrator) invalidJSON(message *types.Message) *types.SendMessageBatchRequestEntry {
+	atomic.AddInt64(&m.invalidBodies, 1)
+	if m.InvalidDest != "" {
+		if err := m.Routes.assign(*message.MessageId, m.InvalidDest); err != nil {
+			m.unroutable(message, err)
+			return nil
+		}
+		m.Logger.Printf("Moving message %s to -invalid-dest, its body is not valid JSON\n", *message.MessageId)
+		entry := &types.SendMessageBatchRequestEntry{
+			Id:          message.MessageId,
+			MessageBody: message.Body,
+		}
+		if m.CopyAttributes {
+			entry.MessageAttributes = message.MessageAttributes
+		}
+		return entry
+	}
+	if m.OnInvalidJSON == InvalidJSONError {
+		m.Interrupted.fail(m.Logger, fmt.Errorf("message %s has a body that is not valid JSON (-require-json)", *message.MessageId))
+		return nil
+	}
+	m.Logger.Printf("Skipping message %s, its body is not valid JSON\n", *message.MessageId)
+	m.Events.skipped(*message.MessageId, m.SourceName, "body is not valid JSON")
+	return nil
+}
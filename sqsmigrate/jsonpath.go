@@ -0,0 +1,136 @@
+package sqsmigrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// JSONFilter keeps messages whose body is JSON with the value at path equal to want.
+type JSONFilter struct {
+	text string
+	path []interface{}
+	want interface{}
+}
+
+// parseJSONFilter parses a -json-filter of the form $.path.to.field=value, where the
+// path may index into arrays with [n].  A value that is valid JSON, such as 5, true or
+// "5", is compared as that JSON value, anything else is compared as a string.
+func parseJSONFilter(text string) (JSONFilter, error) {
+	eq := strings.Index(text, "=")
+	if eq < 0 || !strings.HasPrefix(text, "$") {
+		return JSONFilter{}, fmt.Errorf("%q is not of the form $.path=value", text)
+	}
+	path, err := ParseJSONPath(text[:eq])
+	if err != nil {
+		return JSONFilter{}, err
+	}
+	f := JSONFilter{text: text, path: path}
+	value := text[eq+1:]
+	if err := json.Unmarshal([]byte(value), &f.want); err != nil {
+		f.want = value
+	}
+	return f, nil
+}
+
+// matches reports whether the document holds the wanted value at the filter's path.
+func (f JSONFilter) matches(doc interface{}) bool {
+	value, ok := lookupJSONPath(doc, f.path)
+	return ok && reflect.DeepEqual(value, f.want)
+}
+
+// ParseJSONPath parses a path such as $.path.to.field or $.items[0].id into its steps,
+// a string for each field and an int for each array index.
+func ParseJSONPath(text string) ([]interface{}, error) {
+	if !strings.HasPrefix(text, "$") {
+		return nil, fmt.Errorf("%q is not a path starting with $", text)
+	}
+	path := []interface{}{}
+	rest := text[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[") + 1
+			if end == 0 {
+				end = len(rest)
+			}
+			if end == 1 {
+				return nil, fmt.Errorf("%q has an empty field name", text)
+			}
+			path = append(path, rest[1:end])
+			rest = rest[end:]
+		case '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("%q has an unclosed [", text)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("%q has an invalid array index %q", text, rest[1:end])
+			}
+			path = append(path, index)
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("%q has an unexpected %q in its path", text, rest[0])
+		}
+	}
+	return path, nil
+}
+
+// jsonScalar renders a string or number found in a JSON document as text, reporting
+// false for anything else.
+func jsonScalar(value interface{}) (string, bool) {
+	switch value := value.(type) {
+	case string:
+		return value, true
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	default:
+		return "", false
+	}
+}
+
+// lookupJSONPath returns the value at path in a decoded JSON document, if there is one.
+func lookupJSONPath(doc interface{}, path []interface{}) (interface{}, bool) {
+	for _, step := range path {
+		switch step := step.(type) {
+		case string:
+			object, ok := doc.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if doc, ok = object[step]; !ok {
+				return nil, false
+			}
+		case int:
+			array, ok := doc.([]interface{})
+			if !ok || step >= len(array) {
+				return nil, false
+			}
+			doc = array[step]
+		}
+	}
+	return doc, true
+}
+
+// JSONFilterList is a flag.Value collecting a repeatable -json-filter.
+type JSONFilterList []JSONFilter
+
+func (l *JSONFilterList) String() string {
+	texts := []string{}
+	for _, f := range *l {
+		texts = append(texts, f.text)
+	}
+	return strings.Join(texts, ",")
+}
+
+func (l *JSONFilterList) Set(text string) error {
+	f, err := parseJSONFilter(text)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, f)
+	return nil
+}
@@ -0,0 +1,59 @@
+package sqsmigrate
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// Logger is the logger used throughout a run.  Logs go to stderr, leaving stdout for
+// data such as a -format json summary.  Errors go through Errorf, Errorln and the Fatal
+// methods, which with -quiet are the only output left.
+type Logger struct {
+	*log.Logger
+	errs *log.Logger
+	// OnFatal is given the message of a fatal error before the process exits, for
+	// -notify-webhook.
+	OnFatal func(message string)
+}
+
+func NewLogger(quiet, color bool) *Logger {
+	var info, errs io.Writer = os.Stderr, os.Stderr
+	if color {
+		info = colorWriter{w: os.Stderr, pick: infoColor}
+		errs = colorWriter{w: os.Stderr, pick: errorColor}
+	}
+	if quiet {
+		info = ioutil.Discard
+	}
+	return &Logger{Logger: log.New(info, "", log.LstdFlags), errs: log.New(errs, "", log.LstdFlags)}
+}
+
+func (l *Logger) Errorf(format string, v ...interface{}) {
+	l.errs.Output(2, fmt.Sprintf(format, v...))
+}
+
+func (l *Logger) Errorln(v ...interface{}) {
+	l.errs.Output(2, fmt.Sprintln(v...))
+}
+
+func (l *Logger) Fatal(v ...interface{}) {
+	message := fmt.Sprint(v...)
+	l.errs.Output(2, message)
+	l.exit(message)
+}
+
+func (l *Logger) Fatalf(format string, v ...interface{}) {
+	message := fmt.Sprintf(format, v...)
+	l.errs.Output(2, message)
+	l.exit(message)
+}
+
+func (l *Logger) exit(message string) {
+	if l.OnFatal != nil {
+		l.OnFatal(message)
+	}
+	os.Exit(1)
+}
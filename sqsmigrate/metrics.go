@@ -0,0 +1,98 @@
+package sqsmigrate
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// metricPrefix namespaces every metric written with -format prometheus.
+const metricPrefix = "sqs_migrate_"
+
+// WritePrometheus writes the summary in the Prometheus text exposition format, ready to
+// be pushed to a pushgateway.  Every metric is a gauge describing this run, labelled
+// with its source and destination queues.
+func (s Summary) WritePrometheus(w io.Writer) {
+	labels := fmt.Sprintf(`source=%q,dest=%q`, s.Source, s.Dest)
+	gauge := func(name, help string, value float64, extra ...string) {
+		fmt.Fprintf(w, "# HELP %s%s %s\n", metricPrefix, name, help)
+		fmt.Fprintf(w, "# TYPE %s%s gauge\n", metricPrefix, name)
+		fmt.Fprintf(w, "%s%s{%s} %g\n", metricPrefix, name, strings.Join(append([]string{labels}, extra...), ","), value)
+	}
+	execute := 0.0
+	if s.Execute {
+		execute = 1
+	}
+	gauge("execute", "Whether the run migrated messages rather than a dry run.", execute)
+	gauge("processed_messages", "Messages that matched and were staged for migration.", float64(s.Processed))
+	gauge("sent_messages", "Messages sent to the destination.", float64(s.Sent))
+	gauge("send_failed_messages", "Messages the destination rejected.", float64(s.SendFailed))
+	gauge("deleted_messages", "Messages removed from the source.", float64(s.Deleted))
+	gauge("delete_failed_messages", "Messages that could not be removed from the source.", float64(s.DeleteFailed))
+	gauge("expired_receipts", "Sent messages whose receipt handle expired before the delete.", float64(s.ExpiredReceipts))
+	gauge("empty_body_messages", "Messages with an empty body.", float64(s.EmptyBodies))
+	gauge("oversize_messages", "Messages skipped for being over the SQS size limit.", float64(s.Oversize))
+	gauge("sla_breach_messages", "Migrated messages older than -sla-age.", float64(s.SLABreaches))
+	gauge("checksum_mismatch_messages", "Sent messages whose body checksum didn't match, left on the source.", float64(s.ChecksumMismatches))
+	gauge("attribute_checksum_mismatch_messages", "Sent messages whose attribute checksum didn't match, left on the source.", float64(s.AttrMismatches))
+	gauge("failed_dest_messages", "Messages moved to -failed-dest after every send attempt failed.", float64(s.MovedToFailedDest))
+	gauge("ttl_expired_messages", "Matching messages past -ttl, removed from the source rather than migrated.", float64(s.TTLExpired))
+	gauge("batch_errors", "Requests that failed as a whole and were skipped over with -continue-on-error.", float64(s.BatchErrors))
+	gauge("hash_selected_messages", "Messages inside the -hash-modulo subset.", float64(s.HashSelected))
+	gauge("hash_skipped_messages", "Messages outside the -hash-modulo subset, left on the source.", float64(s.HashSkipped))
+	gauge("invalid_json_messages", "Messages whose body failed -require-json.", float64(s.InvalidJSON))
+	gauge("retry_recovered_messages", "Failed sends that succeeded on a -max-retries retry.", float64(s.RetryRecovered))
+	gauge("retry_exhausted_messages", "Failed sends still failing after every -max-retries retry.", float64(s.RetryExhausted))
+	gauge("batch_timeouts", "Send and delete requests cut off by -batch-timeout.", float64(s.BatchTimeouts))
+	gauge("dedup_collision_messages", "Messages sent with the deduplication ID of an earlier one.", float64(s.DedupCollisions))
+	gauge("dropped_messages", "Messages matching -drop-matching, removed from the source rather than migrated.", float64(s.Dropped))
+	gauge("missing_dedup_key_messages", "Messages left on the source without a -dedup-from field.", float64(s.MissingDedupKeys))
+	gauge("unrouted_messages", "Messages left on the source as their -route-by destination couldn't be resolved.", float64(s.Unrouted))
+	gauge("duplicate_messages", "Messages received with a MessageId already seen.", float64(s.Duplicates))
+	gauge("api_calls", "SQS API calls made, including retries.", float64(s.APICalls))
+	gauge("duration_seconds", "How long the run took.", s.DurationSeconds)
+	gauge("messages_per_second", "Processed messages per second.", s.MessagesPerSecond)
+
+	if len(s.Failures) > 0 {
+		fmt.Fprintf(w, "# HELP %sfailures Failed sends, deletes and visibility changes by cause.\n", metricPrefix)
+		fmt.Fprintf(w, "# TYPE %sfailures gauge\n", metricPrefix)
+		operations := []string{}
+		for operation := range s.Failures {
+			operations = append(operations, operation)
+		}
+		sort.Strings(operations)
+		for _, operation := range operations {
+			causes := []string{}
+			for cause := range s.Failures[operation] {
+				causes = append(causes, cause)
+			}
+			sort.Strings(causes)
+			for _, cause := range causes {
+				fmt.Fprintf(w, "%sfailures{%s,operation=%q,cause=%q} %d\n", metricPrefix, labels, operation, cause, s.Failures[operation][cause])
+			}
+		}
+	}
+
+	stages := []string{}
+	for stage := range s.Latency {
+		stages = append(stages, stage)
+	}
+	sort.Strings(stages)
+	fmt.Fprintf(w, "# HELP %sapi_latency_p95_seconds 95th percentile latency of each kind of SQS call.\n", metricPrefix)
+	fmt.Fprintf(w, "# TYPE %sapi_latency_p95_seconds gauge\n", metricPrefix)
+	for _, stage := range stages {
+		fmt.Fprintf(w, "%sapi_latency_p95_seconds{%s,stage=%q} %g\n", metricPrefix, labels, stage, s.Latency[stage].P95Ms/1000)
+	}
+
+	buckets := []string{}
+	for bucket := range s.Sizes {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+	fmt.Fprintf(w, "# HELP %smessage_size_messages Processed messages by size.\n", metricPrefix)
+	fmt.Fprintf(w, "# TYPE %smessage_size_messages gauge\n", metricPrefix)
+	for _, bucket := range buckets {
+		fmt.Fprintf(w, "%smessage_size_messages{%s,size=%q} %d\n", metricPrefix, labels, bucket, s.Sizes[bucket])
+	}
+}
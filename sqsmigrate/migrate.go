@@ -0,0 +1,1172 @@
+// Package sqsmigrate is the engine behind the aws-utils SQS migrator, for use from other
+// Go programs.  Migrate moves the messages of one source queue to a destination with the
+// same receive, filter, send and delete cycle as the command, configured by Options in
+// place of its flags.
+package sqsmigrate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// BatchSize is the most messages SQS receives, sends or deletes in one request.
+const BatchSize = 10
+
+// MaxWaitTimeSeconds is the longest long poll SQS allows on a receive.
+const MaxWaitTimeSeconds = 20
+
+// Policies for messages with an empty body, which SendMessageBatch rejects.
+const (
+	EmptyBodySkip       = "skip"
+	EmptyBodyError      = "error"
+	EmptyBodySubstitute = "substitute"
+)
+
+// API is the part of the SQS client the migrator and its deleter call, satisfied by
+// *sqs.Client, so tests can hand them a fake one.
+type API interface {
+	ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+	SendMessageBatch(ctx context.Context, params *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error)
+	DeleteMessageBatch(ctx context.Context, params *sqs.DeleteMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageBatchOutput, error)
+	ChangeMessageVisibilityBatch(ctx context.Context, params *sqs.ChangeMessageVisibilityBatchInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityBatchOutput, error)
+}
+
+// Options describes a migration from one source queue.  Each field is off, or the
+// command's default, when left zero.  The command shares the ones that are pointers to
+// shared state, such as Budget and Received, between every source of a run.
+type Options struct {
+	// DestSvc sends to the destination, when it needs different credentials or another
+	// region than the source.
+	DestSvc        API
+	Logger         *Logger
+	SourceQueueURL *string
+	DestQueueURL   *string
+	// Workers is how many workers receive and migrate batches in parallel.
+	Workers int
+	// Limit is how many messages to stage, when there is no Budget shared with other
+	// sources.
+	Limit  int
+	Budget *Budget
+	// Routes picks each message's destination with -dest-prefix, instead of DestQueueURL.
+	Routes *Router
+	// VerifyChecksum checks the MD5 SQS reports for each sent body.
+	VerifyChecksum bool
+	// Sample previews every staged message of a -dry-run-sample.
+	Sample bool
+	// ReceiveAttributes are extra system attributes to request on every receive.
+	ReceiveAttributes SystemAttributeList
+	// FetchAttributes are the message attributes to request instead of All.
+	FetchAttributes AttributeNames
+	// FIFOSequential sends each batch in its original order, one SendMessage at a time.
+	FIFOSequential bool
+	// MaxRetries is how many more times a failed send is attempted before it is moved
+	// to FailedDestURL, when there is one, or left on the source.
+	MaxRetries     int
+	FailedDestURL  *string
+	FailedDestFIFO bool
+	Errs           *ErrorFile
+	IDs            *IDFile
+	// SendFailureThreshold holds a message back from FailedDestURL until this many of
+	// its sends have failed, across receives.
+	SendFailureThreshold int
+	// BatchTimeout cuts off a send or delete request that hangs, recording its messages
+	// as failed rather than stalling the run on it.
+	BatchTimeout time.Duration
+	// PartialRetryDelay is the -partial-retry-delay before the first retry of a batch's
+	// failed sends, doubling for each one after.
+	PartialRetryDelay time.Duration
+	// ContinueOnError carries on past a request that fails as a whole, instead of
+	// stopping the run.
+	ContinueOnError bool
+	// ListFailures keeps the messages behind each failure for the summary.
+	ListFailures bool
+	// Output writes each staged message to stdout with -output-template.
+	Output *MessageOutput
+	// RequireJSON turns down bodies that aren't valid JSON, moving them to InvalidDest
+	// when it is set and otherwise handling them by the OnInvalidJSON policy.
+	RequireJSON   bool
+	OnInvalidJSON string
+	InvalidDest   string
+	// Events streams what happens to each message to stdout with -stream-events.
+	Events *EventStream
+	// Script gets the commands of every batch a dry run would have sent.
+	Script *ScriptFile
+
+	// Execute sends and deletes messages, where otherwise the run is only a dry run
+	// reporting what would have been migrated.
+	Execute bool
+	// MaxMessageAge migrates only messages younger than this, when above 0.
+	MaxMessageAge time.Duration
+	// Filters migrates only messages whose body contains one of them, when given.
+	Filters []string
+	// FilterPatterns and FilterFields take the place of Filters with -filter-mode regex
+	// and jsonpath.
+	FilterPatterns []*regexp.Regexp
+	FilterFields   []JSONFilter
+	// DropMatching removes messages containing any of these from the source instead of
+	// migrating them.
+	DropMatching []string
+	// FilterConfig holds the -filter-config rules, evaluated after every other filter.
+	FilterConfig FilterConfig
+	// AttrFilters must all match the message attributes.
+	AttrFilters []AttributeFilter
+	// FiltersAll must all be in the body, on top of matching one of Filters.
+	FiltersAll  []string
+	JSONFilters []JSONFilter
+	ForceText   bool
+	Compat      CompatMode
+	SenderID    string
+	// HashModulo migrates a deterministic subset of the messages by MessageId.
+	HashModulo *HashModulo
+	// SkipUntil and SkipIDs leave the messages of an earlier, partial run on the source.
+	SkipUntil *SkipUntil
+	SkipIDs   map[string]bool
+
+	// MinBodyBytes and MaxBodyBytes bound the message size, which with
+	// SizeIncludesAttributes also counts message attributes the way SQS does.
+	MinBodyBytes           int
+	MaxBodyBytes           int
+	SizeIncludesAttributes bool
+
+	Verbose   bool
+	Histogram bool
+	// Stats profiles every message received, with -limit counting them rather than the
+	// messages staged.
+	Stats bool
+	// Rate paces sends to -rate messages per second, shared by every source.
+	Rate *RateLimit
+	// SLAAge warns about, without filtering, migrated messages older than this.
+	SLAAge time.Duration
+	// TTL removes matching messages older than this from the source instead of
+	// migrating them.
+	TTL     time.Duration
+	RunTime time.Time
+
+	// Delay overrides the delivery delay of every message, otherwise PreserveDelay
+	// applies the DelaySeconds message attribute when present.
+	Delay         *int32
+	PreserveDelay bool
+	// DelayPerReceive adds to the delay for each earlier receive of a message.
+	DelayPerReceive time.Duration
+	// PreserveTimestamp copies ApproximateFirstReceiveTimestamp into a message attribute.
+	PreserveTimestamp bool
+
+	// Transform or TransformExec rewrites each body, with the first ShowDiff rewrites
+	// and attribute changes printed as a diff during a dry run.  OnTransformError
+	// decides whether a failed rewrite skips the message or ends the run.
+	Transform        *template.Template
+	TransformExec    *ExecTransform
+	OnTransformError string
+	ShowDiff         int
+	// Pipeline runs the steps of a -pipeline file in place of the other transforms.
+	Pipeline *Pipeline
+	// Plugins run after any other transform, and may set message attributes too.
+	Plugins []TransformPlugin
+	// BodyPrefix and BodySuffix wrap every body once it has been transformed.
+	BodyPrefix string
+	BodySuffix string
+	// CompressOver gzips bodies longer than this many bytes, when above 0.
+	CompressOver int
+	// PreSend, when set, gets every batch with its entries as they would be sent, dry
+	// runs included, and may leave out, reorder or change its envelopes but not add to
+	// them.  A message it leaves out stays on the source, and an error stops the run
+	// with the batch left unsent, as does a batch returned with more envelopes than it
+	// was given, a nil envelope or Message, or an Entry.Id that is repeated or wasn't in
+	// the batch.
+	PreSend func([]*MessageEnvelope) ([]*MessageEnvelope, error)
+
+	EmptyBody        string
+	EmptyPlaceholder string
+	OnOversize       string
+	// UnwrapSNS filters and transforms the inner message of SNS notifications, which
+	// are sent re-wrapped unless SendUnwrapped is set.
+	UnwrapSNS     bool
+	SendUnwrapped bool
+	// PromoteSNSAttributes turns the MessageAttributes inside SNS notifications into
+	// message attributes on the destination.
+	PromoteSNSAttributes bool
+	// CopyAttributes carries each message's own attributes over, which SetAttributes
+	// are then applied on top of.
+	CopyAttributes bool
+	SetAttributes  AttributeList
+	// RenameAttributes and DropAttributes reshape the copied attributes before the
+	// SetAttributes are added.
+	RenameAttributes AttributeRenames
+	DropAttributes   AttributeNames
+
+	// GroupID is the -group-id-template used to remap FIFO message groups, rendered with
+	// SourceName as the queue name.
+	GroupID    *template.Template
+	SourceName string
+	// GroupIDFrom sets the MessageGroupId of messages from a standard queue instead.
+	GroupIDFrom *GroupIDSource
+	// CarryGroupID keeps the MessageGroupId of messages from a FIFO source when neither
+	// is given, and CarryDedupID their MessageDeduplicationId with it.
+	CarryGroupID bool
+	CarryDedupID bool
+	// DedupFromBody replaces the MessageDeduplicationId with a hash of the sent body,
+	// computed for the destination's DeduplicationScope in DedupScope.
+	DedupFromBody bool
+	DedupScope    string
+	// DedupFrom is the -dedup-from path to the body field used as the
+	// MessageDeduplicationId instead.
+	DedupFrom []interface{}
+	// DedupIDs catches two messages staged with the same deduplication ID, logging a
+	// warning for each or, with FailOnDedupCollision, stopping the run before it's sent.
+	DedupIDs             *DedupTracker
+	FailOnDedupCollision bool
+
+	// AgeFrom takes each message's age from a field of its body instead of SentTimestamp.
+	AgeFrom *AgeSource
+
+	// MaxEmptyDuration keeps the workers polling an empty queue until nothing has been
+	// received for this long.
+	MaxEmptyDuration time.Duration
+	// Tail keeps polling an empty queue until interrupted, backing off after each empty
+	// receive in a row.
+	Tail        bool
+	Interrupted *Interrupt
+	// ExitOnIdle ends a -tail run once nothing has been received for this long, when
+	// above 0.
+	ExitOnIdle time.Duration
+	// WaitTime overrides the long poll of each receive, and PollDelay the pause after an
+	// empty receive that doesn't end the run.
+	WaitTime  *int32
+	PollDelay time.Duration
+	// Paused holds off new receives while the run is paused with SIGUSR2.
+	Paused *Pauser
+	// Backpressure holds off new receives while the destination is over -dest-max-depth.
+	Backpressure *Pauser
+	// NewestFirst scans the source before migrating anything so the newest matching
+	// messages can go first.
+	NewestFirst bool
+	// MinVisibility and MaxVisibility bound the visibility timeout of each receive.
+	MinVisibility time.Duration
+	MaxVisibility time.Duration
+	// Heartbeat extends the visibility of each batch while it is being sent, every
+	// HeartbeatInterval by HeartbeatExtend, either derived from the visibility timeout
+	// when 0.
+	Heartbeat         bool
+	HeartbeatInterval time.Duration
+	HeartbeatExtend   time.Duration
+	// Approval asks the operator about each matched message with -interactive.
+	Approval *Approver
+	// NoDelete leaves sent messages on the source.
+	NoDelete bool
+	// SentBodies records what was sent for -compare-bodies to look for afterwards.
+	SentBodies *SentBodies
+	// Once stops after a single batch.
+	Once bool
+	// BatchDelay pauses each worker between the batches it migrates.
+	BatchDelay time.Duration
+	// SkipDuplicateIDs removes a repeated MessageId from the source without sending it
+	// again, as long as an earlier copy was sent.
+	SkipDuplicateIDs bool
+	Received         *MessageIDs
+
+	Slots    *ConcurrencyController
+	InFlight *InFlight
+	// BatchSlots caps how many batches are between their receive and their deletes.
+	BatchSlots *BatchLimit
+	// Accumulate holds staged messages across receives for up to this long, to send
+	// full batches.
+	Accumulate time.Duration
+	// DeleteConcurrency is how many batches may be deleted from the source at once.
+	DeleteConcurrency int
+	Calls             *APICalls
+
+	// ReleaseNonmatching releases the messages not migrated back to the source.
+	ReleaseNonmatching bool
+
+	// BatchReport gets a line for each batch.
+	BatchReport *BatchReport
+
+	// ProgressReport follows the migration while it runs, for the progress report.
+	ProgressReport *RunProgress
+	// PricePerMillion estimates what the migration costs in the summary.
+	PricePerMillion float64
+}
+
+// migrator moves messages from the source queue to the destination queue.  Each worker
+// runs the receive, filter, send and delete cycle independently, sharing the -limit
+// budget and the background deleter.
+type migrator struct {
+	Options
+	ctx    context.Context
+	sqsSvc API
+
+	// emptyReceives counts the empty receives in a row of a -tail.
+	emptyReceives int64
+	// sendFailures holds a message back from FailedDestURL until enough of its sends
+	// have failed, across receives, with -send-failure-threshold.
+	sendFailures *sendFailures
+	// scanned profiles every message received with -stats, whose -limit counts them
+	// rather than the messages staged.
+	scanned *scanStats
+	// accumulated holds staged messages across receives to send full batches.
+	accumulated *accumulator
+	removals    *deleter
+
+	latency apiLatency
+	// callsBefore is the API call count when this source started, as the count is
+	// shared across every source in the run.
+	callsBefore int64
+
+	lastReceived   int64
+	stoppedOnCalls int32
+	stoppedOnIdle  int32
+	// staged counts the messages this source took from the shared budget.
+	staged             int64
+	sent               int64
+	sendFailed         int64
+	emptyBodies        int64
+	oversize           int64
+	slaBreaches        int64
+	duplicates         int64
+	unrouted           int64
+	checksumMismatches int64
+	attrMismatches     int64
+	movedToFailedDest  int64
+	missingDedupKeys   int64
+	ttlExpired         int64
+	dropped            int64
+	duplicatesSkipped  int64
+	hashSelected       int64
+	hashSkipped        int64
+	invalidBodies      int64
+	dedupCollisions    int64
+	retryRecovered     int64
+	retryExhausted     int64
+	batchTimeouts      int64
+	sizes              *distribution
+	ages               *distribution
+	diffsShown         int64
+	// failures breaks down failed sends, deletes and heartbeats by cause.
+	failures *failureCauses
+	// batchErrors counts the requests that failed as a whole with -continue-on-error.
+	batchErrors *batchErrors
+
+	// released remembers the messages released with ReleaseNonmatching.
+	releasedMu sync.Mutex
+	released   map[string]bool
+
+	// batches numbers the lines of the BatchReport.
+	batches int64
+
+	// held collects every message received during a peek so it can be released once
+	// the run is done.
+	heldMu sync.Mutex
+	held   []*types.Message
+}
+
+// defaultVisibility is the visibility timeout of each receive unless Options bounds it.
+const defaultVisibility = 60 * time.Second
+
+// Migrate moves the messages of the source queue to the destination through svc,
+// returning the summary once every worker has finished and the messages sent have been
+// deleted from the source.  An error that stopped the migration early is returned along
+// with the summary of what it did until then.
+func Migrate(ctx context.Context, svc API, opts Options) (Summary, error) {
+	start := time.Now()
+	m := newMigrator(ctx, svc, opts)
+	if opts.Interrupted == nil {
+		defer m.Interrupted.Finish()
+	}
+	if m.ProgressReport != nil {
+		m.ProgressReport.track(m)
+	}
+	count := m.run(m.Workers)
+	result := m.summary(aws.ToString(m.SourceQueueURL), aws.ToString(m.DestQueueURL), count, time.Since(start))
+	result.EstimateCost(m.PricePerMillion)
+	if m.ProgressReport != nil {
+		m.ProgressReport.finish(m, result)
+	}
+	return result, m.Interrupted.Err()
+}
+
+// newMigrator is the migrator for opts, filling in the fields left zero.
+func newMigrator(ctx context.Context, svc API, opts Options) *migrator {
+	if opts.DestSvc == nil {
+		opts.DestSvc = svc
+	}
+	if opts.Logger == nil {
+		opts.Logger = NewLogger(true, false)
+	}
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	if opts.Budget == nil {
+		limit := opts.Limit
+		if limit == 0 {
+			limit = math.MaxInt32
+		}
+		opts.Budget = NewBudget(limit)
+	}
+	if opts.Slots == nil {
+		opts.Slots = newConcurrencyController(opts.Workers, opts.Workers, false, opts.Logger)
+	}
+	if opts.Calls == nil {
+		opts.Calls = &APICalls{}
+	}
+	if opts.Interrupted == nil {
+		opts.Interrupted = newInterrupt(ctx)
+	}
+	if opts.DeleteConcurrency < 1 {
+		opts.DeleteConcurrency = 1
+	}
+	if opts.MinVisibility == 0 {
+		opts.MinVisibility = defaultVisibility
+	}
+	if opts.MaxVisibility < opts.MinVisibility {
+		opts.MaxVisibility = opts.MinVisibility
+	}
+	if opts.EmptyBody == "" {
+		opts.EmptyBody = EmptyBodySkip
+	}
+	if opts.RunTime.IsZero() {
+		opts.RunTime = time.Now()
+	}
+	if opts.SourceName == "" {
+		opts.SourceName = QueueName(aws.ToString(opts.SourceQueueURL))
+	}
+	return &migrator{
+		Options:      opts,
+		ctx:          ctx,
+		sqsSvc:       svc,
+		sendFailures: newSendFailures(opts.SendFailureThreshold),
+		scanned:      newScanStats(opts.Stats),
+		accumulated:  newAccumulator(opts.Accumulate),
+		failures:     newFailureCauses(opts.ListFailures),
+		batchErrors:  newBatchErrors(opts.ContinueOnError),
+	}
+}
+
+// run starts the workers and blocks until they have all finished and every migrated
+// message has been removed from the source.  It returns the number of messages staged by
+// this run, as the budget may be shared with other sources.
+func (m *migrator) run(workers int) int {
+	m.sizes = newSizeDistribution()
+	m.ages = newAgeDistribution()
+	m.lastReceived = time.Now().UnixNano()
+	if m.Received == nil {
+		m.Received = NewMessageIDs()
+	}
+	m.released = map[string]bool{}
+	m.callsBefore = m.Calls.Made()
+	m.removals = startDeleter(m.ctx, m.sqsSvc, m.Logger, m.SourceQueueURL, m.Errs, m.InFlight, &m.latency.delete, m.failures, m.batchErrors, m.Interrupted, m.BatchTimeout, &m.batchTimeouts, m.DeleteConcurrency)
+	if m.NewestFirst {
+		staged := m.migrateNewestFirst()
+		m.removals.wait()
+		return staged
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.work()
+		}()
+	}
+	wg.Wait()
+	m.flush(true, nil)
+	m.removals.wait()
+	if m.peek() {
+		m.Logger.Printf("Releasing %d received messages back to the source\n", len(m.held))
+		m.release(m.held)
+	}
+
+	return int(atomic.LoadInt64(&m.staged))
+}
+
+func (m *migrator) work() {
+	for {
+		if m.Calls.exhausted() {
+			atomic.StoreInt32(&m.stoppedOnCalls, 1)
+			return
+		}
+		m.Paused.wait(m.Interrupted)
+		m.Backpressure.wait(m.Interrupted)
+		if m.Approval.stopped() || m.Interrupted.stopping() {
+			return
+		}
+		m.Slots.acquire()
+		reserved := m.Budget.reserve(BatchSize)
+		if reserved == 0 {
+			m.Slots.release()
+			return
+		}
+		staged, more := m.processBatch(m.InFlight.acquire(reserved))
+		m.Budget.settle(reserved, staged)
+		atomic.AddInt64(&m.staged, int64(staged))
+		m.Slots.succeeded()
+		m.Slots.release()
+		if !more || m.Once {
+			return
+		}
+		if m.Execute && m.BatchDelay > 0 {
+			time.Sleep(m.BatchDelay)
+		}
+	}
+}
+
+// processBatch receives up to curBatch messages, migrates the ones that pass the filters
+// and queues them for removal from the source.  It returns how many were staged, or
+// scanned with -stats, and whether the source may still have messages to give.
+func (m *migrator) processBatch(curBatch int) (int, bool) {
+	// Everything not handed to the deleter stops counting against -max-in-flight once
+	// the batch is done, the deleter releases the rest as it removes them.
+	queued := 0
+	defer func() { m.InFlight.release(curBatch - queued) }()
+
+	// The batch's slot is handed to its record once there is one, which frees it when
+	// the last of its deletes is done.
+	m.BatchSlots.acquire()
+	receiveStart := time.Now()
+	messages, ok := m.receive(curBatch)
+	if !ok {
+		m.BatchSlots.release()
+		m.Interrupted.sleep(batchErrorPause)
+		return 0, true
+	}
+	if len(messages) == 0 {
+		m.BatchSlots.release()
+		// With -max-empty-duration an empty receive only ends the run once the queue
+		// has stayed quiet for long enough, with -tail it never does unless there is
+		// an -exit-on-idle.
+		idle := time.Since(time.Unix(0, atomic.LoadInt64(&m.lastReceived)))
+		idled := m.ExitOnIdle > 0 && idle >= m.ExitOnIdle
+		more := m.Tail && !idled || m.MaxEmptyDuration > 0 && idle < m.MaxEmptyDuration
+		if m.Tail && idled && atomic.CompareAndSwapInt32(&m.stoppedOnIdle, 0, 1) {
+			m.Logger.Printf("Nothing received for %s, stopping the tail (-exit-on-idle)\n", idle.Round(time.Second))
+		}
+		m.flush(false, nil)
+		if more {
+			m.Interrupted.sleep(m.pollPause(atomic.AddInt64(&m.emptyReceives, 1)))
+		}
+		return 0, more
+	}
+	atomic.StoreInt64(&m.emptyReceives, 0)
+	if m.FIFOSequential {
+		sortForSending(messages)
+	}
+	record := m.newBatchRecord()
+	if record != nil {
+		record.Received = len(messages)
+		record.ReceiveMs = float64(time.Since(receiveStart)) / float64(time.Millisecond)
+	}
+	defer record.done(m.Logger)
+
+	matched := 0
+	messagesToProcess := []*types.SendMessageBatchRequestEntry{}
+	staged := []*types.Message{}
+	idsToReceipts := make(map[string]*string)
+	duplicatesToDelete := []types.DeleteMessageBatchRequestEntry{}
+	rejected := []*types.Message{}
+	// discarded are removed from the source without being sent, being past the -ttl or
+	// matching -drop-matching.  A dry run or -no-delete leaves them like any rejected
+	// message.
+	discarded := []types.DeleteMessageBatchRequestEntry{}
+	discard := func(message *types.Message) {
+		if m.NoDelete || !m.Execute {
+			rejected = append(rejected, message)
+		}
+		if m.NoDelete {
+			return
+		}
+		discarded = append(discarded, types.DeleteMessageBatchRequestEntry{
+			Id:            message.MessageId,
+			ReceiptHandle: message.ReceiptHandle,
+		})
+	}
+	releasedAgain := 0
+	for i := range messages {
+		message := &messages[i]
+		if m.peek() {
+			m.heldMu.Lock()
+			m.held = append(m.held, message)
+			m.heldMu.Unlock()
+		}
+		if m.releasedBefore(message) {
+			// Already turned down in this run, it is left to its visibility timeout.
+			releasedAgain++
+			continue
+		}
+		if m.Events != nil {
+			age, known := m.age(message)
+			m.Events.received(*message.MessageId, m.SourceName, age, known)
+		}
+		if m.scanned != nil {
+			age, known := m.age(message)
+			m.scanned.observe(m.RunTime, age, known)
+		}
+		if repeat, sent := m.Received.receive(*message.MessageId); repeat {
+			atomic.AddInt64(&m.duplicates, 1)
+			m.Logger.Printf("Message %s has already been received in this run\n", *message.MessageId)
+			// Only a message already on the destination is safe to drop, a repeat of one
+			// that failed to send or was filtered out is handled like any other.
+			if m.SkipDuplicateIDs && sent {
+				atomic.AddInt64(&m.duplicatesSkipped, 1)
+				m.Events.skipped(*message.MessageId, m.SourceName, "duplicate of a message already sent")
+				duplicatesToDelete = append(duplicatesToDelete, types.DeleteMessageBatchRequestEntry{
+					Id:            message.MessageId,
+					ReceiptHandle: message.ReceiptHandle,
+				})
+				continue
+			}
+		}
+		if m.drops(message) {
+			atomic.AddInt64(&m.dropped, 1)
+			m.Logger.Printf("Dropping message %s, its body matches -drop-matching\n", *message.MessageId)
+			m.Events.skipped(*message.MessageId, m.SourceName, "matches -drop-matching")
+			discard(message)
+			continue
+		}
+		if !m.matches(message) {
+			rejected = append(rejected, message)
+			continue
+		}
+		if age, known := m.age(message); known && m.TTL > 0 && age > m.TTL {
+			atomic.AddInt64(&m.ttlExpired, 1)
+			m.Logger.Printf("Message %s is %s old, past the %s -ttl\n", *message.MessageId, age.Round(time.Second), m.TTL)
+			m.Events.skipped(*message.MessageId, m.SourceName, "past -ttl")
+			discard(message)
+			continue
+		}
+		matched++
+		if err := m.IDs.record(*message.MessageId); err != nil {
+			m.Interrupted.fail(m.Logger, err)
+			rejected = append(rejected, message)
+			continue
+		}
+		if age, _ := m.age(message); !m.Approval.approve(*message.MessageId, age, aws.ToString(message.Body)) {
+			m.Events.skipped(*message.MessageId, m.SourceName, "not approved")
+			rejected = append(rejected, message)
+			continue
+		}
+		if entry := m.stage(message); entry != nil {
+			messagesToProcess = append(messagesToProcess, entry)
+			staged = append(staged, message)
+			idsToReceipts[*message.MessageId] = message.ReceiptHandle
+		} else {
+			rejected = append(rejected, message)
+		}
+	}
+	messagesToProcess, left := m.preSend(staged, messagesToProcess, idsToReceipts)
+	rejected = append(rejected, left...)
+
+	if record != nil {
+		record.Matched = matched
+		record.Staged = len(messagesToProcess)
+	}
+	m.releaseRejected(rejected)
+	if m.accumulated != nil {
+		m.accumulated.add(messagesToProcess, idsToReceipts)
+		queued = len(messagesToProcess)
+		m.flush(false, record)
+	} else {
+		beat := m.startHeartbeat(idsToReceipts)
+		queued = m.migrate(messagesToProcess, idsToReceipts, record)
+		beat.stop()
+	}
+	if m.Execute && !m.NoDelete && len(duplicatesToDelete) > 0 {
+		m.Logger.Printf("Removing %d duplicate messages from the source without sending them\n", len(duplicatesToDelete))
+		m.removals.enqueue(duplicatesToDelete, record)
+		queued += len(duplicatesToDelete)
+	}
+	if len(discarded) > 0 && !m.Execute {
+		if err := m.Script.deleteBatch(m.SourceQueueURL, discarded); err != nil {
+			m.Logger.Errorln("Encountered an error when attempting to write to the script")
+			m.Interrupted.fail(m.Logger, err)
+		}
+	} else if len(discarded) > 0 {
+		m.Logger.Printf("Removing %d expired or dropped messages from the source without sending them\n", len(discarded))
+		m.removals.enqueue(discarded, record)
+		queued += len(discarded)
+	}
+	// A receive of nothing but released messages means the rest of the source has been
+	// seen, which has to end the run as they would never let a receive come back empty.
+	if releasedAgain == len(messages) && !m.Tail {
+		return 0, false
+	}
+	if m.scanned != nil {
+		m.scanned.matched(len(messagesToProcess))
+		return len(messages) - releasedAgain, true
+	}
+	return len(messagesToProcess), true
+}
+
+// peek reports whether this is a dry run writing an -ids-file, taking a
+// -dry-run-sample or gathering -stats, which should leave the source just as it found
+// it.
+func (m *migrator) peek() bool {
+	return !m.Execute && (m.IDs != nil || m.Sample || m.scanned != nil)
+}
+
+// receive fetches up to n messages from the source.  It reports false when the request
+// failed and the run carries on with -continue-on-error, or was cut short by stopping.
+func (m *migrator) receive(n int) ([]types.Message, bool) {
+	receiveStart := time.Now()
+	queueReceipt, err := m.sqsSvc.ReceiveMessage(m.Interrupted.receiveContext(m.ctx), &sqs.ReceiveMessageInput{
+		QueueUrl:                    m.SourceQueueURL,
+		MessageSystemAttributeNames: m.attributeNames(),
+		MessageAttributeNames:       m.messageAttributeNames(),
+		MaxNumberOfMessages:         int32(n),
+		VisibilityTimeout:           m.visibilityTimeout(),
+		WaitTimeSeconds:             m.waitTimeSeconds(),
+	})
+	m.latency.receive.since(receiveStart)
+	if err != nil && m.Interrupted.stopping() {
+		return nil, false
+	}
+	if err != nil {
+		m.batchErrors.fail(m.Logger, m.Interrupted, "Error encountered when attempting to make a request to get messages", err)
+		return nil, false
+	}
+	m.batchErrors.succeeded()
+	if len(queueReceipt.Messages) > 0 {
+		atomic.StoreInt64(&m.lastReceived, time.Now().UnixNano())
+	}
+	return queueReceipt.Messages, true
+}
+
+// stage prepares a matching message for sending to the destination, returning nil if it
+// should be left on the source instead.
+func (m *migrator) stage(message *types.Message) *types.SendMessageBatchRequestEntry {
+	body := message.Body
+	if aws.ToString(body) == "" {
+		atomic.AddInt64(&m.emptyBodies, 1)
+		switch m.EmptyBody {
+		case EmptyBodySkip:
+			m.Logger.Printf("Skipping message with an empty body ID: %s\n", *message.MessageId)
+			m.Events.skipped(*message.MessageId, m.SourceName, "empty body")
+			return nil
+		case EmptyBodyError:
+			m.Interrupted.fail(m.Logger, fmt.Errorf("message %s has an empty body, which SendMessageBatch does not accept", *message.MessageId))
+			return nil
+		case EmptyBodySubstitute:
+			body = aws.String(m.EmptyPlaceholder)
+		}
+	}
+	inner, envelope := m.payload(*body)
+	if m.RequireJSON && !json.Valid([]byte(inner)) {
+		return m.invalidJSON(message)
+	}
+	content := inner
+	bodyDiff := ""
+	if (m.Transform != nil || m.TransformExec != nil) && (!isBinary(inner) || m.ForceText) {
+		transformed, err := m.transformed(transformData{Body: inner, MessageId: *message.MessageId, Queue: m.SourceName})
+		if err != nil && m.OnTransformError == TransformErrorFail {
+			m.Interrupted.fail(m.Logger, fmt.Errorf("the transform of message %s failed: %s", *message.MessageId, err))
+			return nil
+		}
+		if err != nil {
+			m.Logger.Printf("Skipping message %s, the transform failed: %s\n", *message.MessageId, err)
+			m.Events.skipped(*message.MessageId, m.SourceName, "transform failed: "+err.Error())
+			return nil
+		}
+		if !m.Execute && m.ShowDiff > 0 {
+			bodyDiff = unifiedDiff(*message.MessageId, inner, transformed)
+		}
+		content = transformed
+	}
+	var pipelined *pipelineMessage
+	if m.Pipeline != nil && (!isBinary(inner) || m.ForceText) {
+		var err error
+		pipelined, err = m.Pipeline.run(m.ctx, message, m.SourceName, inner)
+		if err != nil && m.OnTransformError == TransformErrorFail {
+			m.Interrupted.fail(m.Logger, fmt.Errorf("the pipeline failed on message %s: %s", *message.MessageId, err))
+			return nil
+		}
+		if err != nil {
+			m.Logger.Printf("Skipping message %s, the pipeline failed: %s\n", *message.MessageId, err)
+			m.Events.skipped(*message.MessageId, m.SourceName, "pipeline failed: "+err.Error())
+			return nil
+		}
+		if !m.Execute && m.ShowDiff > 0 {
+			bodyDiff = unifiedDiff(*message.MessageId, inner, pipelined.body)
+		}
+		content = pipelined.body
+	}
+	var pluginAttributes map[string]types.MessageAttributeValue
+	if len(m.Plugins) > 0 {
+		var err error
+		content, pluginAttributes, err = m.runPlugins(message, content)
+		if err != nil && m.OnTransformError == TransformErrorFail {
+			m.Interrupted.fail(m.Logger, fmt.Errorf("the plugins failed on message %s: %s", *message.MessageId, err))
+			return nil
+		}
+		if err != nil {
+			m.Logger.Printf("Skipping message %s, the plugins failed: %s\n", *message.MessageId, err)
+			m.Events.skipped(*message.MessageId, m.SourceName, "plugins failed: "+err.Error())
+			return nil
+		}
+	}
+	content = m.BodyPrefix + content + m.BodySuffix
+	switch {
+	case envelope != nil && m.SendUnwrapped:
+		body = aws.String(content)
+	case envelope != nil && content != inner:
+		rewrapped, err := envelope.rewrap(content)
+		if err != nil {
+			m.Logger.Printf("Skipping message %s, it could not be re-wrapped: %s\n", *message.MessageId, err)
+			m.Events.skipped(*message.MessageId, m.SourceName, "re-wrap failed: "+err.Error())
+			return nil
+		}
+		body = aws.String(rewrapped)
+	case envelope == nil:
+		body = aws.String(content)
+	}
+	age, known := m.age(message)
+	if known && m.SLAAge > 0 && age > m.SLAAge {
+		atomic.AddInt64(&m.slaBreaches, 1)
+		m.Logger.Printf("Warning: message %s is %s old, past the %s SLA\n", *message.MessageId, age.Round(time.Second), m.SLAAge)
+	}
+	m.Logger.Printf("Staging message Age: %s ID: %s Receipt: %s\n", age, *message.MessageId, shortHandle(message.ReceiptHandle))
+	if m.Output != nil {
+		if err := m.Output.write(message, m.SourceName, *body, age); err != nil {
+			m.Interrupted.fail(m.Logger, fmt.Errorf("the -output-template failed on message %s: %s", *message.MessageId, err))
+			return nil
+		}
+	} else if m.Verbose || m.Sample {
+		m.Logger.Printf("%s - %s\n", *message.MessageId, DescribeBody(*body))
+		if len(m.ReceiveAttributes) > 0 {
+			m.Logger.Printf("%s - %s\n", *message.MessageId, describeSystemAttributes(message.Attributes))
+		}
+	}
+	entry := &types.SendMessageBatchRequestEntry{
+		Id:          message.MessageId,
+		MessageBody: body,
+	}
+	delay, err := m.messageDelay(message)
+	if err != nil {
+		m.Logger.Printf("Ignoring delay of message %s: %s\n", *message.MessageId, err)
+	}
+	delay = m.receiveDelay(message, delay)
+	entry.DelaySeconds = aws.ToInt32(delay)
+	if m.CarryGroupID {
+		carryGroupID(message, entry, m.CarryDedupID)
+	}
+	if m.GroupID != nil {
+		if err := remapGroupID(m.GroupID, m.SourceName, message, entry, m.CarryDedupID); err != nil {
+			m.Logger.Errorln("Error encountered when attempting to compute the group ID of a message")
+			m.Interrupted.fail(m.Logger, err)
+			return nil
+		}
+	}
+	if m.GroupIDFrom != nil {
+		if groupID := m.GroupIDFrom.groupID(message, inner); groupID != "" {
+			entry.MessageGroupId = aws.String(groupID)
+		}
+	}
+	if m.DedupFromBody {
+		entry.MessageDeduplicationId = aws.String(bodyDeduplicationID(*body, aws.ToString(entry.MessageGroupId), m.DedupScope))
+	}
+	if m.DedupFrom != nil {
+		key, ok := dedupKey(m.DedupFrom, inner)
+		if !ok {
+			m.Logger.Printf("Skipping message %s, its body has no -dedup-from field to deduplicate on\n", *message.MessageId)
+			atomic.AddInt64(&m.missingDedupKeys, 1)
+			m.Events.skipped(*message.MessageId, m.SourceName, "no -dedup-from field")
+			return nil
+		}
+		entry.MessageDeduplicationId = aws.String(keyDeduplicationID(key))
+	}
+	if m.CopyAttributes && len(message.MessageAttributes) > 0 {
+		entry.MessageAttributes = map[string]types.MessageAttributeValue{}
+		for name, value := range message.MessageAttributes {
+			entry.MessageAttributes[name] = value
+		}
+	}
+	if pipelined != nil && m.Pipeline.usesAttributes() {
+		entry.MessageAttributes = nil
+		if len(pipelined.attributes) > 0 {
+			entry.MessageAttributes = pipelined.attributes
+		}
+	}
+	m.preserveFirstReceive(message, entry)
+	m.promoteAttributes(message, envelope, entry)
+	m.remapAttributes(entry)
+	AttributeList(pluginAttributes).Apply(entry)
+	m.SetAttributes.Apply(entry)
+	m.compress(entry)
+	if !m.Execute && m.ShowDiff > 0 {
+		m.showDiffs(message, bodyDiff, attributeDiff(message.MessageAttributes, entry.MessageAttributes, m.RenameAttributes))
+	}
+	if !fitMessage(entry, message, m.OnOversize) {
+		m.Logger.Printf("Skipping message %s, it would be over the %dKB SQS limit once sent\n", *message.MessageId, MaxMessageBytes>>10)
+		atomic.AddInt64(&m.oversize, 1)
+		m.Events.skipped(*message.MessageId, m.SourceName, "over the SQS size limit")
+		return nil
+	}
+	if previous, ok := m.DedupIDs.collides(entry, *message.MessageId, time.Now()); ok {
+		if m.FailOnDedupCollision {
+			m.Interrupted.fail(m.Logger, fmt.Errorf("message %s would be sent with the deduplication ID of message %s and dropped by the destination (-check-dedup-ids error)", *message.MessageId, previous))
+			return nil
+		}
+		m.Logger.Printf("Warning: message %s is sent with the deduplication ID of message %s, the destination drops it if both arrive within 5 minutes\n", *message.MessageId, previous)
+		atomic.AddInt64(&m.dedupCollisions, 1)
+	}
+	if m.Routes != nil {
+		var err error
+		if queue := m.FilterConfig.destination(inner); queue != "" {
+			err = m.Routes.assign(*message.MessageId, queue)
+		} else {
+			err = m.Routes.route(*message.MessageId, inner)
+		}
+		if err != nil {
+			m.unroutable(message, err)
+			return nil
+		}
+	}
+	m.sizes.observe(int64(messageSize(*body, message.MessageAttributes, m.SizeIncludesAttributes)))
+	if known {
+		m.ages.observe(int64(age))
+	}
+	return entry
+}
+
+// showDiffs logs the body and attribute changes of a staged message for the first
+// -show-diff messages that have any.
+func (m *migrator) showDiffs(message *types.Message, bodyDiff, attrDiff string) {
+	if bodyDiff == "" && attrDiff == "" {
+		return
+	}
+	if atomic.AddInt64(&m.diffsShown, 1) > int64(m.ShowDiff) {
+		return
+	}
+	if bodyDiff != "" {
+		m.Logger.Printf("Transform of %s:\n%s", *message.MessageId, bodyDiff)
+	}
+	if attrDiff != "" {
+		m.Logger.Printf("Attribute changes of %s:\n%s", *message.MessageId, attrDiff)
+	}
+}
+
+// migrate sends a batch of staged messages to their destinations and queues the ones
+// that were sent successfully for removal from the source, returning how many were
+// queued.
+func (m *migrator) migrate(messagesToProcess []*types.SendMessageBatchRequestEntry, idsToReceipts map[string]*string, record *BatchRecord) int {
+	queued := 0
+	for _, group := range m.Routes.split(m.DestQueueURL, messagesToProcess) {
+		queued += m.sendBatch(group.queueURL, group.entries, idsToReceipts, record)
+	}
+	return queued
+}
+
+// sendBatch sends staged messages to one destination queue, returning how many were
+// queued for removal from the source.
+func (m *migrator) sendBatch(destQueueURL *string, messagesToProcess []*types.SendMessageBatchRequestEntry, idsToReceipts map[string]*string, record *BatchRecord) int {
+	if len(messagesToProcess) == 0 {
+		return 0
+	}
+	if !m.Execute {
+		if err := m.Script.sendBatch(m.SourceQueueURL, destQueueURL, messagesToProcess, idsToReceipts, m.NoDelete); err != nil {
+			m.Logger.Errorln("Encountered an error when attempting to write to the script")
+			m.Interrupted.fail(m.Logger, err)
+		}
+	}
+	if !m.Execute {
+		for _, entry := range messagesToProcess {
+			m.Events.migrated(*entry.Id, m.SourceName, QueueName(aws.ToString(destQueueURL)), true)
+		}
+	}
+	if !m.Execute && m.Routes != nil {
+		m.Logger.Printf("In Dry-Run mode.  This batch would have attempted to send %d messages to %s\n", len(messagesToProcess), aws.ToString(destQueueURL))
+		return 0
+	}
+	if !m.Execute {
+		m.Logger.Printf("In Dry-Run mode.  This batch would have attempted to process %d messages\n", len(messagesToProcess))
+		return 0
+	}
+
+	entries := make([]types.SendMessageBatchRequestEntry, len(messagesToProcess))
+	for i, entry := range messagesToProcess {
+		entries[i] = *entry
+	}
+	byID := map[string]*types.SendMessageBatchRequestEntry{}
+	for _, entry := range messagesToProcess {
+		byID[*entry.Id] = entry
+	}
+	sendStart := time.Now()
+	resp := m.send(destQueueURL, entries)
+	m.verifyChecksums(messagesToProcess, resp)
+	m.retryFailed(destQueueURL, byID, resp)
+	record.sent(len(resp.Successful), len(resp.Failed), time.Since(sendStart))
+
+	for _, failed := range resp.Failed {
+		m.failures.record("send", failed)
+		m.Events.failed(*failed.Id, m.SourceName, QueueName(aws.ToString(destQueueURL)), aws.ToString(failed.Code), aws.ToString(failed.Message))
+	}
+	moved, failures := m.moveToFailedDest(byID, resp.Failed)
+	for _, failedMigration := range failures {
+		m.Logger.Errorf("err with %s - %s", *failedMigration.Id, *failedMigration.Message)
+		if err := m.Errs.recordSend(m.SourceQueueURL, byID[*failedMigration.Id], idsToReceipts[*failedMigration.Id], failedMigration); err != nil {
+			m.Interrupted.fail(m.Logger, err)
+		}
+	}
+
+	for _, sent := range resp.Successful {
+		m.Received.markSent(*sent.Id)
+		m.SentBodies.add(aws.ToString(destQueueURL), aws.ToString(byID[*sent.Id].MessageBody))
+		m.Events.migrated(*sent.Id, m.SourceName, QueueName(aws.ToString(destQueueURL)), false)
+	}
+	atomic.AddInt64(&m.sent, int64(len(resp.Successful)))
+	atomic.AddInt64(&m.sendFailed, int64(len(resp.Failed)))
+	// One write per batch, so the results of batches sent by -concurrency workers at
+	// the same time aren't interleaved line by line.
+	m.Logger.Printf("\nCompleted transfering messages for this batch, resulting in: \n    Successes: %d\n    Failed: %d\n", len(resp.Successful), len(resp.Failed))
+
+	if m.NoDelete {
+		m.Logger.Printf("Leaving %d sent messages on the source (-no-delete), they will reappear there\n", len(resp.Successful))
+		return 0
+	}
+
+	m.Logger.Println("\nRemoving messages from source queue")
+	messagesToDelete := []types.DeleteMessageBatchRequestEntry{}
+	for _, successfullyMigrated := range append(resp.Successful, moved...) {
+		m.Logger.Printf("Staging for removal ID: %s Message ID: %s Receipt: %s\n", *successfullyMigrated.Id, aws.ToString(successfullyMigrated.MessageId), shortHandle(idsToReceipts[*successfullyMigrated.Id]))
+		messagesToDelete = append(messagesToDelete, types.DeleteMessageBatchRequestEntry{
+			Id:            successfullyMigrated.Id,
+			ReceiptHandle: idsToReceipts[*successfullyMigrated.Id],
+		})
+	}
+	m.removals.enqueue(messagesToDelete, record)
+	return len(messagesToDelete)
+}
+
+// attributeNames lists the system attributes each receive needs for the configured
+// filters and transforms, along with any asked for with -receive-attributes.
+func (m *migrator) attributeNames() []types.MessageSystemAttributeName {
+	names := []types.MessageSystemAttributeName{types.MessageSystemAttributeNameSentTimestamp}
+	if m.SenderID != "" {
+		names = append(names, types.MessageSystemAttributeNameSenderId)
+	}
+	if m.PreserveTimestamp {
+		names = append(names, types.MessageSystemAttributeNameApproximateFirstReceiveTimestamp)
+	}
+	if m.GroupID != nil || m.CarryGroupID {
+		names = append(names,
+			types.MessageSystemAttributeNameMessageGroupId,
+			types.MessageSystemAttributeNameMessageDeduplicationId)
+	}
+	if m.FIFOSequential {
+		names = append(names, types.MessageSystemAttributeNameSequenceNumber)
+	}
+	if m.DelayPerReceive > 0 {
+		names = append(names, types.MessageSystemAttributeNameApproximateReceiveCount)
+	}
+	for _, name := range m.ReceiveAttributes {
+		if name == types.MessageSystemAttributeNameAll {
+			return []types.MessageSystemAttributeName{types.MessageSystemAttributeNameAll}
+		}
+		requested := false
+		for _, existing := range names {
+			requested = requested || existing == name
+		}
+		if !requested {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// waitTimeSeconds switches receives to long polling with -tail or while waiting out
+// -max-empty-duration, so that an idle queue doesn't turn into a busy loop of requests,
+// unless -wait-time says otherwise.
+func (m *migrator) waitTimeSeconds() int32 {
+	if m.WaitTime != nil {
+		return *m.WaitTime
+	}
+	if m.Tail {
+		return MaxWaitTimeSeconds
+	}
+	if m.MaxEmptyDuration <= 0 {
+		return 0
+	}
+	seconds := int32(m.MaxEmptyDuration / time.Second)
+	if seconds > MaxWaitTimeSeconds {
+		seconds = MaxWaitTimeSeconds
+	}
+	return seconds
+}
+
+// messageAttributeNames lists the custom message attributes each receive needs.
+func (m *migrator) messageAttributeNames() []string {
+	if m.FetchAttributes["All"] {
+		return []string{"All"}
+	}
+	// An explicit -receive-message-attributes list takes the place of All, and the
+	// attributes copied, sized and given to plugins are then only those fetched.
+	if len(m.FetchAttributes) == 0 && (m.SizeIncludesAttributes || m.CopyAttributes || len(m.Plugins) > 0 || m.Pipeline.usesAttributes()) {
+		return []string{"All"}
+	}
+	wanted := AttributeNames{}
+	for name := range m.FetchAttributes {
+		wanted[name] = true
+	}
+	if m.PreserveDelay && m.Delay == nil {
+		wanted[delayAttribute] = true
+	}
+	if m.GroupIDFrom != nil && m.GroupIDFrom.attribute != "" {
+		wanted[m.GroupIDFrom.attribute] = true
+	}
+	for _, f := range m.AttrFilters {
+		wanted[f.name] = true
+	}
+	if len(wanted) == 0 {
+		return nil
+	}
+	return wanted.sorted()
+}
+
+// Budget hands out the remaining -limit to workers so that, however many are running,
+// no more than limit messages are staged in total.
+type Budget struct {
+	mu        sync.Mutex
+	remaining int
+	staged    int
+}
+
+func NewBudget(limit int) *Budget {
+	return &Budget{remaining: limit}
+}
+
+// Exhausted reports whether the whole limit has been staged.
+func (b *Budget) Exhausted() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.remaining == 0
+}
+
+// reserve claims up to n messages of the remaining budget, returning how many were
+// granted.  A worker that is granted nothing should stop.
+func (b *Budget) reserve(n int) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n > b.remaining {
+		n = b.remaining
+	}
+	b.remaining -= n
+	return n
+}
+
+// settle returns the unused part of a reservation once a batch has been staged.
+func (b *Budget) settle(reserved, staged int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remaining += reserved - staged
+	b.staged += staged
+}
@@ -0,0 +1,382 @@
+package sqsmigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// fakeSQS holds a source queue in memory for the migrator.  A received message stays
+// invisible for the rest of the test, as it would within its visibility timeout.
+type fakeSQS struct {
+	mu       sync.Mutex
+	source   []types.Message
+	received map[string]bool
+	// failSends makes this many sends of a message ID fail, -1 for every one.
+	failSends map[string]int
+	// hangSends makes this many send requests wait until they are cancelled.
+	hangSends int
+	// rejectSends fails a send request carrying any of these message IDs as a whole.
+	rejectSends map[string]bool
+	// rejectQueue fails every send request to this queue URL as a whole.
+	rejectQueue string
+	attempts    map[string]int
+	sent        []types.SendMessageBatchRequestEntry
+	deleted     []string
+	// expired lists the receipt handles whose deletes fail as expired.
+	expired map[string]bool
+}
+
+func newFakeSQS(ids ...string) *fakeSQS {
+	f := &fakeSQS{received: map[string]bool{}, failSends: map[string]int{}, rejectSends: map[string]bool{}, attempts: map[string]int{}, expired: map[string]bool{}}
+	for _, id := range ids {
+		f.source = append(f.source, types.Message{
+			MessageId:     aws.String(id),
+			ReceiptHandle: aws.String("receipt-" + id),
+			Body:          aws.String("body of " + id),
+			Attributes: map[string]string{
+				string(types.MessageSystemAttributeNameSentTimestamp): "1700000000000",
+			},
+		})
+	}
+	return f
+}
+
+func (f *fakeSQS) onSource(id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, m := range f.source {
+		if *m.MessageId == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeSQS) ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := &sqs.ReceiveMessageOutput{}
+	for _, m := range f.source {
+		if len(out.Messages) == int(params.MaxNumberOfMessages) {
+			break
+		}
+		if !f.received[*m.MessageId] {
+			f.received[*m.MessageId] = true
+			out.Messages = append(out.Messages, m)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeSQS) SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	panic("SendMessage is only used by -fifo-sequential")
+}
+
+func (f *fakeSQS) SendMessageBatch(ctx context.Context, params *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.hangSends > 0 {
+		f.hangSends--
+		for _, entry := range params.Entries {
+			f.attempts[*entry.Id]++
+		}
+		f.mu.Unlock()
+		<-ctx.Done()
+		f.mu.Lock()
+		return nil, ctx.Err()
+	}
+	for _, entry := range params.Entries {
+		if f.rejectSends[*entry.Id] || *params.QueueUrl == f.rejectQueue {
+			f.attempts[*entry.Id]++
+			return nil, errors.New("service unavailable")
+		}
+	}
+	out := &sqs.SendMessageBatchOutput{}
+	for _, entry := range params.Entries {
+		f.attempts[*entry.Id]++
+		if left := f.failSends[*entry.Id]; left != 0 {
+			f.failSends[*entry.Id] = left - 1
+			out.Failed = append(out.Failed, types.BatchResultErrorEntry{
+				Id:      entry.Id,
+				Code:    aws.String("InternalError"),
+				Message: aws.String("try again"),
+			})
+			continue
+		}
+		f.sent = append(f.sent, entry)
+		out.Successful = append(out.Successful, types.SendMessageBatchResultEntry{Id: entry.Id, MessageId: entry.Id})
+	}
+	return out, nil
+}
+
+func (f *fakeSQS) DeleteMessageBatch(ctx context.Context, params *sqs.DeleteMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageBatchOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := &sqs.DeleteMessageBatchOutput{}
+	for _, entry := range params.Entries {
+		if f.expired[*entry.ReceiptHandle] {
+			out.Failed = append(out.Failed, types.BatchResultErrorEntry{
+				Id:      entry.Id,
+				Code:    aws.String(receiptHandleIsInvalid),
+				Message: aws.String("the receipt handle has expired"),
+			})
+			continue
+		}
+		kept := f.source[:0]
+		for _, m := range f.source {
+			if *m.ReceiptHandle != *entry.ReceiptHandle {
+				kept = append(kept, m)
+			}
+		}
+		f.source = kept
+		f.deleted = append(f.deleted, *entry.Id)
+		out.Successful = append(out.Successful, types.DeleteMessageBatchResultEntry{Id: entry.Id})
+	}
+	return out, nil
+}
+
+func (f *fakeSQS) ChangeMessageVisibilityBatch(ctx context.Context, params *sqs.ChangeMessageVisibilityBatchInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	return &sqs.ChangeMessageVisibilityBatchOutput{}, nil
+}
+
+// newTestMigrator is a migrator moving every message on svc with the defaults of the
+// command and -execute.
+func newTestMigrator(svc API) *migrator {
+	return newMigrator(context.Background(), svc, Options{
+		SourceQueueURL: aws.String("https://sqs.us-east-1.amazonaws.com/123456789012/source"),
+		DestQueueURL:   aws.String("https://sqs.us-east-1.amazonaws.com/123456789012/dest"),
+		Execute:        true,
+		Limit:          100,
+		CopyAttributes: true,
+		CarryDedupID:   true,
+	})
+}
+
+func TestPartialSendFailureStaysOnSource(t *testing.T) {
+	svc := newFakeSQS("ok", "bad")
+	svc.failSends["bad"] = -1
+	m := newTestMigrator(svc)
+	m.MaxRetries = 2
+
+	m.run(1)
+	result := m.summary("source", "dest", 2, time.Second)
+
+	if svc.attempts["bad"] != 3 {
+		t.Errorf("bad was sent %d times, want the first attempt and 2 retries", svc.attempts["bad"])
+	}
+	if !svc.onSource("bad") {
+		t.Error("the message that failed to send was deleted from the source")
+	}
+	if svc.onSource("ok") {
+		t.Error("the message sent was left on the source")
+	}
+	if result.Sent != 1 || result.SendFailed != 1 || result.Deleted != 1 || result.RetryExhausted != 1 {
+		t.Errorf("got sent %d, failed %d, deleted %d, retries exhausted %d", result.Sent, result.SendFailed, result.Deleted, result.RetryExhausted)
+	}
+}
+
+func TestPartialSendFailureRecoveredByRetry(t *testing.T) {
+	svc := newFakeSQS("ok", "flaky")
+	svc.failSends["flaky"] = 1
+	m := newTestMigrator(svc)
+	m.MaxRetries = 2
+
+	m.run(1)
+	result := m.summary("source", "dest", 2, time.Second)
+
+	if svc.onSource("flaky") || svc.onSource("ok") {
+		t.Errorf("expected both messages deleted once sent, deleted %v", svc.deleted)
+	}
+	if result.Sent != 2 || result.SendFailed != 0 || result.RetryRecovered != 1 {
+		t.Errorf("got sent %d, failed %d, retries recovered %d", result.Sent, result.SendFailed, result.RetryRecovered)
+	}
+}
+
+func TestTimedOutSendNotRetried(t *testing.T) {
+	svc := newFakeSQS("slow")
+	svc.hangSends = 1
+	m := newTestMigrator(svc)
+	m.MaxRetries = 2
+	m.BatchTimeout = 20 * time.Millisecond
+
+	m.run(1)
+	result := m.summary("source", "dest", 1, time.Second)
+
+	if svc.attempts["slow"] != 1 {
+		t.Errorf("slow was sent %d times, want a timed out send left alone", svc.attempts["slow"])
+	}
+	if !svc.onSource("slow") {
+		t.Error("the timed out send was deleted from the source")
+	}
+	if result.BatchTimeouts != 1 || result.SendFailed != 1 {
+		t.Errorf("got %d batch timeouts and %d failed sends", result.BatchTimeouts, result.SendFailed)
+	}
+}
+
+func TestFailFastStillDeletesSentBatches(t *testing.T) {
+	var ids []string
+	for i := 1; i <= BatchSize+1; i++ {
+		ids = append(ids, fmt.Sprintf("m%02d", i))
+	}
+	svc := newFakeSQS(ids...)
+	svc.rejectSends[ids[BatchSize]] = true
+	m := newTestMigrator(svc)
+
+	m.run(1)
+	result := m.summary("source", "dest", len(ids), time.Second)
+
+	if m.Interrupted.Err() == nil {
+		t.Error("the failed send request didn't stop the run with its error")
+	}
+	if len(svc.deleted) != BatchSize {
+		t.Errorf("deleted %v, want the first batch sent before the error", svc.deleted)
+	}
+	if !svc.onSource(ids[BatchSize]) {
+		t.Error("the message whose send failed was deleted from the source")
+	}
+	if result.Sent != BatchSize || result.Deleted != BatchSize {
+		t.Errorf("got sent %d, deleted %d", result.Sent, result.Deleted)
+	}
+}
+
+func TestFailedDestRequestErrorLeavesMessageOnSource(t *testing.T) {
+	svc := newFakeSQS("ok", "bad")
+	svc.failSends["bad"] = -1
+	m := newTestMigrator(svc)
+	m.batchErrors = newBatchErrors(true)
+	m.FailedDestURL = aws.String("https://sqs.us-east-1.amazonaws.com/123456789012/failed")
+	svc.rejectQueue = *m.FailedDestURL
+
+	m.run(1)
+	result := m.summary("source", "dest", 2, time.Second)
+
+	if m.Interrupted.Err() != nil {
+		t.Errorf("the run was stopped despite -continue-on-error: %v", m.Interrupted.Err())
+	}
+	if !svc.onSource("bad") {
+		t.Error("the message that couldn't be moved to -failed-dest was deleted from the source")
+	}
+	if svc.onSource("ok") {
+		t.Error("the message sent was left on the source")
+	}
+	if result.BatchErrors != 1 || result.MovedToFailedDest != 0 {
+		t.Errorf("got %d batch errors and %d moved to -failed-dest", result.BatchErrors, result.MovedToFailedDest)
+	}
+}
+
+func TestMigrateMovesAndDeletes(t *testing.T) {
+	svc := newFakeSQS("a", "b", "c")
+	result, err := Migrate(context.Background(), svc, Options{
+		SourceQueueURL: aws.String("https://sqs.us-east-1.amazonaws.com/123456789012/source"),
+		DestQueueURL:   aws.String("https://sqs.us-east-1.amazonaws.com/123456789012/dest"),
+		Execute:        true,
+		Filters:        []string{"body of a", "body of c"},
+	})
+	if err != nil {
+		t.Fatalf("Migrate returned %v", err)
+	}
+
+	if svc.onSource("a") || svc.onSource("c") {
+		t.Errorf("expected the matching messages deleted once sent, deleted %v", svc.deleted)
+	}
+	if !svc.onSource("b") {
+		t.Error("the message not matching -filter was deleted from the source")
+	}
+	if result.Sent != 2 || result.Deleted != 2 {
+		t.Errorf("got sent %d, deleted %d", result.Sent, result.Deleted)
+	}
+}
+
+func TestMigrateDryRunLeavesSource(t *testing.T) {
+	svc := newFakeSQS("a", "b")
+	result, err := Migrate(context.Background(), svc, Options{
+		SourceQueueURL: aws.String("https://sqs.us-east-1.amazonaws.com/123456789012/source"),
+		DestQueueURL:   aws.String("https://sqs.us-east-1.amazonaws.com/123456789012/dest"),
+	})
+	if err != nil {
+		t.Fatalf("Migrate returned %v", err)
+	}
+
+	if len(svc.sent) != 0 || len(svc.deleted) != 0 {
+		t.Errorf("a dry run sent %d and deleted %v", len(svc.sent), svc.deleted)
+	}
+	if result.Execute || result.Sent != 0 {
+		t.Errorf("got execute %v, sent %d", result.Execute, result.Sent)
+	}
+}
+
+func TestPreSendLeavesOutAndChanges(t *testing.T) {
+	svc := newFakeSQS("a", "b")
+	m := newTestMigrator(svc)
+	m.PreSend = func(batch []*MessageEnvelope) ([]*MessageEnvelope, error) {
+		kept := []*MessageEnvelope{}
+		for _, envelope := range batch {
+			if *envelope.Message.MessageId == "a" {
+				envelope.Entry.MessageBody = aws.String("enriched")
+				kept = append(kept, envelope)
+			}
+		}
+		return kept, nil
+	}
+
+	m.run(1)
+
+	if len(svc.sent) != 1 || *svc.sent[0].Id != "a" || *svc.sent[0].MessageBody != "enriched" {
+		t.Errorf("expected only the changed a sent, got %v", svc.sent)
+	}
+	if svc.onSource("a") || !svc.onSource("b") {
+		t.Errorf("expected a deleted and b left on the source, deleted %v", svc.deleted)
+	}
+}
+
+func TestPreSendInvalidBatchStopsRun(t *testing.T) {
+	other := &MessageEnvelope{Message: &types.Message{}, Entry: types.SendMessageBatchRequestEntry{Id: aws.String("other")}}
+	tests := map[string]func([]*MessageEnvelope) ([]*MessageEnvelope, error){
+		"error": func(batch []*MessageEnvelope) ([]*MessageEnvelope, error) {
+			return batch, errors.New("lookup failed")
+		},
+		"longer": func(batch []*MessageEnvelope) ([]*MessageEnvelope, error) {
+			return append(batch, batch[0]), nil
+		},
+		"nil envelope": func(batch []*MessageEnvelope) ([]*MessageEnvelope, error) {
+			return []*MessageEnvelope{nil}, nil
+		},
+		"nil message": func(batch []*MessageEnvelope) ([]*MessageEnvelope, error) {
+			batch[0].Message = nil
+			return batch, nil
+		},
+		"repeated id": func(batch []*MessageEnvelope) ([]*MessageEnvelope, error) {
+			batch[1].Entry.Id = batch[0].Entry.Id
+			return batch, nil
+		},
+		"unknown id": func(batch []*MessageEnvelope) ([]*MessageEnvelope, error) {
+			return []*MessageEnvelope{other}, nil
+		},
+	}
+	for name, preSend := range tests {
+		t.Run(name, func(t *testing.T) {
+			svc := newFakeSQS("a", "b")
+			_, err := Migrate(context.Background(), svc, Options{
+				SourceQueueURL: aws.String("https://sqs.us-east-1.amazonaws.com/123456789012/source"),
+				DestQueueURL:   aws.String("https://sqs.us-east-1.amazonaws.com/123456789012/dest"),
+				Execute:        true,
+				PreSend:        preSend,
+			})
+
+			if err == nil {
+				t.Error("expected Migrate to return the PreSend error")
+			}
+			if len(svc.sent) != 0 || !svc.onSource("a") || !svc.onSource("b") {
+				t.Errorf("expected the batch left unsent on the source, sent %d and deleted %v", len(svc.sent), svc.deleted)
+			}
+		})
+	}
+}
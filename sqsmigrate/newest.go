@@ -0,0 +1,139 @@
+package sqsmigrate
+
+import (
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// migrateNewestFirst migrates the most recently sent matching messages first.  SQS gives
+// no control over receive order, so the source is scanned up front, holding every
+// received message invisible, and once the scan is done the newest are migrated up to
+// -limit while the rest are released straight back to the source.
+//
+// The scan only covers what can be received within one visibility timeout, which is
+// -max-visibility as nothing has been sent yet.  On a deep queue the first messages become visible again before the scan finishes, so the
+// scan stops as soon as a message is seen a second time and only the messages seen so
+// far are ranked.  It returns the number of staged messages.
+func (m *migrator) migrateNewestFirst() int {
+	received := map[string]*types.Message{}
+	order := []*types.Message{}
+	for wrapped := false; !wrapped; {
+		if m.Calls.exhausted() {
+			atomic.StoreInt32(&m.stoppedOnCalls, 1)
+			break
+		}
+		messages, ok := m.receive(BatchSize)
+		if !ok && m.Interrupted.stopping() {
+			break
+		}
+		if !ok {
+			m.Interrupted.sleep(batchErrorPause)
+			continue
+		}
+		if len(messages) == 0 {
+			break
+		}
+		for i := range messages {
+			message := &messages[i]
+			if previous, ok := received[*message.MessageId]; ok {
+				// Only the latest receipt handle is valid.
+				previous.ReceiptHandle = message.ReceiptHandle
+				wrapped = true
+				continue
+			}
+			received[*message.MessageId] = message
+			order = append(order, message)
+		}
+	}
+
+	candidates := []*types.Message{}
+	rejected := []*types.Message{}
+	for _, message := range order {
+		if m.matches(message) {
+			if err := m.IDs.record(*message.MessageId); err != nil {
+				m.Interrupted.fail(m.Logger, err)
+				rejected = append(rejected, message)
+				continue
+			}
+			candidates = append(candidates, message)
+		} else {
+			rejected = append(rejected, message)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return sentTimestamp(candidates[i]) > sentTimestamp(candidates[j])
+	})
+
+	selected := m.Budget.reserve(len(candidates))
+	m.Logger.Printf("Scanned %d messages, migrating the newest %d of %d matches\n", len(order), selected, len(candidates))
+	m.release(append(rejected, candidates[selected:]...))
+
+	staged := 0
+	for start := 0; start < selected; start += BatchSize {
+		end := start + BatchSize
+		if end > selected {
+			end = selected
+		}
+		messagesToProcess := []*types.SendMessageBatchRequestEntry{}
+		stagedMessages := []*types.Message{}
+		idsToReceipts := make(map[string]*string)
+		for _, message := range candidates[start:end] {
+			if entry := m.stage(message); entry != nil {
+				messagesToProcess = append(messagesToProcess, entry)
+				stagedMessages = append(stagedMessages, message)
+				idsToReceipts[*message.MessageId] = message.ReceiptHandle
+			}
+		}
+		messagesToProcess, _ = m.preSend(stagedMessages, messagesToProcess, idsToReceipts)
+		staged += len(messagesToProcess)
+		m.migrate(messagesToProcess, idsToReceipts, nil)
+	}
+	m.Budget.settle(selected, staged)
+	if m.peek() {
+		m.release(candidates[:selected])
+	}
+	return staged
+}
+
+// release makes messages visible on the source again straight away instead of waiting
+// out their visibility timeout.
+func (m *migrator) release(messages []*types.Message) {
+	for start := 0; start < len(messages); start += BatchSize {
+		end := start + BatchSize
+		if end > len(messages) {
+			end = len(messages)
+		}
+		entries := []types.ChangeMessageVisibilityBatchRequestEntry{}
+		for i, message := range messages[start:end] {
+			entries = append(entries, types.ChangeMessageVisibilityBatchRequestEntry{
+				Id:                aws.String(strconv.Itoa(i)),
+				ReceiptHandle:     message.ReceiptHandle,
+				VisibilityTimeout: 0,
+			})
+		}
+		resp, err := m.sqsSvc.ChangeMessageVisibilityBatch(m.ctx, &sqs.ChangeMessageVisibilityBatchInput{
+			QueueUrl: m.SourceQueueURL,
+			Entries:  entries,
+		})
+		if err != nil {
+			m.Logger.Errorln("Error encountered while attempting to release messages back to the source")
+			m.Interrupted.fail(m.Logger, err)
+			continue
+		}
+		for _, failed := range resp.Failed {
+			m.Logger.Errorf("err releasing message - %s", aws.ToString(failed.Message))
+		}
+	}
+}
+
+// sentTimestamp is the message's SentTimestamp in milliseconds, or 0 when it is missing
+// so that such messages sort as the oldest.
+func sentTimestamp(message *types.Message) int64 {
+	sent, _ := strconv.ParseInt(message.Attributes[string(types.MessageSystemAttributeNameSentTimestamp)], 10, 64)
+	return sent
+}
@@ -0,0 +1,160 @@
+package sqsmigrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// notifyTimeout bounds the -notify-webhook request, so a webhook that doesn't answer
+// can't keep a finished run from exiting.
+const notifyTimeout = 10 * time.Second
+
+// Statuses of a -notify-webhook notification.
+const (
+	notifyCompleted = "completed"
+	notifyFailed    = "failed"
+)
+
+// notification is the JSON posted to -notify-webhook.  Text is a one line account of
+// the run, which is what a Slack incoming webhook shows.
+type notification struct {
+	Text     string   `json:"text"`
+	Status   string   `json:"status"`
+	ExitCode int      `json:"exit_code"`
+	Sources  []string `json:"sources"`
+	Dest     string   `json:"dest,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	Summary  *Summary `json:"summary,omitempty"`
+}
+
+// WebhookNotifier posts the outcome of the run to -notify-webhook once it finishes or
+// dies on a fatal error, so an unattended migration can be followed from a chat channel.
+// Only the first outcome is posted, and failing to post is logged but never fatal.  A
+// nil *WebhookNotifier posts nothing.
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+	logger *Logger
+	once   sync.Once
+
+	// mu guards what is known of the run, which fills in as the queues are resolved and
+	// the migration starts.
+	mu       sync.Mutex
+	sources  []string
+	dest     string
+	progress *RunProgress
+}
+
+func NewWebhookNotifier(url string, logger *Logger, sources []string, dest string) *WebhookNotifier {
+	if url == "" {
+		return nil
+	}
+	return &WebhookNotifier{
+		url:     url,
+		client:  &http.Client{Timeout: notifyTimeout},
+		logger:  logger,
+		sources: sources,
+		dest:    dest,
+	}
+}
+
+// Track records the queues of the run once they are resolved, and its progress, which
+// gives a fatal error the counts reached so far.
+func (n *WebhookNotifier) Track(sources []string, dest string, progress *RunProgress) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sources, n.dest, n.progress = sources, dest, progress
+}
+
+// Finished posts the final summary of a run ending with the exit status code.
+func (n *WebhookNotifier) Finished(result Summary, code int) {
+	if n == nil {
+		return
+	}
+	status := notifyCompleted
+	if code != 0 {
+		status = notifyFailed
+	}
+	// The counts are what a channel needs, not every batch of a long run.
+	result.Batches, result.BatchesOmitted = nil, 0
+	n.post(status, code, "", &result)
+}
+
+// Fatal posts the error a run is dying of.  It is the Logger's OnFatal hook.
+func (n *WebhookNotifier) Fatal(message string) {
+	n.Failed(1, message)
+}
+
+// Failed posts the error a run is stopping on with the exit status code, along with the
+// counts reached so far.
+func (n *WebhookNotifier) Failed(code int, message string) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	progress := n.progress
+	n.mu.Unlock()
+	var partial *Summary
+	if progress != nil {
+		snapshot := progress.snapshot()
+		partial = &snapshot
+	}
+	n.post(notifyFailed, code, message, partial)
+}
+
+func (n *WebhookNotifier) post(status string, code int, message string, result *Summary) {
+	n.once.Do(func() {
+		n.mu.Lock()
+		payload := notification{
+			Status:   status,
+			ExitCode: code,
+			Sources:  n.sources,
+			Dest:     n.dest,
+			Error:    strings.TrimSpace(message),
+			Summary:  result,
+		}
+		n.mu.Unlock()
+		payload.Text = payload.describe()
+		body, err := json.Marshal(payload)
+		if err != nil {
+			n.logger.Errorf("Encountered an error when attempting to encode the -notify-webhook notification: %s\n", err)
+			return
+		}
+		resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			n.logger.Errorf("Encountered an error when attempting to post to -notify-webhook: %s\n", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			n.logger.Errorf("The -notify-webhook answered %s, the notification may not have been delivered\n", resp.Status)
+		}
+	})
+}
+
+// describe sums the notification up in a line.
+func (p notification) describe() string {
+	run := strings.Join(p.Sources, ", ")
+	if p.Dest != "" {
+		run += " into " + p.Dest
+	}
+	text := fmt.Sprintf("Migration from %s %s", run, p.Status)
+	if p.Status == notifyFailed {
+		text += fmt.Sprintf(" (exit status %d)", p.ExitCode)
+	}
+	if p.Summary != nil {
+		text += fmt.Sprintf(": %d processed, %d sent, %d failed to send, %d deleted in %s", p.Summary.Processed, p.Summary.Sent, p.Summary.SendFailed, p.Summary.Deleted, time.Duration(p.Summary.DurationSeconds*float64(time.Second)).Round(time.Second))
+	}
+	if p.Error != "" {
+		text += ": " + p.Error
+	}
+	return text
+}
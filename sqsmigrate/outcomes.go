@@ -0,0 +1,103 @@
+package sqsmigrate
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+	"time"
+)
+
+// outcomeColumns is the header row of a -csv-outcome file.
+var outcomeColumns = []string{"time", "message_id", "queue", "age_seconds", "action", "dest", "result", "error_code", "reason"}
+
+// Results of a message in a -csv-outcome file.
+const (
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+	outcomeSkipped = "skipped"
+	outcomeDryRun  = "dry_run"
+)
+
+// OutcomeLedger writes one CSV row for what became of every message received with
+// -csv-outcome, a record of the run to keep.  Rows are written and flushed as the run
+// goes, and only the ages of the messages still in hand are remembered.  A nil
+// *OutcomeLedger writes nothing.
+type OutcomeLedger struct {
+	file *os.File
+	w    *csv.Writer
+	// ages holds the age of each message received but not yet accounted for, keyed by
+	// queue and MessageId.
+	ages map[string]*float64
+}
+
+func OpenOutcomeLedger(path string) (*OutcomeLedger, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	l := &OutcomeLedger{file: file, w: csv.NewWriter(file), ages: map[string]*float64{}}
+	if err := l.write(outcomeColumns); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *OutcomeLedger) write(row []string) error {
+	if err := l.w.Write(row); err != nil {
+		return err
+	}
+	l.w.Flush()
+	return l.w.Error()
+}
+
+// record writes the row for an event that settles a message, remembering the age that
+// comes with its received event until then.  The caller serialises calls.
+func (l *OutcomeLedger) record(event messageEvent) error {
+	if l == nil {
+		return nil
+	}
+	key := event.Queue + "/" + event.MessageId
+	if event.Event == eventReceived {
+		l.ages[key] = event.AgeSeconds
+		return nil
+	}
+	age := ""
+	if seconds := l.ages[key]; seconds != nil {
+		age = strconv.FormatFloat(*seconds, 'f', 0, 64)
+	}
+	delete(l.ages, key)
+
+	action, result := "migrate", outcomeSuccess
+	switch {
+	case event.Event == eventSkipped:
+		action, result = "skip", outcomeSkipped
+	case event.Event == eventFailed:
+		result = outcomeFailure
+	case event.DryRun:
+		result = outcomeDryRun
+	}
+	return l.write([]string{
+		event.Time.Format(time.RFC3339),
+		event.MessageId,
+		event.Queue,
+		age,
+		action,
+		event.Dest,
+		result,
+		event.Code,
+		event.Reason,
+	})
+}
+
+func (l *OutcomeLedger) close() error {
+	if l == nil {
+		return nil
+	}
+	l.w.Flush()
+	if err := l.w.Error(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
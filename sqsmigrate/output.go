@@ -0,0 +1,83 @@
+package sqsmigrate
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// outputData is what an -output-template is rendered against for each staged message.
+type outputData struct {
+	MessageId string
+	Queue     string
+	// Body is the body as it would be sent, after any transform.
+	Body string
+	Age  time.Duration
+	// Attributes holds the String and Number message attributes, and Binary ones
+	// base64 encoded.
+	Attributes       map[string]string
+	SystemAttributes map[string]string
+}
+
+// MessageOutput writes a line to stdout for each staged message with -output-template,
+// in place of the preview logged in verbose mode or with -dry-run-sample.  A nil
+// *MessageOutput writes nothing.
+type MessageOutput struct {
+	mu   sync.Mutex
+	tmpl *template.Template
+	w    io.Writer
+}
+
+// ParseOutputTemplate parses an -output-template, which ends each message with a newline
+// unless it already does.  {{json .Attributes}} renders any field as JSON.
+func ParseOutputTemplate(text string, w io.Writer) (*MessageOutput, error) {
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	tmpl, err := template.New("output").Option("missingkey=error").Funcs(template.FuncMap{
+		"json": func(value interface{}) (string, error) {
+			encoded, err := json.Marshal(value)
+			return string(encoded), err
+		},
+	}).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &MessageOutput{tmpl: tmpl, w: w}, nil
+}
+
+func (o *MessageOutput) write(message *types.Message, queue, body string, age time.Duration) error {
+	if o == nil {
+		return nil
+	}
+	data := outputData{
+		MessageId:        aws.ToString(message.MessageId),
+		Queue:            queue,
+		Body:             body,
+		Age:              age,
+		Attributes:       map[string]string{},
+		SystemAttributes: message.Attributes,
+	}
+	for name, value := range message.MessageAttributes {
+		if value.StringValue != nil {
+			data.Attributes[name] = *value.StringValue
+		} else {
+			data.Attributes[name] = base64.StdEncoding.EncodeToString(value.BinaryValue)
+		}
+	}
+	var line strings.Builder
+	if err := o.tmpl.Execute(&line, data); err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, err := io.WriteString(o.w, line.String())
+	return err
+}
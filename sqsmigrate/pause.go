@@ -0,0 +1,84 @@
+package sqsmigrate
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// Pauser holds off new receives while paused, toggled by each of pauseSignals so an
+// operator can let consumers catch up without stopping the run.  Batches already
+// received are finished first.  A nil pauser never pauses.
+type Pauser struct {
+	mu     sync.Mutex
+	resume chan struct{}
+}
+
+func WatchPauseSignal(logger *Logger) *Pauser {
+	if len(pauseSignals) == 0 {
+		return nil
+	}
+	p := &Pauser{}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, pauseSignals...)
+	go func() {
+		for range signals {
+			if p.toggle() {
+				logger.Println("Paused, finishing the current batches and receiving nothing more until signalled again")
+			} else {
+				logger.Println("Resuming")
+			}
+		}
+	}()
+	return p
+}
+
+// toggle pauses or resumes, reporting whether it is now paused.
+func (p *Pauser) toggle() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resume == nil {
+		p.resume = make(chan struct{})
+		return true
+	}
+	close(p.resume)
+	p.resume = nil
+	return false
+}
+
+// set pauses or resumes, reporting whether that changed anything.
+func (p *Pauser) set(paused bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if (p.resume != nil) == paused {
+		return false
+	}
+	if paused {
+		p.resume = make(chan struct{})
+	} else {
+		close(p.resume)
+		p.resume = nil
+	}
+	return true
+}
+
+// wait blocks while paused, returning early once interrupted.
+func (p *Pauser) wait(interrupted *Interrupt) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	resume := p.resume
+	p.mu.Unlock()
+	if resume == nil {
+		return
+	}
+	if interrupted == nil {
+		<-resume
+		return
+	}
+	select {
+	case <-resume:
+	case <-interrupted.done:
+	}
+}
@@ -0,0 +1,212 @@
+package sqsmigrate
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// pipelineMessage is the message each step of a -pipeline works on, as the previous
+// step left it.
+type pipelineMessage struct {
+	id    string
+	queue string
+	body  string
+	// attributes are the message attributes, carried over from the source when any step
+	// works on them.
+	attributes map[string]types.MessageAttributeValue
+	// envelope is the SNS notification an unwrap-sns step took the body out of, for a
+	// later rewrap-sns step to put it back in.
+	envelope *snsEnvelope
+}
+
+// pipelineStep is one step of a -pipeline.  A step only sees the message the steps
+// before it produced, so any of them can follow any other.
+type pipelineStep interface {
+	apply(ctx context.Context, message *pipelineMessage) error
+}
+
+// stepFunc adapts a function to a pipelineStep.
+type stepFunc func(ctx context.Context, message *pipelineMessage) error
+
+func (f stepFunc) apply(ctx context.Context, message *pipelineMessage) error {
+	return f(ctx, message)
+}
+
+// Pipeline is the steps of a -pipeline file in the order they run.
+type Pipeline struct {
+	steps []pipelineStep
+	// names are the step names, for errors to say which step failed.
+	names []string
+	// attributes is set when a step works on message attributes.
+	attributes bool
+}
+
+// LoadPipeline reads a -pipeline file.  Each line is a step name followed by its
+// argument, which takes up the rest of the line.  Blank lines and lines starting with #
+// are ignored.  Every step is checked up front, templates parsed and commands looked
+// up, so a bad one fails the run before anything moves.  Exec steps share the
+// -transform-exec-concurrency and -transform-exec-timeout limits.
+func LoadPipeline(path string, execConcurrency int, execTimeout time.Duration) (*Pipeline, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &Pipeline{}
+	unwrapped := false
+	for i, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, arg := line, ""
+		if space := strings.IndexAny(line, " \t"); space > 0 {
+			name, arg = line[:space], strings.TrimSpace(line[space:])
+		}
+		needsArg := name != "unwrap-sns" && name != "rewrap-sns"
+		switch {
+		case needsArg && arg == "":
+			return nil, fmt.Errorf("line %d: step %s needs an argument", i+1, name)
+		case !needsArg && arg != "":
+			return nil, fmt.Errorf("line %d: step %s takes no argument", i+1, name)
+		}
+
+		var step pipelineStep
+		switch name {
+		case "unwrap-sns":
+			unwrapped = true
+			step = stepFunc(unwrapStep)
+		case "rewrap-sns":
+			if !unwrapped {
+				return nil, fmt.Errorf("line %d: rewrap-sns needs an unwrap-sns step before it", i+1)
+			}
+			step = stepFunc(rewrapStep)
+		case "template":
+			tmpl, err := ParseTransformTemplate(arg)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", i+1, err)
+			}
+			step = stepFunc(func(ctx context.Context, message *pipelineMessage) error {
+				body, err := transformBody(tmpl, transformData{Body: message.body, MessageId: message.id, Queue: message.queue})
+				message.body = body
+				return err
+			})
+		case "exec":
+			command, err := NewExecTransform(arg, execConcurrency, execTimeout)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", i+1, err)
+			}
+			step = stepFunc(func(ctx context.Context, message *pipelineMessage) error {
+				body, err := command.run(ctx, transformData{Body: message.body, MessageId: message.id, Queue: message.queue})
+				message.body = body
+				return err
+			})
+		case "prefix":
+			step = stepFunc(func(ctx context.Context, message *pipelineMessage) error {
+				message.body = arg + message.body
+				return nil
+			})
+		case "suffix":
+			step = stepFunc(func(ctx context.Context, message *pipelineMessage) error {
+				message.body += arg
+				return nil
+			})
+		case "set-attr":
+			set := AttributeList{}
+			if err := set.Set(arg); err != nil {
+				return nil, fmt.Errorf("line %d: %s", i+1, err)
+			}
+			p.attributes = true
+			step = stepFunc(func(ctx context.Context, message *pipelineMessage) error {
+				for name, value := range set {
+					message.attributes[name] = value
+				}
+				return nil
+			})
+		case "drop-attr":
+			p.attributes = true
+			step = stepFunc(func(ctx context.Context, message *pipelineMessage) error {
+				delete(message.attributes, arg)
+				return nil
+			})
+		case "rename-attr":
+			renames := AttributeRenames{}
+			if err := renames.Set(arg); err != nil {
+				return nil, fmt.Errorf("line %d: %s", i+1, err)
+			}
+			p.attributes = true
+			step = stepFunc(func(ctx context.Context, message *pipelineMessage) error {
+				for from, to := range renames {
+					if value, ok := message.attributes[from]; ok {
+						delete(message.attributes, from)
+						message.attributes[to] = value
+					}
+				}
+				return nil
+			})
+		default:
+			return nil, fmt.Errorf("line %d: unknown step %q", i+1, name)
+		}
+		p.steps = append(p.steps, step)
+		p.names = append(p.names, name)
+	}
+	if len(p.steps) == 0 {
+		return nil, fmt.Errorf("no steps given")
+	}
+	return p, nil
+}
+
+// unwrapStep replaces an SNS notification with its inner message.  A body that isn't a
+// notification is left as it is, as with -unwrap-sns.
+func unwrapStep(ctx context.Context, message *pipelineMessage) error {
+	if envelope, ok := parseSNSEnvelope(message.body); ok {
+		message.body, message.envelope = envelope.message, envelope
+	}
+	return nil
+}
+
+// rewrapStep puts the body back in the notification unwrapStep took it out of.
+func rewrapStep(ctx context.Context, message *pipelineMessage) error {
+	if message.envelope == nil {
+		return nil
+	}
+	body, err := message.envelope.rewrap(message.body)
+	if err != nil {
+		return err
+	}
+	message.body, message.envelope = body, nil
+	return nil
+}
+
+// usesAttributes reports whether any step works on message attributes, which then need
+// receiving in full.
+func (p *Pipeline) usesAttributes() bool {
+	return p != nil && p.attributes
+}
+
+// run passes a message through every step in turn, stopping at the first that fails.
+func (p *Pipeline) run(ctx context.Context, message *types.Message, queue, body string) (*pipelineMessage, error) {
+	current := &pipelineMessage{id: *message.MessageId, queue: queue, body: body}
+	if p.attributes {
+		current.attributes = map[string]types.MessageAttributeValue{}
+		for name, value := range message.MessageAttributes {
+			current.attributes[name] = value
+		}
+	}
+	for i, step := range p.steps {
+		if err := step.apply(ctx, current); err != nil {
+			return nil, fmt.Errorf("step %d (%s): %s", i+1, p.names[i], err)
+		}
+	}
+	if current.body == "" {
+		return nil, fmt.Errorf("the pipeline left an empty body, which SQS does not accept")
+	}
+	if len(current.attributes) > maxMessageAttributes {
+		return nil, fmt.Errorf("the pipeline left %d message attributes, SQS allows at most %d", len(current.attributes), maxMessageAttributes)
+	}
+	return current, nil
+}
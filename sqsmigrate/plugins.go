@@ -0,0 +1,88 @@
+package sqsmigrate
+
+import (
+	"fmt"
+	"path/filepath"
+	"plugin"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// pluginTransform is the signature of the Transform function a -plugin-dir plugin
+// exports.  It is given the body and the string form of the message attributes, and
+// returns the new body along with attributes to set on the migrated message.
+type pluginTransform func(body []byte, attrs map[string]string) ([]byte, map[string]string, error)
+
+// TransformPlugin is a Go plugin loaded from the -plugin-dir.
+type TransformPlugin struct {
+	Name      string
+	transform pluginTransform
+}
+
+// LoadPlugins opens every .so in dir, in name order, and checks that each one exports
+// a Transform function with the expected signature.  Plugins have to be built with
+// go build -buildmode=plugin against the same Go version and dependencies as this tool.
+func LoadPlugins(dir string) ([]TransformPlugin, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.so"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no .so plugins found in %s", dir)
+	}
+	sort.Strings(paths)
+
+	plugins := []TransformPlugin{}
+	for _, path := range paths {
+		p, err := plugin.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		symbol, err := p.Lookup("Transform")
+		if err != nil {
+			return nil, fmt.Errorf("plugin %s: %s", path, err)
+		}
+		// An exported func is looked up as the func itself, an exported variable
+		// holding one as a pointer to it.
+		var transform pluginTransform
+		switch symbol := symbol.(type) {
+		case func([]byte, map[string]string) ([]byte, map[string]string, error):
+			transform = symbol
+		case *func([]byte, map[string]string) ([]byte, map[string]string, error):
+			transform = *symbol
+		default:
+			return nil, fmt.Errorf("plugin %s: Transform is a %T rather than func([]byte, map[string]string) ([]byte, map[string]string, error)", path, symbol)
+		}
+		plugins = append(plugins, TransformPlugin{Name: filepath.Base(path), transform: transform})
+	}
+	return plugins, nil
+}
+
+// runPlugins passes a body through every plugin in turn, returning the final body and
+// the attributes the plugins set.
+func (m *migrator) runPlugins(message *types.Message, body string) (string, map[string]types.MessageAttributeValue, error) {
+	attrs := map[string]string{}
+	for name, value := range message.MessageAttributes {
+		if value.StringValue != nil {
+			attrs[name] = *value.StringValue
+		}
+	}
+	set := map[string]types.MessageAttributeValue{}
+	for _, p := range m.Plugins {
+		transformed, changed, err := p.transform([]byte(body), attrs)
+		if err != nil {
+			return "", nil, fmt.Errorf("plugin %s: %s", p.Name, err)
+		}
+		body = string(transformed)
+		for name, value := range changed {
+			if err := validAttributeName(name); err != nil {
+				return "", nil, fmt.Errorf("plugin %s: %s", p.Name, err)
+			}
+			attrs[name] = value
+			set[name] = types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+		}
+	}
+	return body, set, nil
+}
@@ -0,0 +1,88 @@
+package sqsmigrate
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// MessageEnvelope is a message about to be sent: the Message received from the source,
+// and the Entry sending it on.  The Entry's Id names the message received, which is the
+// one deleted from the source once the Entry is sent.
+type MessageEnvelope struct {
+	Message *types.Message
+	Entry   types.SendMessageBatchRequestEntry
+}
+
+// preSend hands a staged batch to Options.PreSend, returning the entries it kept and
+// the messages it left out, whose receipts are dropped from idsToReceipts.  An error
+// from PreSend, or a batch it returns that doesn't check out, stops the run with none of
+// the batch sent.
+func (m *migrator) preSend(messages []*types.Message, entries []*types.SendMessageBatchRequestEntry, idsToReceipts map[string]*string) ([]*types.SendMessageBatchRequestEntry, []*types.Message) {
+	if m.PreSend == nil || len(entries) == 0 {
+		return entries, nil
+	}
+	batch := make([]*MessageEnvelope, len(entries))
+	for i, entry := range entries {
+		batch[i] = &MessageEnvelope{Message: messages[i], Entry: *entry}
+	}
+	kept, err := m.PreSend(batch)
+	if err != nil {
+		err = fmt.Errorf("PreSend failed on a batch of %d messages: %s", len(entries), err)
+	} else if invalid := checkPreSend(entries, kept); invalid != nil {
+		err = fmt.Errorf("PreSend returned an invalid batch for %d messages: %s", len(entries), invalid)
+	}
+	if err != nil {
+		m.Interrupted.fail(m.Logger, err)
+		for _, entry := range entries {
+			delete(idsToReceipts, *entry.Id)
+		}
+		return nil, messages
+	}
+
+	sending := map[string]bool{}
+	staged := make([]*types.SendMessageBatchRequestEntry, len(kept))
+	for i, envelope := range kept {
+		entry := envelope.Entry
+		staged[i] = &entry
+		sending[*entry.Id] = true
+	}
+	left := []*types.Message{}
+	for i, entry := range entries {
+		if !sending[*entry.Id] {
+			delete(idsToReceipts, *entry.Id)
+			m.Events.skipped(*entry.Id, m.SourceName, "left out by PreSend")
+			left = append(left, messages[i])
+		}
+	}
+	return staged, left
+}
+
+// checkPreSend reports what is wrong with the batch PreSend returned for entries, which
+// may only leave out, reorder or change the envelopes it was given.
+func checkPreSend(entries []*types.SendMessageBatchRequestEntry, kept []*MessageEnvelope) error {
+	if len(kept) > len(entries) {
+		return fmt.Errorf("it returned %d envelopes for %d messages", len(kept), len(entries))
+	}
+	given := map[string]bool{}
+	for _, entry := range entries {
+		given[*entry.Id] = true
+	}
+	seen := map[string]bool{}
+	for i, envelope := range kept {
+		if envelope == nil {
+			return fmt.Errorf("envelope %d it returned is nil", i)
+		}
+		if envelope.Message == nil {
+			return fmt.Errorf("envelope %d it returned has no message", i)
+		}
+		if envelope.Entry.Id == nil || !given[*envelope.Entry.Id] {
+			return fmt.Errorf("envelope %d it returned isn't one of the batch it was given", i)
+		}
+		if seen[*envelope.Entry.Id] {
+			return fmt.Errorf("it returned entry %s more than once", *envelope.Entry.Id)
+		}
+		seen[*envelope.Entry.Id] = true
+	}
+	return nil
+}
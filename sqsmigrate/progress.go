@@ -0,0 +1,84 @@
+package sqsmigrate
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// RunProgress totals the sources already migrated in a run with the progress of the ones
+// being migrated, for the periodic -checkpoint-file saves and the progress printed on
+// SIGUSR1.
+type RunProgress struct {
+	budget  *Budget
+	batches *BatchLimit
+	started time.Time
+
+	mu       sync.Mutex
+	finished Summary
+	current  map[*migrator]bool
+}
+
+func NewRunProgress(shared *Budget, batches *BatchLimit) *RunProgress {
+	return &RunProgress{budget: shared, batches: batches, started: time.Now(), current: map[*migrator]bool{}}
+}
+
+// track adds m to the sources being migrated, of which there are several at once with
+// -parallel-queues.
+func (p *RunProgress) track(m *migrator) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.current[m] = true
+}
+
+// finish swaps m for the summary of the source that it just finished.
+func (p *RunProgress) finish(m *migrator, result Summary) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.finished.add(result)
+	delete(p.current, m)
+}
+
+// snapshot is the run's progress so far.  Removals are only counted for the sources
+// that have finished.
+func (p *RunProgress) snapshot() Summary {
+	p.mu.Lock()
+	total := p.finished
+	for m := range p.current {
+		total.add(m.progress())
+	}
+	p.mu.Unlock()
+
+	p.budget.mu.Lock()
+	total.Processed = p.budget.staged
+	p.budget.mu.Unlock()
+	total.DurationSeconds = time.Since(p.started).Seconds()
+	if total.DurationSeconds > 0 {
+		total.MessagesPerSecond = float64(total.Processed) / total.DurationSeconds
+	}
+	return total
+}
+
+// WatchProgressSignal logs the run's progress every time the process receives one of
+// progressSignals, SIGUSR1 where there is one, so a long migration can be checked on
+// with `kill -USR1` without stopping it.
+func WatchProgressSignal(logger *Logger, progress *RunProgress) {
+	if len(progressSignals) == 0 {
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, progressSignals...)
+	go func() {
+		for range signals {
+			s := progress.snapshot()
+			inFlight := ""
+			if progress.batches != nil {
+				inFlight = fmt.Sprintf(", %d of %d batches in flight", progress.batches.held(), cap(progress.batches.slots))
+			}
+			logger.Printf("Progress after %s: processed %d, sent %d, failed %d, %.2f messages/second%s\n",
+				time.Duration(s.DurationSeconds*float64(time.Second)).Round(time.Second), s.Processed, s.Sent, s.SendFailed, s.MessagesPerSecond, inFlight)
+		}
+	}()
+}
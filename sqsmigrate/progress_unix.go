@@ -0,0 +1,13 @@
+//go:build !windows
+
+package sqsmigrate
+
+import (
+	"os"
+	"syscall"
+)
+
+var (
+	progressSignals = []os.Signal{syscall.SIGUSR1}
+	pauseSignals    = []os.Signal{syscall.SIGUSR2}
+)
@@ -0,0 +1,10 @@
+package sqsmigrate
+
+import "os"
+
+// Windows has neither SIGUSR1 nor SIGUSR2, so progress is only shown in the closing
+// summary and a run can't be paused.
+var (
+	progressSignals []os.Signal
+	pauseSignals    []os.Signal
+)
@@ -0,0 +1,94 @@
+package sqsmigrate
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPreviewBytes is how much of a body -interactive shows before asking about it.
+const maxPreviewBytes = 500
+
+// Confirm asks a yes/no question and reads the answer from in.  Anything other than y or
+// yes, including end of input, is treated as no so a non-interactive run never proceeds
+// by accident.
+func Confirm(in io.Reader, out io.Writer, question string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// Approver asks the operator about each matched message in -interactive mode.  It keeps
+// one reader over the input for the whole run, as buffering would otherwise swallow the
+// answers to later questions.  A nil *Approver approves everything.
+type Approver struct {
+	mu   sync.Mutex
+	in   *bufio.Reader
+	out  io.Writer
+	all  bool
+	quit bool
+}
+
+func NewApprover(in io.Reader, out io.Writer) *Approver {
+	return &Approver{in: bufio.NewReader(in), out: out}
+}
+
+// approve shows a message and asks whether to migrate it.  Answering a approves it and
+// every message after it, q declines it and stops the run.
+func (a *Approver) approve(id string, age time.Duration, body string) bool {
+	if a == nil {
+		return true
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.quit {
+		return false
+	}
+	if a.all {
+		return true
+	}
+
+	preview := DescribeBody(body)
+	if len(preview) > maxPreviewBytes {
+		preview = preview[:maxPreviewBytes] + "..."
+	}
+	fmt.Fprintf(a.out, "\nMessage %s (age %s):\n%s\n", id, age.Round(time.Second), preview)
+	for {
+		fmt.Fprint(a.out, "Migrate this? [y/N/a(ll)/q(uit)] ")
+		answer, err := a.in.ReadString('\n')
+		if err != nil && answer == "" {
+			// The input is gone, so nothing more can be approved.
+			a.quit = true
+			return false
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true
+		case "", "n", "no":
+			return false
+		case "a", "all":
+			a.all = true
+			return true
+		case "q", "quit":
+			a.quit = true
+			return false
+		}
+	}
+}
+
+// stopped reports whether the operator has quit.
+func (a *Approver) stopped() bool {
+	if a == nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.quit
+}
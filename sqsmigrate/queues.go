@@ -0,0 +1,332 @@
+package sqsmigrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const maxListedQueues = 1000
+
+// emptyPollInterval is how often -assert-empty checks a source while waiting for it to
+// report empty.
+const emptyPollInterval = 5 * time.Second
+
+// QueueList is a flag.Value collecting queues from a flag that may be repeated, each
+// occurrence holding one or more comma separated queues.
+type QueueList []string
+
+func (q *QueueList) String() string {
+	return strings.Join(*q, ",")
+}
+
+func (q *QueueList) Set(value string) error {
+	for _, queue := range strings.Split(value, ",") {
+		if queue = strings.TrimSpace(queue); queue != "" {
+			*q = append(*q, queue)
+		}
+	}
+	return nil
+}
+
+// queueFilter selects -source-prefix queues by one of their attributes with
+// -queue-filter.  A value of * matches any queue with the attribute set, and an empty
+// value one without it.
+type queueFilter struct {
+	name  string
+	value string
+}
+
+// queueAttributeDefaults are what SQS means by an attribute it leaves out, so a standard
+// queue matches FifoQueue=false.
+var queueAttributeDefaults = map[string]string{
+	string(types.QueueAttributeNameFifoQueue):                 "false",
+	string(types.QueueAttributeNameContentBasedDeduplication): "false",
+}
+
+func (f queueFilter) String() string {
+	return f.name + "=" + f.value
+}
+
+func (f queueFilter) matches(attributes map[string]string) bool {
+	value, ok := attributes[f.name]
+	if !ok {
+		value = queueAttributeDefaults[f.name]
+	}
+	if f.value == "*" {
+		return value != ""
+	}
+	return value == f.value
+}
+
+// QueueFilterList is a flag.Value collecting a repeatable -queue-filter, every one of
+// which a queue has to match.
+type QueueFilterList []queueFilter
+
+func (l *QueueFilterList) String() string {
+	texts := []string{}
+	for _, f := range *l {
+		texts = append(texts, f.String())
+	}
+	return strings.Join(texts, ",")
+}
+
+func (l *QueueFilterList) Set(value string) error {
+	eq := strings.Index(value, "=")
+	if eq < 1 {
+		return fmt.Errorf("queue filter %q is not Attribute=value", value)
+	}
+	*l = append(*l, queueFilter{name: value[:eq], value: value[eq+1:]})
+	return nil
+}
+
+// Matches reports whether a queue's attributes pass every filter.
+func (l QueueFilterList) Matches(attributes map[string]string) bool {
+	for _, f := range l {
+		if !f.matches(attributes) {
+			return false
+		}
+	}
+	return true
+}
+
+// ResolveQueueURL turns a -source/-dest value into a queue URL.  The value may be a queue
+// name, which is looked up with GetQueueUrl, a queue ARN, or the queue URL itself.
+func ResolveQueueURL(ctx context.Context, sqsSvc *sqs.Client, queue string) (*string, error) {
+	if IsQueueURL(queue) {
+		region := sqsSvc.Options().Region
+		if urlRegion, ok := RegionFromQueueURL(queue); ok && urlRegion != region {
+			return nil, fmt.Errorf("queue %s is in %s but the client is configured for %s, use -region or -dest-region to select it", queue, urlRegion, region)
+		}
+		return aws.String(queue), nil
+	}
+	if !arn.IsARN(queue) {
+		resp, err := sqsSvc.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{QueueName: aws.String(queue)})
+		if err != nil {
+			return nil, err
+		}
+		return resp.QueueUrl, nil
+	}
+
+	queueARN, err := arn.Parse(queue)
+	if err != nil {
+		return nil, err
+	}
+	region := sqsSvc.Options().Region
+	if queueARN.Region != region {
+		return nil, fmt.Errorf("queue %s is in %s but the client is configured for %s, use -region or -dest-region to select it", queue, queueARN.Region, region)
+	}
+	queueURL, err := queueURLFromARN(ctx, queueARN)
+	if err != nil {
+		return nil, err
+	}
+	return aws.String(queueURL), nil
+}
+
+// QueueAttributes returns every attribute of a queue.
+func QueueAttributes(ctx context.Context, sqsSvc *sqs.Client, queueURL *string) (map[string]string, error) {
+	resp, err := sqsSvc.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       queueURL,
+		AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameAll},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Attributes, nil
+}
+
+// ApproximateMessages is the number of messages SQS reports as available on a queue,
+// leaving out those in flight or delayed.
+func ApproximateMessages(ctx context.Context, sqsSvc *sqs.Client, queueURL *string) (int, error) {
+	resp, err := sqsSvc.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       queueURL,
+		AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameApproximateNumberOfMessages},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(resp.Attributes[string(types.QueueAttributeNameApproximateNumberOfMessages)])
+}
+
+// remainingMessages is every message SQS reports on a queue: available, in flight and
+// delayed.
+func remainingMessages(ctx context.Context, sqsSvc *sqs.Client, queueURL *string) (int, error) {
+	resp, err := sqsSvc.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl: queueURL,
+		AttributeNames: []types.QueueAttributeName{
+			types.QueueAttributeNameApproximateNumberOfMessages,
+			types.QueueAttributeNameApproximateNumberOfMessagesNotVisible,
+			types.QueueAttributeNameApproximateNumberOfMessagesDelayed,
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, count := range resp.Attributes {
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// AwaitEmpty polls a queue for -assert-empty until it reports no messages at all or the
+// grace period runs out, as the counts SQS reports lag behind the deletes.  It returns
+// the last count.
+func AwaitEmpty(ctx context.Context, sqsSvc *sqs.Client, queueURL *string, grace time.Duration) (int, error) {
+	deadline := time.Now().Add(grace)
+	for {
+		n, err := remainingMessages(ctx, sqsSvc, queueURL)
+		if err != nil || n == 0 || time.Now().After(deadline) {
+			return n, err
+		}
+		time.Sleep(emptyPollInterval)
+	}
+}
+
+// ListQueues returns the URL of every queue whose name starts with prefix.  The results
+// are paged through, but a prefix matching more than 1000 queues is refused as it is far
+// more likely to be a mistake than a migration anyone wants.
+func ListQueues(ctx context.Context, sqsSvc *sqs.Client, prefix string) ([]string, error) {
+	queueURLs := []string{}
+	input := &sqs.ListQueuesInput{}
+	if prefix != "" {
+		input.QueueNamePrefix = aws.String(prefix)
+	}
+	pages := sqs.NewListQueuesPaginator(sqsSvc, input)
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		queueURLs = append(queueURLs, page.QueueUrls...)
+		if len(queueURLs) > maxListedQueues {
+			return nil, fmt.Errorf("prefix %q matches more than %d queues, use a longer prefix", prefix, maxListedQueues)
+		}
+	}
+	return queueURLs, nil
+}
+
+// WriteQueueList prints the name and approximate depth of every queue starting with
+// prefix, or of every queue when it is empty, for -list-queues.
+func WriteQueueList(ctx context.Context, sqsSvc *sqs.Client, w io.Writer, prefix string) error {
+	queueURLs, err := ListQueues(ctx, sqsSvc, prefix)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "QUEUE\tMESSAGES")
+	for _, queueURL := range queueURLs {
+		depth, err := ApproximateMessages(ctx, sqsSvc, aws.String(queueURL))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "%s\t%d\n", path.Base(queueURL), depth)
+	}
+	return tw.Flush()
+}
+
+// IsQueueURL reports whether a -source/-dest value is a queue URL rather than a name or
+// ARN.
+func IsQueueURL(queue string) bool {
+	return strings.HasPrefix(queue, "https://") || strings.HasPrefix(queue, "http://")
+}
+
+// RegionFromQueueURL infers the region of a queue from the host of its URL, which is
+// sqs.<region>.amazonaws.com, or <region>.queue.amazonaws.com and queue.amazonaws.com
+// for us-east-1 in the legacy form.  Hosts such as a local SQS-compatible server have no
+// region to infer.
+func RegionFromQueueURL(queue string) (string, bool) {
+	u, err := url.Parse(queue)
+	if err != nil {
+		return "", false
+	}
+	host := u.Hostname()
+	if host == "queue.amazonaws.com" {
+		return "us-east-1", true
+	}
+	labels := strings.Split(host, ".")
+	if len(labels) < 3 {
+		return "", false
+	}
+	region := ""
+	switch {
+	case labels[0] == "sqs" || labels[0] == "sqs-fips":
+		region = labels[1]
+	case labels[1] == "queue":
+		region = labels[0]
+	}
+	return region, region != "" && KnownRegion(region)
+}
+
+// queueURLFromARN builds the URL for an SQS queue ARN.  The endpoint comes from the SDK's
+// endpoint rules rather than assuming amazonaws.com, so GovCloud (aws-us-gov) and China
+// (aws-cn) queues get the correct host.
+func queueURLFromARN(ctx context.Context, queueARN arn.ARN) (string, error) {
+	if queueARN.Service != "sqs" {
+		return "", fmt.Errorf("%s is not an SQS queue ARN", queueARN)
+	}
+	if queueARN.AccountID == "" || queueARN.Resource == "" || strings.Contains(queueARN.Resource, ":") {
+		return "", fmt.Errorf("%s does not identify a single queue", queueARN)
+	}
+	// Each partition has a <partition>-global pseudo-region in the SDK's rules.  One that
+	// falls back to the standard partition's endpoints is a partition the rules don't
+	// know, which can't be checked.
+	global := queueARN.Partition + "-global"
+	known := queueARN.Partition == "aws" || !SamePartition(ctx, global, "aws-global")
+	if known && !SamePartition(ctx, queueARN.Region, global) {
+		return "", fmt.Errorf("%s uses partition %s but region %s belongs to another", queueARN, queueARN.Partition, queueARN.Region)
+	}
+
+	endpoint, err := sqs.NewDefaultEndpointResolverV2().ResolveEndpoint(ctx, sqs.EndpointParameters{Region: aws.String(queueARN.Region)})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(endpoint.URI.String(), "/") + "/" + queueARN.AccountID + "/" + queueARN.Resource, nil
+}
+
+// SamePartition reports whether two regions belong to the same partition.  The endpoint
+// rules don't name the partition they pick, so regions are compared by the standard and
+// FIPS endpoints the rules give them, with the region itself taken out.  Regions the
+// rules don't recognise fall back to the standard aws partition.
+func SamePartition(ctx context.Context, a, b string) bool {
+	return partitionEndpoints(ctx, a) == partitionEndpoints(ctx, b)
+}
+
+func partitionEndpoints(ctx context.Context, region string) string {
+	resolver := sqs.NewDefaultEndpointResolverV2()
+	hosts := []string{}
+	for _, fips := range []bool{false, true} {
+		endpoint, err := resolver.ResolveEndpoint(ctx, sqs.EndpointParameters{Region: aws.String(region), UseFIPS: aws.Bool(fips)})
+		if err != nil {
+			return ""
+		}
+		hosts = append(hosts, strings.Replace(endpoint.URI.Host, "."+region+".", ".{region}.", 1))
+	}
+	return strings.Join(hosts, " ")
+}
+
+// regionName is the shape every AWS region name shares, such as us-east-1 or
+// us-gov-west-1.
+var regionName = regexp.MustCompile(`^[a-z]{2,4}(-[a-z]+)+-\d+$`)
+
+// KnownRegion reports whether the region is named like an AWS region.  Others still
+// work, they just fall back to the standard partition's endpoint pattern.
+func KnownRegion(region string) bool {
+	return regionName.MatchString(region)
+}
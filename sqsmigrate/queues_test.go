@@ -0,0 +1,64 @@
+package sqsmigrate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+func TestQueueURLFromARN(t *testing.T) {
+	for _, tc := range []struct {
+		arn, url string
+	}{
+		{"arn:aws:sqs:us-east-1:123456789012:orders", "https://sqs.us-east-1.amazonaws.com/123456789012/orders"},
+		{"arn:aws-us-gov:sqs:us-gov-west-1:123456789012:orders", "https://sqs.us-gov-west-1.amazonaws.com/123456789012/orders"},
+		{"arn:aws-cn:sqs:cn-north-1:123456789012:orders.fifo", "https://sqs.cn-north-1.amazonaws.com.cn/123456789012/orders.fifo"},
+		{"arn:aws-eusc:sqs:eusc-de-east-1:123456789012:orders", "https://sqs.eusc-de-east-1.amazonaws.eu/123456789012/orders"},
+	} {
+		queueARN, err := arn.Parse(tc.arn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		url, err := queueURLFromARN(context.Background(), queueARN)
+		if err != nil {
+			t.Errorf("%s: %s", tc.arn, err)
+		} else if url != tc.url {
+			t.Errorf("%s: got %s, want %s", tc.arn, url, tc.url)
+		}
+	}
+}
+
+func TestQueueURLFromARNPartitionMismatch(t *testing.T) {
+	for _, s := range []string{
+		"arn:aws:sqs:us-gov-west-1:123456789012:orders",
+		"arn:aws:sqs:cn-north-1:123456789012:orders",
+		"arn:aws-cn:sqs:us-east-1:123456789012:orders",
+	} {
+		queueARN, err := arn.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := queueURLFromARN(context.Background(), queueARN); err == nil {
+			t.Errorf("%s: expected the partition not matching the region to be rejected", s)
+		}
+	}
+}
+
+func TestSamePartition(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		same bool
+	}{
+		{"us-east-1", "eu-west-1", true},
+		{"us-east-1", "localhost", true},
+		{"us-gov-west-1", "us-gov-east-1", true},
+		{"us-east-1", "us-gov-west-1", false},
+		{"us-east-1", "cn-north-1", false},
+		{"cn-north-1", "us-iso-east-1", false},
+	} {
+		if got := SamePartition(context.Background(), tc.a, tc.b); got != tc.same {
+			t.Errorf("%s and %s: got same partition %t, want %t", tc.a, tc.b, got, tc.same)
+		}
+	}
+}
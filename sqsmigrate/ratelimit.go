@@ -0,0 +1,39 @@
+package sqsmigrate
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimit paces the sends of every worker and source to -rate messages per second, so
+// a downstream consumer isn't flooded with a backlog all at once.  Each batch waits its
+// turn and pushes the next one back by its share of the rate.  A nil *RateLimit never
+// waits.
+type RateLimit struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func NewRateLimit(perSecond float64) *RateLimit {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &RateLimit{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// wait blocks until n more messages may be sent.
+func (r *RateLimit) wait(n int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	now := time.Now()
+	if r.next.Before(now) {
+		r.next = now
+	}
+	at := r.next
+	r.next = r.next.Add(time.Duration(n) * r.interval)
+	r.mu.Unlock()
+	time.Sleep(time.Until(at))
+}
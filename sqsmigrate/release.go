@@ -0,0 +1,29 @@
+package sqsmigrate
+
+import "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+// releaseRejected makes messages this run decided not to migrate visible on the source
+// again straight away with -release-nonmatching, rather than hiding them from its real
+// consumers for the rest of the visibility timeout.  Each message is only released once,
+// as releasing it again when it comes straight back would spin on the same messages.
+func (m *migrator) releaseRejected(rejected []*types.Message) {
+	if !m.ReleaseNonmatching || m.peek() || len(rejected) == 0 {
+		return
+	}
+	m.releasedMu.Lock()
+	for _, message := range rejected {
+		m.released[*message.MessageId] = true
+	}
+	m.releasedMu.Unlock()
+	m.release(rejected)
+}
+
+// releasedBefore reports whether a message has already been released in this run.
+func (m *migrator) releasedBefore(message *types.Message) bool {
+	if !m.ReleaseNonmatching {
+		return false
+	}
+	m.releasedMu.Lock()
+	defer m.releasedMu.Unlock()
+	return m.released[*message.MessageId]
+}
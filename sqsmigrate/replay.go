@@ -0,0 +1,179 @@
+package sqsmigrate
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// ReplayErrors re-attempts the failures recorded in an error file.  Failed sends are
+// re-sent to the destination with destSvc and then removed from the source using the
+// recorded receipt handle, while failed deletes are simply attempted again.  Anything that still
+// fails is recorded to errs so a later replay can pick it up.  Each record is removed from
+// the source queue it was read from, falling back to sourceQueueURL for records written
+// before the source was recorded.  Only a message removed from the source counts as
+// recovered.  One whose receipt handle has expired is already on the destination and
+// will be migrated again from the source, so it is counted as a duplicate instead, which
+// ReplayErrors returns.
+func ReplayErrors(ctx context.Context, sqsSvc, destSvc API, logger *Logger, records []ErrorRecord, sourceQueueURL, destQueueURL *string, execute bool, errs *ErrorFile) int {
+	sends := []ErrorRecord{}
+	deletes := []ErrorRecord{}
+	for _, record := range records {
+		switch record.Kind {
+		case sendFailure:
+			sends = append(sends, record)
+		case deleteFailure:
+			deletes = append(deletes, record)
+		default:
+			logger.Printf("Ignoring record %s with unknown kind %q\n", record.ID, record.Kind)
+		}
+	}
+
+	logger.Printf("Loaded %d failed sends and %d failed deletes to replay\n", len(sends), len(deletes))
+	if !execute {
+		logger.Println("In Dry-Run mode.  No failures were replayed")
+		return 0
+	}
+
+	recovered, duplicates := 0, 0
+	for start := 0; start < len(sends); start += BatchSize {
+		end := start + BatchSize
+		if end > len(sends) {
+			end = len(sends)
+		}
+		batch := sends[start:end]
+		entries := []types.SendMessageBatchRequestEntry{}
+		for i, record := range batch {
+			entry := types.SendMessageBatchRequestEntry{
+				Id:                aws.String(strconv.Itoa(i)),
+				MessageBody:       aws.String(record.Body),
+				MessageAttributes: record.Attributes,
+			}
+			if record.GroupID != "" {
+				entry.MessageGroupId = aws.String(record.GroupID)
+			}
+			if record.DedupID != "" {
+				entry.MessageDeduplicationId = aws.String(record.DedupID)
+			}
+			entries = append(entries, entry)
+		}
+		resp, err := destSvc.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+			QueueUrl: destQueueURL,
+			Entries:  entries,
+		})
+		if err != nil {
+			logger.Errorln("Error attempting to replay a batch of failed sends")
+			logger.Fatal(err)
+		}
+
+		for _, failed := range resp.Failed {
+			index, _ := strconv.Atoi(*failed.Id)
+			logger.Errorf("err replaying send of %s - %s", batch[index].ID, *failed.Message)
+			entries[index].Id = aws.String(batch[index].ID)
+			if err := errs.recordSend(recordSource(batch[index], sourceQueueURL), &entries[index], aws.String(batch[index].ReceiptHandle), failed); err != nil {
+				logger.Fatal(err)
+			}
+		}
+
+		toDelete := []ErrorRecord{}
+		for _, sent := range resp.Successful {
+			index, _ := strconv.Atoi(*sent.Id)
+			toDelete = append(toDelete, batch[index])
+		}
+		for _, group := range groupBySource(toDelete, sourceQueueURL) {
+			deleted, expired := replayDeletes(ctx, sqsSvc, logger, group, recordSource(group[0], sourceQueueURL), errs)
+			recovered += deleted
+			duplicates += expired
+		}
+	}
+
+	for _, group := range groupBySource(deletes, sourceQueueURL) {
+		for start := 0; start < len(group); start += BatchSize {
+			end := start + BatchSize
+			if end > len(group) {
+				end = len(group)
+			}
+			deleted, expired := replayDeletes(ctx, sqsSvc, logger, group[start:end], recordSource(group[0], sourceQueueURL), errs)
+			recovered += deleted
+			duplicates += expired
+		}
+	}
+
+	logger.Printf("Recovered %d of %d recorded failures", recovered, len(sends)+len(deletes))
+	if duplicates > 0 {
+		logger.Errorf("%d messages already on the destination could not be removed from the source and will be migrated again as duplicates\n", duplicates)
+	}
+	return duplicates
+}
+
+// replayDeletes removes a batch of messages from the source using their recorded receipt
+// handles and returns how many were removed, and how many had expired handles.  Receipt
+// handles are only valid while the original receive is in flight, so an expired handle
+// is reported rather than recorded again: the message has already become visible on the
+// source and a normal run will pick it up.
+func replayDeletes(ctx context.Context, sqsSvc API, logger *Logger, batch []ErrorRecord, sourceQueueURL *string, errs *ErrorFile) (int, int) {
+	if len(batch) == 0 {
+		return 0, 0
+	}
+
+	entries := []types.DeleteMessageBatchRequestEntry{}
+	for i, record := range batch {
+		entries = append(entries, types.DeleteMessageBatchRequestEntry{
+			Id:            aws.String(strconv.Itoa(i)),
+			ReceiptHandle: aws.String(record.ReceiptHandle),
+		})
+	}
+	resp, err := sqsSvc.DeleteMessageBatch(ctx, &sqs.DeleteMessageBatchInput{
+		QueueUrl: sourceQueueURL,
+		Entries:  entries,
+	})
+	if err != nil {
+		logger.Errorln("Error encountered while attempting to replay a batch of deletes")
+		logger.Fatal(err)
+	}
+
+	expired := 0
+	for _, failed := range resp.Failed {
+		index, _ := strconv.Atoi(*failed.Id)
+		if aws.ToString(failed.Code) == receiptHandleIsInvalid {
+			logger.Errorf("Receipt handle for %s has expired, the message will reappear on the source and be migrated again\n", batch[index].ID)
+			expired++
+			continue
+		}
+		logger.Errorf("err replaying delete of %s - %s", batch[index].ID, *failed.Message)
+		entries[index].Id = aws.String(batch[index].ID)
+		if err := errs.recordDelete(sourceQueueURL, &entries[index], failed); err != nil {
+			logger.Fatal(err)
+		}
+	}
+	return len(resp.Successful), expired
+}
+
+// recordSource returns the source queue a record was read from.
+func recordSource(record ErrorRecord, fallback *string) *string {
+	if record.Source == "" {
+		return fallback
+	}
+	return aws.String(record.Source)
+}
+
+// groupBySource splits records by their source queue, keeping the order in which each
+// queue first appears.
+func groupBySource(records []ErrorRecord, fallback *string) [][]ErrorRecord {
+	groups := [][]ErrorRecord{}
+	index := map[string]int{}
+	for _, record := range records {
+		source := aws.ToString(recordSource(record, fallback))
+		i, ok := index[source]
+		if !ok {
+			i = len(groups)
+			index[source] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], record)
+	}
+	return groups
+}
@@ -0,0 +1,28 @@
+package sqsmigrate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestReplayCountsExpiredHandlesAsDuplicates(t *testing.T) {
+	svc := newFakeSQS("ok", "late")
+	svc.expired["receipt-late"] = true
+	records := []ErrorRecord{
+		{Kind: sendFailure, ID: "ok", ReceiptHandle: "receipt-ok", Body: "body of ok"},
+		{Kind: sendFailure, ID: "late", ReceiptHandle: "receipt-late", Body: "body of late"},
+	}
+	source := aws.String("https://sqs.us-east-1.amazonaws.com/123456789012/source")
+	dest := aws.String("https://sqs.us-east-1.amazonaws.com/123456789012/dest")
+
+	duplicates := ReplayErrors(context.Background(), svc, svc, NewLogger(true, false), records, source, dest, true, nil)
+
+	if duplicates != 1 {
+		t.Errorf("got %d duplicates, want the resend whose handle had expired", duplicates)
+	}
+	if len(svc.sent) != 2 || svc.onSource("ok") || !svc.onSource("late") {
+		t.Errorf("expected both resent and only ok removed, sent %d, deleted %v", len(svc.sent), svc.deleted)
+	}
+}
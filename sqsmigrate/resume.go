@@ -0,0 +1,48 @@
+package sqsmigrate
+
+import (
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+)
+
+// SkipUntil holds back every message received before the one with -skip-until-id, a
+// best-effort way to pick an ordered migration up where it stopped.  That message and
+// every one received after it pass, across all workers and sources.  A nil *SkipUntil
+// holds back nothing.
+type SkipUntil struct {
+	ID      string
+	reached int32
+}
+
+// passes reports whether the message with this MessageId comes after the -skip-until-id.
+func (s *SkipUntil) passes(id string) bool {
+	if s == nil || atomic.LoadInt32(&s.reached) == 1 {
+		return true
+	}
+	if id == s.ID {
+		atomic.StoreInt32(&s.reached, 1)
+		return true
+	}
+	return false
+}
+
+// Found reports whether the -skip-until-id has been received.
+func (s *SkipUntil) Found() bool {
+	return s == nil || atomic.LoadInt32(&s.reached) == 1
+}
+
+// ReadSkipIDs reads a -skip-ids-file of MessageIds, one per line as -ids-file writes them.
+func ReadSkipIDs(path string) (map[string]bool, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ids := map[string]bool{}
+	for _, id := range strings.Split(string(contents), "\n") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids[id] = true
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,187 @@
+package sqsmigrate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// routeUnresolved is the error file code for a message whose -route-by destination
+// couldn't be worked out.
+const routeUnresolved = "RouteUnresolved"
+
+// Router picks a destination for each message with -dest-prefix and -route-by, sending
+// it to the queue named by the prefix followed by a field of its JSON body, or with the
+// route rules of a -filter-config.  Queue URLs are looked up as each name is first seen
+// and cached, failures included.
+type Router struct {
+	ctx     context.Context
+	destSvc *sqs.Client
+	prefix  string
+	// path is nil without -route-by, when only route rules assign destinations.
+	path []interface{}
+	// sources are refused as destinations, so a message can't be routed back to the
+	// queue it is being migrated from.
+	sources map[string]bool
+
+	mu     sync.Mutex
+	queues map[string]resolvedQueue
+	// assigned holds the destination of each staged message until it is sent.
+	assigned map[string]*string
+}
+
+type resolvedQueue struct {
+	url *string
+	err error
+}
+
+// destinationGroup is the part of a batch going to one destination queue.
+type destinationGroup struct {
+	queueURL *string
+	entries  []*types.SendMessageBatchRequestEntry
+}
+
+func NewRouter(ctx context.Context, destSvc *sqs.Client, prefix, routeBy string, sourceQueueURLs []*string) (*Router, error) {
+	var path []interface{}
+	if routeBy != "" {
+		var err error
+		if path, err = ParseJSONPath(routeBy); err != nil {
+			return nil, err
+		}
+	}
+	sources := map[string]bool{}
+	for _, sourceQueueURL := range sourceQueueURLs {
+		sources[aws.ToString(sourceQueueURL)] = true
+	}
+	return &Router{
+		ctx:      ctx,
+		destSvc:  destSvc,
+		prefix:   prefix,
+		path:     path,
+		sources:  sources,
+		queues:   map[string]resolvedQueue{},
+		assigned: map[string]*string{},
+	}, nil
+}
+
+// route works out the destination of a message from its body and holds on to it until
+// the message is sent.
+func (r *Router) route(id, body string) error {
+	if r.path == nil {
+		return nil
+	}
+	var doc interface{}
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		return fmt.Errorf("body is not JSON")
+	}
+	value, ok := lookupJSONPath(doc, r.path)
+	if !ok {
+		return fmt.Errorf("body has no -route-by field")
+	}
+	field, ok := jsonScalar(value)
+	if !ok {
+		return fmt.Errorf("-route-by field is %v rather than a string or number", value)
+	}
+	return r.assign(id, r.prefix+field)
+}
+
+// assign sends a message to the named queue, such as that of a -filter-config route
+// rule.
+func (r *Router) assign(id, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	queueURL, err := r.resolve(name)
+	if err != nil {
+		return err
+	}
+	r.assigned[id] = queueURL
+	return nil
+}
+
+// ResolveAll looks up the named queues up front, so a typo in a -filter-config route rule
+// fails the run before anything is moved.
+func (r *Router) ResolveAll(names []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, name := range names {
+		if _, err := r.resolve(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolve looks up a destination queue, caching the result.  r.mu must be held.
+func (r *Router) resolve(name string) (*string, error) {
+	queue, ok := r.queues[name]
+	if !ok {
+		queue.url, queue.err = ResolveQueueURL(r.ctx, r.destSvc, name)
+		if queue.err == nil && r.sources[aws.ToString(queue.url)] {
+			queue.err = fmt.Errorf("%s is a source queue", name)
+		}
+		r.queues[name] = queue
+	}
+	if queue.err != nil {
+		return nil, fmt.Errorf("queue %s could not be resolved: %s", name, queue.err)
+	}
+	return queue.url, nil
+}
+
+// unroutable leaves a message on the source when its destination couldn't be worked
+// out, recording it to the error file so it can be replayed to a -dest later.
+func (m *migrator) unroutable(message *types.Message, err error) {
+	atomic.AddInt64(&m.unrouted, 1)
+	m.Logger.Errorf("err routing %s - %s", *message.MessageId, err)
+	m.Events.failed(*message.MessageId, m.SourceName, "", routeUnresolved, err.Error())
+	if !m.Execute {
+		return
+	}
+	entry := &types.SendMessageBatchRequestEntry{
+		Id:                message.MessageId,
+		MessageBody:       message.Body,
+		MessageAttributes: message.MessageAttributes,
+	}
+	failure := types.BatchResultErrorEntry{
+		Id:          message.MessageId,
+		Code:        aws.String(routeUnresolved),
+		Message:     aws.String(err.Error()),
+		SenderFault: true,
+	}
+	if err := m.Errs.recordSend(m.SourceQueueURL, entry, message.ReceiptHandle, failure); err != nil {
+		m.Interrupted.fail(m.Logger, err)
+	}
+}
+
+// split groups a batch by destination, in the order each destination first appears.
+// A nil router sends everything to destQueueURL, as does a router for the messages it
+// wasn't given a destination for.
+func (r *Router) split(destQueueURL *string, entries []*types.SendMessageBatchRequestEntry) []destinationGroup {
+	if r == nil {
+		return []destinationGroup{{queueURL: destQueueURL, entries: entries}}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	groups := []destinationGroup{}
+	index := map[string]int{}
+	for _, entry := range entries {
+		queueURL, ok := r.assigned[*entry.Id]
+		if !ok {
+			queueURL = destQueueURL
+		}
+		delete(r.assigned, *entry.Id)
+		i, ok := index[aws.ToString(queueURL)]
+		if !ok {
+			i = len(groups)
+			index[aws.ToString(queueURL)] = i
+			groups = append(groups, destinationGroup{queueURL: queueURL})
+		}
+		groups[i].entries = append(groups[i].entries, entry)
+	}
+	return groups
+}
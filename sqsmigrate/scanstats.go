@@ -0,0 +1,77 @@
+package sqsmigrate
+
+import (
+	"sync"
+	"time"
+)
+
+// scanStats profiles every message a -stats run receives, whether or not it matches:
+// how long ago it was sent and how many of them the filters would migrate.  A nil
+// *scanStats records nothing.
+type scanStats struct {
+	ages *distribution
+
+	// mu guards the counts and the range of send times seen.
+	mu          sync.Mutex
+	scanned     int64
+	matching    int64
+	noTimestamp int64
+	oldest      time.Time
+	newest      time.Time
+}
+
+func newScanStats(enabled bool) *scanStats {
+	if !enabled {
+		return nil
+	}
+	return &scanStats{ages: newAgeDistribution()}
+}
+
+// observe records a message received, sent age ago, or without a known age.
+func (s *scanStats) observe(now time.Time, age time.Duration, known bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scanned++
+	if !known {
+		s.noTimestamp++
+		return
+	}
+	s.ages.observe(int64(age))
+	sent := now.Add(-age)
+	if s.oldest.IsZero() || sent.Before(s.oldest) {
+		s.oldest = sent
+	}
+	if sent.After(s.newest) {
+		s.newest = sent
+	}
+}
+
+// matched records n of the messages received passing the filters.
+func (s *scanStats) matched(n int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.matching += int64(n)
+}
+
+// fill copies the stats into a summary, with the oldest and newest send times seen when
+// verbose.
+func (s *scanStats) fill(summary *Summary, verbose bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summary.Scanned, summary.Matching, summary.NoTimestamp = s.scanned, s.matching, s.noTimestamp
+	summary.ScannedAges = s.ages.values()
+	summary.scannedAges = s.ages
+	if verbose && !s.oldest.IsZero() {
+		oldest, newest := s.oldest, s.newest
+		summary.OldestSent, summary.NewestSent = &oldest, &newest
+	}
+}
@@ -0,0 +1,155 @@
+package sqsmigrate
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// scriptHeader opens every -emit-script.  Receipt handles stop working once a message is
+// received again, so the deletes are only good while the dry run's receives are.
+const scriptHeader = `#!/bin/sh
+# The aws sqs commands a migration with -execute would have run, as found by a dry run.
+# Each delete uses the receipt handle the dry run received, which is no longer valid
+# once the message has been received again by anything else.
+set -e
+`
+
+// ScriptFile writes the -emit-script, the send and delete batches a dry run would have
+// made as aws CLI commands.  A nil *ScriptFile discards everything.
+type ScriptFile struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *bufio.Writer
+}
+
+// scriptSendEntry and scriptAttribute are the shapes the aws CLI takes for the entries
+// of send-message-batch, leaving out everything that isn't set.
+type scriptSendEntry struct {
+	Id                     string
+	MessageBody            string
+	DelaySeconds           int32                      `json:",omitempty"`
+	MessageAttributes      map[string]scriptAttribute `json:",omitempty"`
+	MessageGroupId         string                     `json:",omitempty"`
+	MessageDeduplicationId string                     `json:",omitempty"`
+}
+
+type scriptAttribute struct {
+	DataType    string
+	StringValue string `json:",omitempty"`
+	BinaryValue []byte `json:",omitempty"`
+}
+
+type scriptDeleteEntry struct {
+	Id            string
+	ReceiptHandle string
+}
+
+func CreateScriptFile(path string) (*ScriptFile, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return nil, err
+	}
+	s := &ScriptFile{f: f, w: bufio.NewWriter(f)}
+	if _, err := s.w.WriteString(scriptHeader); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// sendBatch writes the send of a batch of staged messages, followed by their removal from
+// the source unless leaveOnSource is set.
+func (s *ScriptFile) sendBatch(sourceQueueURL, destQueueURL *string, entries []*types.SendMessageBatchRequestEntry, idsToReceipts map[string]*string, leaveOnSource bool) error {
+	if s == nil {
+		return nil
+	}
+	sends := []scriptSendEntry{}
+	deletes := []types.DeleteMessageBatchRequestEntry{}
+	for _, entry := range entries {
+		send := scriptSendEntry{
+			Id:                     aws.ToString(entry.Id),
+			MessageBody:            aws.ToString(entry.MessageBody),
+			DelaySeconds:           entry.DelaySeconds,
+			MessageGroupId:         aws.ToString(entry.MessageGroupId),
+			MessageDeduplicationId: aws.ToString(entry.MessageDeduplicationId),
+		}
+		for name, value := range entry.MessageAttributes {
+			if send.MessageAttributes == nil {
+				send.MessageAttributes = map[string]scriptAttribute{}
+			}
+			send.MessageAttributes[name] = scriptAttribute{
+				DataType:    aws.ToString(value.DataType),
+				StringValue: aws.ToString(value.StringValue),
+				BinaryValue: value.BinaryValue,
+			}
+		}
+		sends = append(sends, send)
+		deletes = append(deletes, types.DeleteMessageBatchRequestEntry{
+			Id:            entry.Id,
+			ReceiptHandle: idsToReceipts[aws.ToString(entry.Id)],
+		})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.command("send-message-batch", destQueueURL, sends); err != nil {
+		return err
+	}
+	if leaveOnSource {
+		return nil
+	}
+	return s.deletes(sourceQueueURL, deletes)
+}
+
+// deleteBatch writes the removal of messages from the source without sending them.
+func (s *ScriptFile) deleteBatch(sourceQueueURL *string, entries []types.DeleteMessageBatchRequestEntry) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deletes(sourceQueueURL, entries)
+}
+
+func (s *ScriptFile) deletes(sourceQueueURL *string, entries []types.DeleteMessageBatchRequestEntry) error {
+	deletes := []scriptDeleteEntry{}
+	for _, entry := range entries {
+		deletes = append(deletes, scriptDeleteEntry{
+			Id:            aws.ToString(entry.Id),
+			ReceiptHandle: aws.ToString(entry.ReceiptHandle),
+		})
+	}
+	return s.command("delete-message-batch", sourceQueueURL, deletes)
+}
+
+func (s *ScriptFile) command(name string, queueURL *string, entries interface{}) error {
+	encoded, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.w, "aws sqs %s --queue-url %s --entries %s\n", name, shellQuote(aws.ToString(queueURL)), shellQuote(string(encoded)))
+	return err
+}
+
+func (s *ScriptFile) Close() error {
+	if s == nil {
+		return nil
+	}
+	if err := s.w.Flush(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
+
+// shellQuote single quotes a value for a POSIX shell.
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,93 @@
+package sqsmigrate
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+)
+
+// sortForSending orders a received batch the way it was sent to the source for
+// -fifo-sequential: by SequenceNumber on a FIFO source, otherwise by SentTimestamp.  A
+// receive can hand back messages from several groups interleaved, so the sort is
+// stable to keep the order SQS returned them in for anything it can't tell apart.
+func sortForSending(messages []types.Message) {
+	sort.SliceStable(messages, func(i, j int) bool {
+		a := messages[i].Attributes[string(types.MessageSystemAttributeNameSequenceNumber)]
+		b := messages[j].Attributes[string(types.MessageSystemAttributeNameSequenceNumber)]
+		if a != "" && b != "" {
+			return lessSequenceNumber(a, b)
+		}
+		return sentTimestamp(&messages[i]) < sentTimestamp(&messages[j])
+	})
+}
+
+// lessSequenceNumber compares two SQS sequence numbers, which are decimal strings too
+// large for an int64.
+func lessSequenceNumber(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// groupHeldBackCode is the failure code of a -fifo-sequential message not sent because
+// an earlier message of its group failed to.
+const groupHeldBackCode = "EarlierMessageFailed"
+
+// sendSequential sends a batch one message at a time with SendMessage for
+// -fifo-sequential, so each message is on the destination before the next is sent.  The
+// results are gathered into a batch response so they are handled just like those of
+// SendMessageBatch, with a rejected message counted as a failed entry rather than ending
+// the run.  Once a message fails the rest of its MessageGroupId are failed without being
+// sent, so none of them overtake it, messages without a group counting as one.  A
+// server fault stops the batch, returning what was sent so far along with the error.
+func (m *migrator) sendSequential(ctx context.Context, destQueueURL *string, entries []types.SendMessageBatchRequestEntry) (*sqs.SendMessageBatchOutput, error) {
+	resp := &sqs.SendMessageBatchOutput{}
+	failedGroups := map[string]bool{}
+	for _, entry := range entries {
+		group := aws.ToString(entry.MessageGroupId)
+		if failedGroups[group] {
+			resp.Failed = append(resp.Failed, types.BatchResultErrorEntry{
+				Id:      entry.Id,
+				Code:    aws.String(groupHeldBackCode),
+				Message: aws.String("held back as an earlier message of its group failed to send"),
+			})
+			continue
+		}
+		sent, err := m.DestSvc.SendMessage(ctx, &sqs.SendMessageInput{
+			QueueUrl:               destQueueURL,
+			MessageBody:            entry.MessageBody,
+			MessageAttributes:      entry.MessageAttributes,
+			MessageGroupId:         entry.MessageGroupId,
+			MessageDeduplicationId: entry.MessageDeduplicationId,
+		})
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorFault() != smithy.FaultServer {
+			resp.Failed = append(resp.Failed, types.BatchResultErrorEntry{
+				Id:          entry.Id,
+				Code:        aws.String(apiErr.ErrorCode()),
+				Message:     aws.String(apiErr.ErrorMessage()),
+				SenderFault: true,
+			})
+			failedGroups[group] = true
+			continue
+		}
+		if err != nil {
+			return resp, err
+		}
+		resp.Successful = append(resp.Successful, types.SendMessageBatchResultEntry{
+			Id:                           entry.Id,
+			MessageId:                    sent.MessageId,
+			MD5OfMessageBody:             sent.MD5OfMessageBody,
+			MD5OfMessageAttributes:       sent.MD5OfMessageAttributes,
+			MD5OfMessageSystemAttributes: sent.MD5OfMessageSystemAttributes,
+			SequenceNumber:               sent.SequenceNumber,
+		})
+	}
+	return resp, nil
+}
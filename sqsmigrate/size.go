@@ -0,0 +1,59 @@
+package sqsmigrate
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// MaxMessageBytes is the largest message, body and attributes together, SQS accepts.
+const MaxMessageBytes = 256 << 10
+
+// Policies for messages that would be over MaxMessageBytes once staged.
+const (
+	OversizeSkip     = "skip"
+	OversizeTruncate = "truncate"
+)
+
+// fitMessage makes sure a staged entry is within the SQS size limit.  With -on-oversize
+// truncate the attributes the tool added on top of the original message are dropped
+// first, the original body and attributes are never touched, and neither is the
+// Content-Encoding of a compressed body.  It reports whether the entry now fits.
+func fitMessage(entry *types.SendMessageBatchRequestEntry, original *types.Message, policy string) bool {
+	if messageSize(*entry.MessageBody, entry.MessageAttributes, true) <= MaxMessageBytes {
+		return true
+	}
+	if policy != OversizeTruncate {
+		return false
+	}
+	for name := range entry.MessageAttributes {
+		if _, ok := original.MessageAttributes[name]; !ok && name != contentEncodingAttribute {
+			delete(entry.MessageAttributes, name)
+		}
+	}
+	return messageSize(*entry.MessageBody, entry.MessageAttributes, true) <= MaxMessageBytes
+}
+
+// messageSize is the size SQS counts against its limits: the body plus, when
+// includeAttributes is set, each message attribute's name, type and value.
+func messageSize(body string, attributes map[string]types.MessageAttributeValue, includeAttributes bool) int {
+	size := len(body)
+	if includeAttributes {
+		size += attributesSize(attributes)
+	}
+	return size
+}
+
+func attributesSize(attributes map[string]types.MessageAttributeValue) int {
+	size := 0
+	for name, value := range attributes {
+		size += len(name) + len(aws.ToString(value.DataType)) + len(aws.ToString(value.StringValue)) + len(value.BinaryValue)
+	}
+	return size
+}
+
+// newSizeDistribution buckets staged messages up to the 256KB SQS maximum.
+func newSizeDistribution() *distribution {
+	return newDistribution(
+		[]string{"<1KB", "<16KB", "<64KB", "<128KB", "<=256KB"},
+		[]int64{1 << 10, 16 << 10, 64 << 10, 128 << 10})
+}
@@ -0,0 +1,135 @@
+package sqsmigrate
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// snsEnvelope is an SNS notification as delivered to a subscribed queue without raw
+// message delivery.  Only the fields needed to recognise and unwrap it are decoded, the
+// rest are kept as they were for re-wrapping.
+type snsEnvelope struct {
+	fields  map[string]json.RawMessage
+	message string
+}
+
+// parseSNSEnvelope recognises an SNS notification body.  Anything that isn't a JSON
+// object with a Notification type, a TopicArn and a string Message is reported as not
+// wrapped and handled as a plain body.
+func parseSNSEnvelope(body string) (*snsEnvelope, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(body), &fields); err != nil {
+		return nil, false
+	}
+	var kind, topic, message string
+	if json.Unmarshal(fields["Type"], &kind) != nil || kind != "Notification" {
+		return nil, false
+	}
+	if json.Unmarshal(fields["TopicArn"], &topic) != nil || topic == "" {
+		return nil, false
+	}
+	if json.Unmarshal(fields["Message"], &message) != nil {
+		return nil, false
+	}
+	return &snsEnvelope{fields: fields, message: message}, true
+}
+
+// rewrap returns the envelope with its Message replaced.  The SNS signature covers the
+// original message, so a re-wrapped notification whose message changed no longer
+// verifies.
+func (e *snsEnvelope) rewrap(message string) (string, error) {
+	encoded, err := json.Marshal(message)
+	if err != nil {
+		return "", err
+	}
+	fields := map[string]json.RawMessage{}
+	for name, value := range e.fields {
+		fields[name] = value
+	}
+	fields["Message"] = encoded
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// snsAttribute is how SNS encodes a message attribute inside a notification.
+type snsAttribute struct {
+	Type  string
+	Value string
+}
+
+// attributes converts the MessageAttributes of the notification into SQS message
+// attributes.  Binary values are base64 encoded in the envelope, and String.Array keeps
+// its JSON array as a String.Array attribute, which SQS accepts as a custom String type.
+func (e *snsEnvelope) attributes() (map[string]types.MessageAttributeValue, error) {
+	raw, ok := e.fields["MessageAttributes"]
+	if !ok {
+		return nil, nil
+	}
+	var encoded map[string]snsAttribute
+	if err := json.Unmarshal(raw, &encoded); err != nil {
+		return nil, err
+	}
+	attributes := map[string]types.MessageAttributeValue{}
+	for name, attribute := range encoded {
+		switch attribute.Type {
+		case "String", "Number", "String.Array":
+			attributes[name] = types.MessageAttributeValue{
+				DataType:    aws.String(attribute.Type),
+				StringValue: aws.String(attribute.Value),
+			}
+		case "Binary":
+			value, err := base64.StdEncoding.DecodeString(attribute.Value)
+			if err != nil {
+				return nil, fmt.Errorf("attribute %s: %s", name, err)
+			}
+			attributes[name] = types.MessageAttributeValue{
+				DataType:    aws.String(attribute.Type),
+				BinaryValue: value,
+			}
+		default:
+			return nil, fmt.Errorf("attribute %s has unknown type %q", name, attribute.Type)
+		}
+	}
+	return attributes, nil
+}
+
+// promoteAttributes sets the attributes of an SNS notification on its migrated
+// message with -promote-sns-attributes.  Attributes the SQS message already carries win.
+func (m *migrator) promoteAttributes(message *types.Message, envelope *snsEnvelope, entry *types.SendMessageBatchRequestEntry) {
+	if !m.PromoteSNSAttributes || envelope == nil {
+		return
+	}
+	attributes, err := envelope.attributes()
+	if err != nil {
+		m.Logger.Printf("Warning: not promoting the SNS attributes of message %s: %s\n", *message.MessageId, err)
+		return
+	}
+	for name, value := range attributes {
+		if entry.MessageAttributes == nil {
+			entry.MessageAttributes = map[string]types.MessageAttributeValue{}
+		}
+		if _, ok := entry.MessageAttributes[name]; !ok {
+			entry.MessageAttributes[name] = value
+		}
+	}
+}
+
+// payload is the part of a body the filters and transforms look at: the inner message
+// of an SNS notification with -unwrap-sns, otherwise the body itself.
+func (m *migrator) payload(body string) (string, *snsEnvelope) {
+	if !m.UnwrapSNS {
+		return body, nil
+	}
+	envelope, ok := parseSNSEnvelope(body)
+	if !ok {
+		return body, nil
+	}
+	return envelope.message, envelope
+}
@@ -0,0 +1,125 @@
+package sqsmigrate
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// latencyGrowth is the ratio between successive histogram bucket bounds, giving roughly
+// 10% resolution on percentiles from a millisecond up to a couple of minutes.
+const (
+	latencyGrowth  = 1.1
+	latencyBuckets = 130
+)
+
+// latencyHistogram aggregates API call durations into exponential buckets so that
+// memory stays constant however long the run is.
+type latencyHistogram struct {
+	mu      sync.Mutex
+	buckets [latencyBuckets]int64
+	count   int64
+	sum     time.Duration
+	max     time.Duration
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := 0
+	if ms := float64(d) / float64(time.Millisecond); ms > 1 {
+		i = int(math.Ceil(math.Log(ms) / math.Log(latencyGrowth)))
+	}
+	if i >= latencyBuckets {
+		i = latencyBuckets - 1
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buckets[i]++
+	h.count++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// since records the time elapsed since start.
+func (h *latencyHistogram) since(start time.Time) {
+	h.observe(time.Since(start))
+}
+
+// LatencyStats summarises a histogram for reporting.
+type LatencyStats struct {
+	Calls int64   `json:"calls"`
+	AvgMs float64 `json:"avg_ms"`
+	P95Ms float64 `json:"p95_ms"`
+	MaxMs float64 `json:"max_ms"`
+}
+
+func (h *latencyHistogram) stats() LatencyStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count == 0 {
+		return LatencyStats{}
+	}
+	return LatencyStats{
+		Calls: h.count,
+		AvgMs: milliseconds(h.sum / time.Duration(h.count)),
+		P95Ms: milliseconds(h.percentile(0.95)),
+		MaxMs: milliseconds(h.max),
+	}
+}
+
+// percentile returns the upper bound of the bucket holding the pth fraction of
+// observations, capped at the largest duration actually seen.
+func (h *latencyHistogram) percentile(p float64) time.Duration {
+	target := int64(math.Ceil(p * float64(h.count)))
+	var seen int64
+	for i, n := range h.buckets {
+		seen += n
+		if seen >= target {
+			bound := time.Duration(math.Pow(latencyGrowth, float64(i)) * float64(time.Millisecond))
+			if bound > h.max {
+				return h.max
+			}
+			return bound
+		}
+	}
+	return h.max
+}
+
+// quantile returns the pth fraction latency, or false if nothing has been observed yet.
+func (h *latencyHistogram) quantile(p float64) (time.Duration, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count == 0 {
+		return 0, false
+	}
+	return h.percentile(p), true
+}
+
+// merge adds the observations of o to h.
+func (h *latencyHistogram) merge(o *latencyHistogram) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, n := range o.buckets {
+		h.buckets[i] += n
+	}
+	h.count += o.count
+	h.sum += o.sum
+	if o.max > h.max {
+		h.max = o.max
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Microsecond)) / 1000
+}
+
+// apiLatency tracks how long each stage's SQS calls take.
+type apiLatency struct {
+	receive latencyHistogram
+	send    latencyHistogram
+	delete  latencyHistogram
+}
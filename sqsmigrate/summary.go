@@ -0,0 +1,419 @@
+package sqsmigrate
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Formats for the closing summary.
+const (
+	SummaryText       = "text"
+	SummaryJSON       = "json"
+	SummaryPrometheus = "prometheus"
+)
+
+// Summary describes the outcome of a run, both for the closing log lines and the
+// -report-file.
+type Summary struct {
+	Source             string  `json:"source"`
+	Dest               string  `json:"dest,omitempty"`
+	Execute            bool    `json:"execute"`
+	Processed          int     `json:"processed"`
+	Sent               int64   `json:"sent"`
+	SendFailed         int64   `json:"send_failed"`
+	Deleted            int     `json:"deleted"`
+	DeleteFailed       int     `json:"delete_failed"`
+	Failed             int64   `json:"failed"`
+	ExpiredReceipts    int     `json:"expired_receipts"`
+	EmptyBodies        int64   `json:"empty_bodies"`
+	Oversize           int64   `json:"oversize"`
+	SLABreaches        int64   `json:"sla_breaches,omitempty"`
+	Duplicates         int64   `json:"duplicates,omitempty"`
+	DuplicatesSkipped  int64   `json:"duplicates_skipped,omitempty"`
+	Unrouted           int64   `json:"unrouted,omitempty"`
+	ChecksumMismatches int64   `json:"checksum_mismatches,omitempty"`
+	AttrMismatches     int64   `json:"attribute_checksum_mismatches,omitempty"`
+	MovedToFailedDest  int64   `json:"moved_to_failed_dest,omitempty"`
+	MissingDedupKeys   int64   `json:"missing_dedup_keys,omitempty"`
+	TTLExpired         int64   `json:"ttl_expired,omitempty"`
+	Dropped            int64   `json:"dropped,omitempty"`
+	BatchErrors        int64   `json:"batch_errors,omitempty"`
+	HashSelected       int64   `json:"hash_selected,omitempty"`
+	HashSkipped        int64   `json:"hash_skipped,omitempty"`
+	InvalidJSON        int64   `json:"invalid_json,omitempty"`
+	DedupCollisions    int64   `json:"dedup_collisions,omitempty"`
+	RetryRecovered     int64   `json:"retry_recovered,omitempty"`
+	RetryExhausted     int64   `json:"retry_exhausted,omitempty"`
+	BatchTimeouts      int64   `json:"batch_timeouts,omitempty"`
+	APICalls           int64   `json:"api_calls"`
+	StoppedOnAPICalls  bool    `json:"stopped_on_api_calls,omitempty"`
+	StoppedBy          string  `json:"stopped_by,omitempty"`
+	DurationSeconds    float64 `json:"duration_seconds"`
+	// EstimatedRequests and EstimatedCost are what a dry run expects the same migration
+	// to cost with -execute.
+	EstimatedRequests int64   `json:"estimated_requests,omitempty"`
+	EstimatedCost     float64 `json:"estimated_cost_usd,omitempty"`
+	MessagesPerSecond float64 `json:"messages_per_second"`
+
+	Failures map[string]map[string]int64 `json:"failures,omitempty"`
+	Latency  map[string]LatencyStats     `json:"latency"`
+	Sizes    map[string]int64            `json:"sizes"`
+	Ages     map[string]int64            `json:"ages,omitempty"`
+
+	// FailedMessages lists the sends and deletes behind Failed for a JSON summary, up to
+	// maxFailedMessages of them, and Batches the result of each batch of the run, the
+	// same records as the -batch-report, up to maxKeptBatches.
+	FailedMessages        []FailedMessage `json:"failed_messages,omitempty"`
+	FailedMessagesOmitted int64           `json:"failed_messages_omitted,omitempty"`
+	Batches               []*BatchRecord  `json:"batches,omitempty"`
+	BatchesOmitted        int64           `json:"batches_omitted,omitempty"`
+
+	// Scanned and the fields after it profile everything a -stats run received,
+	// Matching counting those the filters would migrate.  The send times seen are only
+	// kept with -verbose.
+	Scanned     int64            `json:"scanned,omitempty"`
+	Matching    int64            `json:"matching,omitempty"`
+	NoTimestamp int64            `json:"no_timestamp,omitempty"`
+	ScannedAges map[string]int64 `json:"scanned_ages,omitempty"`
+	OldestSent  *time.Time       `json:"oldest_sent,omitempty"`
+	NewestSent  *time.Time       `json:"newest_sent,omitempty"`
+
+	// Sources holds the per-source summaries when a run migrates several queues.
+	Sources []Summary `json:"sources,omitempty"`
+
+	latency     *apiLatency
+	sizes       *distribution
+	ages        *distribution
+	scannedAges *distribution
+}
+
+// progress is a summary of the counts that are safe to read while the workers are still
+// running.  It leaves out the processed count, which is only known for the shared
+// budget, as well as removals and latency.
+func (m *migrator) progress() Summary {
+	return Summary{
+		Sent:               atomic.LoadInt64(&m.sent),
+		SendFailed:         atomic.LoadInt64(&m.sendFailed),
+		EmptyBodies:        atomic.LoadInt64(&m.emptyBodies),
+		Oversize:           atomic.LoadInt64(&m.oversize),
+		SLABreaches:        atomic.LoadInt64(&m.slaBreaches),
+		Duplicates:         atomic.LoadInt64(&m.duplicates),
+		DuplicatesSkipped:  atomic.LoadInt64(&m.duplicatesSkipped),
+		Unrouted:           atomic.LoadInt64(&m.unrouted),
+		ChecksumMismatches: atomic.LoadInt64(&m.checksumMismatches),
+		AttrMismatches:     atomic.LoadInt64(&m.attrMismatches),
+		MovedToFailedDest:  atomic.LoadInt64(&m.movedToFailedDest),
+		MissingDedupKeys:   atomic.LoadInt64(&m.missingDedupKeys),
+		TTLExpired:         atomic.LoadInt64(&m.ttlExpired),
+		Dropped:            atomic.LoadInt64(&m.dropped),
+		BatchErrors:        m.batchErrors.failed(),
+		HashSelected:       atomic.LoadInt64(&m.hashSelected),
+		HashSkipped:        atomic.LoadInt64(&m.hashSkipped),
+		InvalidJSON:        atomic.LoadInt64(&m.invalidBodies),
+		DedupCollisions:    atomic.LoadInt64(&m.dedupCollisions),
+		RetryRecovered:     atomic.LoadInt64(&m.retryRecovered),
+		RetryExhausted:     atomic.LoadInt64(&m.retryExhausted),
+		BatchTimeouts:      atomic.LoadInt64(&m.batchTimeouts),
+		Failures:           m.failures.values(),
+	}
+}
+
+func (m *migrator) summary(source, dest string, processed int, elapsed time.Duration) Summary {
+	failed, omitted := m.failures.messages()
+	s := Summary{
+		Source:             source,
+		Dest:               dest,
+		Execute:            m.Execute,
+		Processed:          processed,
+		Sent:               m.sent,
+		SendFailed:         m.sendFailed,
+		Deleted:            m.removals.successful,
+		DeleteFailed:       m.removals.failed,
+		Failed:             m.sendFailed + int64(m.removals.failed),
+		ExpiredReceipts:    m.removals.expired,
+		EmptyBodies:        m.emptyBodies,
+		Oversize:           m.oversize,
+		SLABreaches:        m.slaBreaches,
+		Duplicates:         m.duplicates,
+		DuplicatesSkipped:  m.duplicatesSkipped,
+		Unrouted:           m.unrouted,
+		ChecksumMismatches: m.checksumMismatches,
+		AttrMismatches:     m.attrMismatches,
+		MovedToFailedDest:  m.movedToFailedDest,
+		MissingDedupKeys:   m.missingDedupKeys,
+		TTLExpired:         m.ttlExpired,
+		Dropped:            m.dropped,
+		BatchErrors:        m.batchErrors.failed(),
+		HashSelected:       m.hashSelected,
+		HashSkipped:        m.hashSkipped,
+		InvalidJSON:        m.invalidBodies,
+		DedupCollisions:    m.dedupCollisions,
+		RetryRecovered:     m.retryRecovered,
+		RetryExhausted:     m.retryExhausted,
+		BatchTimeouts:      m.batchTimeouts,
+		Failures:           m.failures.values(),
+		FailedMessages:     failed,
+		APICalls:           m.Calls.Made() - m.callsBefore,
+		StoppedOnAPICalls:  m.stoppedOnCalls == 1,
+		DurationSeconds:    elapsed.Seconds(),
+		Latency: map[string]LatencyStats{
+			"receive": m.latency.receive.stats(),
+			"send":    m.latency.send.stats(),
+			"delete":  m.latency.delete.stats(),
+		},
+		Sizes:                 m.sizes.values(),
+		FailedMessagesOmitted: omitted,
+		latency:               &m.latency,
+		sizes:                 m.sizes,
+	}
+	if m.Histogram {
+		s.Ages = m.ages.values()
+		s.ages = m.ages
+	}
+	m.scanned.fill(&s, m.Verbose)
+	if elapsed > 0 {
+		s.MessagesPerSecond = float64(processed) / elapsed.Seconds()
+	}
+	return s
+}
+
+// CombineSummaries totals the summaries of each source in a run.  The API call count and
+// duration cover the whole run, including resolving the queues, rather than adding up
+// the sources.
+func CombineSummaries(sources []Summary, apiCalls int64, elapsed time.Duration) Summary {
+	if len(sources) == 1 {
+		s := sources[0]
+		s.APICalls = apiCalls
+		return s
+	}
+
+	names := []string{}
+	total := Summary{
+		APICalls:        apiCalls,
+		DurationSeconds: elapsed.Seconds(),
+		Sources:         sources,
+		latency:         &apiLatency{},
+		sizes:           newSizeDistribution(),
+	}
+	for _, s := range sources {
+		names = append(names, s.Source)
+		total.Dest = s.Dest
+		total.Execute = s.Execute
+		total.add(s)
+		total.latency.receive.merge(&s.latency.receive)
+		total.latency.send.merge(&s.latency.send)
+		total.latency.delete.merge(&s.latency.delete)
+		total.sizes.merge(s.sizes)
+		if s.ages != nil {
+			if total.ages == nil {
+				total.ages = newAgeDistribution()
+			}
+			total.ages.merge(s.ages)
+		}
+		if s.scannedAges != nil {
+			if total.scannedAges == nil {
+				total.scannedAges = newAgeDistribution()
+			}
+			total.scannedAges.merge(s.scannedAges)
+		}
+	}
+	total.Source = strings.Join(names, ",")
+	total.Latency = map[string]LatencyStats{
+		"receive": total.latency.receive.stats(),
+		"send":    total.latency.send.stats(),
+		"delete":  total.latency.delete.stats(),
+	}
+	total.Sizes = total.sizes.values()
+	if total.scannedAges != nil {
+		total.ScannedAges = total.scannedAges.values()
+	}
+	if total.ages != nil {
+		total.Ages = total.ages.values()
+	}
+	if elapsed > 0 {
+		total.MessagesPerSecond = float64(total.Processed) / elapsed.Seconds()
+	}
+	return total
+}
+
+// add totals the message counts of another summary into this one.
+func (s *Summary) add(other Summary) {
+	s.Processed += other.Processed
+	s.Sent += other.Sent
+	s.SendFailed += other.SendFailed
+	s.Deleted += other.Deleted
+	s.DeleteFailed += other.DeleteFailed
+	s.Failed += other.Failed
+	s.ExpiredReceipts += other.ExpiredReceipts
+	s.EmptyBodies += other.EmptyBodies
+	s.Oversize += other.Oversize
+	s.SLABreaches += other.SLABreaches
+	s.Duplicates += other.Duplicates
+	s.DuplicatesSkipped += other.DuplicatesSkipped
+	s.Unrouted += other.Unrouted
+	s.ChecksumMismatches += other.ChecksumMismatches
+	s.AttrMismatches += other.AttrMismatches
+	s.MovedToFailedDest += other.MovedToFailedDest
+	s.MissingDedupKeys += other.MissingDedupKeys
+	s.TTLExpired += other.TTLExpired
+	s.Dropped += other.Dropped
+	s.BatchErrors += other.BatchErrors
+	s.HashSelected += other.HashSelected
+	s.HashSkipped += other.HashSkipped
+	s.InvalidJSON += other.InvalidJSON
+	s.DedupCollisions += other.DedupCollisions
+	s.RetryRecovered += other.RetryRecovered
+	s.RetryExhausted += other.RetryExhausted
+	s.BatchTimeouts += other.BatchTimeouts
+	s.Failures = addFailures(s.Failures, other.Failures)
+	s.FailedMessages, s.FailedMessagesOmitted = appendFailed(s.FailedMessages, s.FailedMessagesOmitted+other.FailedMessagesOmitted, other.FailedMessages...)
+	s.StoppedOnAPICalls = s.StoppedOnAPICalls || other.StoppedOnAPICalls
+	s.Scanned += other.Scanned
+	s.Matching += other.Matching
+	s.NoTimestamp += other.NoTimestamp
+	if other.OldestSent != nil && (s.OldestSent == nil || other.OldestSent.Before(*s.OldestSent)) {
+		s.OldestSent = other.OldestSent
+	}
+	if other.NewestSent != nil && (s.NewestSent == nil || other.NewestSent.After(*s.NewestSent)) {
+		s.NewestSent = other.NewestSent
+	}
+}
+
+// EstimateCost fills in the expected requests and cost of running a dry run for real: the
+// receives it took to find the matches, plus a send and a delete for every batch of them.
+// SQS bills each 64KB of a request separately, so large messages cost more than this.
+func (s *Summary) EstimateCost(pricePerMillion float64) {
+	if s.Execute {
+		return
+	}
+	// A -stats run processes everything it scans, but would only migrate what matches.
+	staged := s.Processed
+	if s.ScannedAges != nil {
+		staged = int(s.Matching)
+	}
+	batches := int64((staged + BatchSize - 1) / BatchSize)
+	s.EstimatedRequests = s.Latency["receive"].Calls + 2*batches
+	s.EstimatedCost = float64(s.EstimatedRequests) * pricePerMillion / 1e6
+}
+
+func (s Summary) Print(logger *Logger, emptyBodyPolicy string) {
+	if s.Execute {
+		logger.Println("\nCompleted removal of messages from source queue, resulting in: ")
+		logger.Printf("    Successful Removals: %d\n", s.Deleted)
+		logger.Printf("    Failed Removals: %d\n", s.DeleteFailed)
+		if s.ExpiredReceipts > 0 {
+			logger.Printf("    Expired Receipts: %d (already sent, expect duplicates once they are redelivered)\n", s.ExpiredReceipts)
+		}
+	}
+	if s.EmptyBodies > 0 {
+		logger.Printf("Encountered %d messages with an empty body (%s)\n", s.EmptyBodies, emptyBodyPolicy)
+	}
+
+	if s.Oversize > 0 {
+		logger.Printf("Skipped %d messages that would have been over the SQS size limit\n", s.Oversize)
+	}
+	if s.SLABreaches > 0 {
+		logger.Printf("Migrated %d messages older than the -sla-age\n", s.SLABreaches)
+	}
+	if s.ChecksumMismatches > 0 {
+		logger.Errorf("%d messages were stored with a different body checksum than sent, they were left on the source\n", s.ChecksumMismatches)
+	}
+	if s.AttrMismatches > 0 {
+		logger.Errorf("%d messages were stored with a different message attribute checksum than sent, they were left on the source\n", s.AttrMismatches)
+	}
+	if s.MovedToFailedDest > 0 {
+		logger.Printf("Moved %d messages that kept failing to send to -failed-dest\n", s.MovedToFailedDest)
+	}
+	if s.TTLExpired > 0 {
+		logger.Printf("Found %d messages past the -ttl, removed from the source rather than migrated unless in a dry run or with -no-delete\n", s.TTLExpired)
+	}
+	if s.BatchErrors > 0 {
+		logger.Errorf("%d requests failed as a whole and were skipped over (-continue-on-error)\n", s.BatchErrors)
+	}
+	if len(s.Failures) > 0 {
+		logger.Println("Failures by cause:")
+		for _, line := range failureLines(s.Failures) {
+			logger.Printf("    %s\n", line)
+		}
+	}
+	if total := s.HashSelected + s.HashSkipped; total > 0 {
+		logger.Printf("Selected %d of %d messages (%.1f%%) with -hash-modulo\n", s.HashSelected, total, 100*float64(s.HashSelected)/float64(total))
+	}
+	if s.InvalidJSON > 0 {
+		logger.Printf("Found %d messages whose body is not valid JSON (-require-json)\n", s.InvalidJSON)
+	}
+	if s.DedupCollisions > 0 {
+		logger.Printf("Sent %d messages with the deduplication ID of an earlier one, which the destination may have dropped (-check-dedup-ids)\n", s.DedupCollisions)
+	}
+	if s.RetryRecovered > 0 || s.RetryExhausted > 0 {
+		logger.Printf("Retried failed sends: %d recovered, %d still failed after -max-retries\n", s.RetryRecovered, s.RetryExhausted)
+	}
+	if s.BatchTimeouts > 0 {
+		logger.Printf("Cut off %d send and delete requests that ran past -batch-timeout, their messages were counted as failed\n", s.BatchTimeouts)
+	}
+	if s.Dropped > 0 {
+		logger.Printf("Found %d messages matching -drop-matching, removed from the source rather than migrated unless in a dry run or with -no-delete\n", s.Dropped)
+	}
+	if s.MissingDedupKeys > 0 {
+		logger.Printf("Left %d messages on the source without a -dedup-from field\n", s.MissingDedupKeys)
+	}
+	if s.Unrouted > 0 {
+		logger.Printf("Left %d messages on the source whose -route-by destination couldn't be resolved\n", s.Unrouted)
+	}
+	if s.Duplicates > 0 {
+		logger.Printf("Received %d messages with a MessageId already seen in this run, %d removed without sending again\n", s.Duplicates, s.DuplicatesSkipped)
+	}
+	if s.Processed > 0 {
+		logger.Println("\nMessage sizes:")
+		s.sizes.print(logger)
+	}
+	if s.ages != nil {
+		logger.Println("\nMessage ages:")
+		s.ages.print(logger)
+	}
+	if s.scannedAges != nil {
+		logger.Printf("\nScanned %d messages, %d of which the filters would migrate.  Ages of every message scanned:\n", s.Scanned, s.Matching)
+		s.scannedAges.print(logger)
+		if s.NoTimestamp > 0 {
+			logger.Printf("    %d without a SentTimestamp\n", s.NoTimestamp)
+		}
+		if s.OldestSent != nil {
+			logger.Printf("Oldest sent %s, newest sent %s\n", s.OldestSent.Format(time.RFC3339), s.NewestSent.Format(time.RFC3339))
+		}
+	}
+
+	logger.Println("\nAPI latency:")
+	for _, stage := range []string{"receive", "send", "delete"} {
+		if l := s.Latency[stage]; l.Calls > 0 {
+			logger.Printf("    %-8s calls: %d avg: %.1fms p95: %.1fms max: %.1fms\n", stage, l.Calls, l.AvgMs, l.P95Ms, l.MaxMs)
+		}
+	}
+	logger.Printf("Made %d SQS API calls\n", s.APICalls)
+	if s.EstimatedRequests > 0 {
+		logger.Printf("Executing this migration would take roughly %d requests, an estimated $%.4f\n", s.EstimatedRequests, s.EstimatedCost)
+	}
+	if s.StoppedOnAPICalls {
+		logger.Println("Stopped early after reaching -max-api-calls, the source may still have matching messages")
+	}
+	if s.StoppedBy != "" {
+		logger.Printf("Stopped early by %s, the source may still have matching messages\n", s.StoppedBy)
+	}
+	logger.Printf("Processed %d messages in total in %s (%.1f messages/sec)", s.Processed, time.Duration(s.DurationSeconds*float64(time.Second)).Round(time.Millisecond), s.MessagesPerSecond)
+}
+
+// WriteJSON writes the summary as a single JSON object, the same one as the -report-file.
+func (s Summary) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(s)
+}
+
+func (s Summary) WriteReport(path string) error {
+	report, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(report, '\n'), 0644)
+}
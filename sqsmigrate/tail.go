@@ -0,0 +1,184 @@
+package sqsmigrate
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// maxTailBackoff caps the pause between empty receives with -tail.
+const maxTailBackoff = 30 * time.Second
+
+// Interrupt lets a run finish the batches it is working on when interrupted by SIGINT
+// or SIGTERM, or once its -timeout runs out, instead of dying with messages sent but not
+// yet deleted.  No new batches are received after that, and a long poll in progress is
+// cut short.  A second signal exits straight away.  An error the run can't carry on from
+// stops it the same way, so the deletes of the batches already sent still drain.
+type Interrupt struct {
+	done chan struct{}
+	once sync.Once
+	// reason is what stopped the run, set before done is closed.
+	reason  string
+	signals chan os.Signal
+	// receives is the context of receives, cancelled on stopping.
+	receives context.Context
+	cancel   context.CancelFunc
+
+	// mu guards failure, the first error that stopped the run.
+	mu      sync.Mutex
+	failure error
+}
+
+// newInterrupt is an interrupt that only stops on an error, until WatchInterrupt hands
+// it signals and a -timeout.
+func newInterrupt(ctx context.Context) *Interrupt {
+	i := &Interrupt{done: make(chan struct{})}
+	i.receives, i.cancel = context.WithCancel(ctx)
+	return i
+}
+
+func WatchInterrupt(ctx context.Context, logger *Logger, timeout time.Duration) *Interrupt {
+	signals := make(chan os.Signal, 1)
+	i := newInterrupt(ctx)
+	i.signals = signals
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		reason := "SIGTERM"
+		if <-signals == os.Interrupt {
+			reason = "SIGINT"
+		}
+		signal.Stop(signals)
+		i.stop(reason, func() {
+			logger.Println("Interrupted, finishing the current batches.  Interrupt again to exit straight away")
+		})
+	}()
+	if timeout > 0 {
+		time.AfterFunc(timeout, func() {
+			// Once the run is winding down a signal exits straight away, as after a
+			// first one.
+			signal.Stop(signals)
+			i.stop("-timeout", func() {
+				logger.Printf("Ran for the -timeout of %s, finishing the current batches\n", timeout)
+			})
+		})
+	}
+	return i
+}
+
+// stop ends the run for the given reason, logging with announce unless it was already
+// stopping.
+func (i *Interrupt) stop(reason string, announce func()) {
+	i.once.Do(func() {
+		i.reason = reason
+		announce()
+		close(i.done)
+		i.cancel()
+	})
+}
+
+// fail stops the run over an error it can't carry on from, logging it.  Unlike exiting
+// there and then, the batches in hand finish and those already sent are still deleted
+// from the source, and the error is only reported once the run has wound down.
+func (i *Interrupt) fail(logger *Logger, err error) {
+	if i == nil {
+		logger.Fatal(err)
+	}
+	logger.Errorln(err)
+	i.mu.Lock()
+	if i.failure == nil {
+		i.failure = err
+	}
+	i.mu.Unlock()
+	i.stop("an error", func() {
+		logger.Errorln("Stopping after an error, finishing the current batches")
+	})
+}
+
+// Err returns the error that stopped the run, if any did.
+func (i *Interrupt) Err() error {
+	if i == nil {
+		return nil
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.failure
+}
+
+// Finish marks the receives as over, so a signal or the -timeout arriving afterwards
+// doesn't count as stopping the run early.  Signals get their default handling back.
+func (i *Interrupt) Finish() {
+	i.once.Do(func() {
+		signal.Stop(i.signals)
+		i.cancel()
+	})
+}
+
+// receiveContext returns the context for a receive, which ends once the run is stopping.
+func (i *Interrupt) receiveContext(ctx context.Context) context.Context {
+	if i == nil {
+		return ctx
+	}
+	return i.receives
+}
+
+// StoppedBy returns what stopped the run early, or "" when nothing did.
+func (i *Interrupt) StoppedBy() string {
+	if !i.stopping() {
+		return ""
+	}
+	return i.reason
+}
+
+func (i *Interrupt) stopping() bool {
+	if i == nil {
+		return false
+	}
+	select {
+	case <-i.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleep pauses for d, returning early once interrupted.
+func (i *Interrupt) sleep(d time.Duration) {
+	if i == nil {
+		time.Sleep(d)
+		return
+	}
+	select {
+	case <-i.done:
+	case <-time.After(d):
+	}
+}
+
+// pollPause is how long to wait after the nth empty receive in a row before polling
+// again: the -poll-delay when given, otherwise a backoff with -tail and nothing while
+// waiting out -max-empty-duration, which relies on the long poll alone.
+func (m *migrator) pollPause(n int64) time.Duration {
+	switch {
+	case m.PollDelay > 0:
+		return m.PollDelay
+	case m.Tail:
+		return tailBackoff(n)
+	default:
+		return 0
+	}
+}
+
+// tailBackoff is how long to wait after the nth empty receive in a row, doubling from a
+// second up to maxTailBackoff.
+func tailBackoff(n int64) time.Duration {
+	backoff := time.Second
+	for ; n > 1 && backoff < maxTailBackoff; n-- {
+		backoff *= 2
+	}
+	if backoff > maxTailBackoff {
+		backoff = maxTailBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,28 @@
+package sqsmigrate
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestInterruptTimeoutAfterFinish(t *testing.T) {
+	i := WatchInterrupt(context.Background(), NewLogger(true, false), 10*time.Millisecond)
+	i.Finish()
+	time.Sleep(30 * time.Millisecond)
+	if reason := i.StoppedBy(); reason != "" {
+		t.Errorf("a run that finished before its -timeout was stopped by %q", reason)
+	}
+}
+
+func TestInterruptTimeoutCancelsReceive(t *testing.T) {
+	i := WatchInterrupt(context.Background(), NewLogger(true, false), 10*time.Millisecond)
+	select {
+	case <-i.receiveContext(context.Background()).Done():
+	case <-time.After(time.Second):
+		t.Fatal("the -timeout left a receive waiting")
+	}
+	if reason := i.StoppedBy(); reason != "-timeout" {
+		t.Errorf("stopped by %q, want -timeout", reason)
+	}
+}
@@ -0,0 +1,86 @@
+package sqsmigrate
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// transformData is what a -transform-template is rendered against for each message.
+type transformData struct {
+	Body      string
+	MessageId string
+	Queue     string
+}
+
+func ParseTransformTemplate(text string) (*template.Template, error) {
+	return template.New("transform").Option("missingkey=error").Parse(text)
+}
+
+// transformBody renders the new body for a message.
+func transformBody(tmpl *template.Template, data transformData) (string, error) {
+	var body strings.Builder
+	if err := tmpl.Execute(&body, data); err != nil {
+		return "", err
+	}
+	return body.String(), nil
+}
+
+// Policies for a message whose transform fails.
+const (
+	TransformErrorSkip = "skip"
+	TransformErrorFail = "error"
+)
+
+// transformed returns the new body for a message from the -transform-template or
+// -transform-exec command.
+func (m *migrator) transformed(data transformData) (string, error) {
+	if m.TransformExec != nil {
+		return m.TransformExec.run(m.ctx, data)
+	}
+	return transformBody(m.Transform, data)
+}
+
+// unifiedDiff renders a line based unified diff between two bodies as a single hunk.
+// Message bodies are small enough that showing every line as context is more useful
+// than splitting them into separate hunks.
+func unifiedDiff(name, before, after string) string {
+	a := strings.Split(before, "\n")
+	b := strings.Split(after, "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- original/%s\n+++ transformed/%s\n@@ -1,%d +1,%d @@\n", name, name, len(a), len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			fmt.Fprintf(&out, " %s\n", a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(&out, "-%s\n", a[i])
+			i++
+		default:
+			fmt.Fprintf(&out, "+%s\n", b[j])
+			j++
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,37 @@
+package sqsmigrate
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// visibilitySafetyFactor is how many times the recent send and delete latency a
+	// received message is kept invisible for, leaving headroom for a slow batch or a
+	// stall waiting on the deleter.
+	visibilitySafetyFactor = 4
+	// MaxVisibilityTimeout is the longest visibility timeout SQS accepts.
+	MaxVisibilityTimeout = 12 * time.Hour
+)
+
+// visibilityTimeout is the visibility timeout for the next receive.  It is fixed unless
+// -max-visibility is above -min-visibility, in which case it tracks the p95 send plus
+// delete latency times visibilitySafetyFactor within those bounds.  Until a batch has
+// been sent and deleted there is nothing to go on, so the maximum is used.
+func (m *migrator) visibilityTimeout() int32 {
+	timeout := m.MaxVisibility
+	if m.MaxVisibility > m.MinVisibility {
+		send, sent := m.latency.send.quantile(0.95)
+		remove, removed := m.latency.delete.quantile(0.95)
+		if sent && removed {
+			timeout = (send + remove) * visibilitySafetyFactor
+			if timeout < m.MinVisibility {
+				timeout = m.MinVisibility
+			}
+			if timeout > m.MaxVisibility {
+				timeout = m.MaxVisibility
+			}
+		}
+	}
+	return int32(math.Ceil(timeout.Seconds()))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/jrnt30/aws-utils/sqsmigrate"
+)
+
+// queueTags is a flag.Value collecting the Key=Value tags of -source-tags or -dest-tags,
+// repeated or comma separated, every one of which the queue has to carry.
+type queueTags map[string]string
+
+func (t queueTags) String() string {
+	pairs := []string{}
+	for key, value := range t {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (t queueTags) Set(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		eq := strings.Index(pair, "=")
+		if eq < 1 {
+			return fmt.Errorf("queue tag %q is not Key=Value", pair)
+		}
+		t[pair[:eq]] = pair[eq+1:]
+	}
+	return nil
+}
+
+// matches reports whether a queue's tags hold every tag of the selector.
+func (t queueTags) matches(tags map[string]string) bool {
+	for key, value := range t {
+		if actual, ok := tags[key]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveTaggedQueue finds the one queue carrying every tag of the selector, listing the
+// queues of the account and reading the tags of each.  No match, or more than one, is
+// an error naming what was found.
+func resolveTaggedQueue(ctx context.Context, sqsSvc *sqs.Client, selector queueTags) (string, error) {
+	queueURLs, err := sqsmigrate.ListQueues(ctx, sqsSvc, "")
+	if err != nil {
+		return "", err
+	}
+	matched := []string{}
+	for _, queueURL := range queueURLs {
+		resp, err := sqsSvc.ListQueueTags(ctx, &sqs.ListQueueTagsInput{QueueUrl: aws.String(queueURL)})
+		if err != nil {
+			return "", fmt.Errorf("reading the tags of %s: %w", path.Base(queueURL), err)
+		}
+		if selector.matches(resp.Tags) {
+			matched = append(matched, queueURL)
+		}
+	}
+	switch len(matched) {
+	case 0:
+		return "", fmt.Errorf("no queue is tagged %s", selector)
+	case 1:
+		return matched[0], nil
+	}
+	names := []string{}
+	for _, queueURL := range matched {
+		names = append(names, path.Base(queueURL))
+	}
+	return "", fmt.Errorf("%d queues are tagged %s, add a tag to pick one of %s", len(matched), selector, strings.Join(names, ", "))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/jrnt30/aws-utils/sqsmigrate"
+)
+
+// testSendGroupID is the MessageGroupId of a -test-send message to a FIFO destination
+// when no -group-id is given.
+const testSendGroupID = "aws-utils-test"
+
+// testSendReceives is how many receives -test-delete makes looking for the test message
+// among whatever else is on the destination.
+const testSendReceives = 5
+
+// testSend sends one synthetic message to the destination for -test-send, proving it is
+// reachable and writable with the destination's credentials.  With remove the message
+// is received back and deleted, releasing anything else received on the way at once.
+func testSend(ctx context.Context, destSvc *sqs.Client, logger *sqsmigrate.Logger, destQueueURL *string, fifo bool, body string, attributes sqsmigrate.AttributeList, groupID string, remove bool) error {
+	entry := &types.SendMessageBatchRequestEntry{MessageBody: aws.String(body)}
+	attributes.Apply(entry)
+	input := &sqs.SendMessageInput{
+		QueueUrl:          destQueueURL,
+		MessageBody:       entry.MessageBody,
+		MessageAttributes: entry.MessageAttributes,
+	}
+	if fifo {
+		if groupID == "" {
+			groupID = testSendGroupID
+		}
+		input.MessageGroupId = aws.String(groupID)
+		input.MessageDeduplicationId = aws.String(strconv.FormatInt(time.Now().UnixNano(), 10))
+	}
+	sent, err := destSvc.SendMessage(ctx, input)
+	if err != nil {
+		return err
+	}
+	logger.Printf("Sent test message %s to %s\n", aws.ToString(sent.MessageId), *destQueueURL)
+	if !remove {
+		return nil
+	}
+
+	for i := 0; i < testSendReceives; i++ {
+		resp, err := destSvc.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            destQueueURL,
+			MaxNumberOfMessages: int32(sqsmigrate.BatchSize),
+			VisibilityTimeout:   30,
+			WaitTimeSeconds:     2,
+		})
+		if err != nil {
+			return err
+		}
+		others := []types.ChangeMessageVisibilityBatchRequestEntry{}
+		var found *types.Message
+		for j, message := range resp.Messages {
+			if aws.ToString(message.MessageId) == aws.ToString(sent.MessageId) {
+				found = &resp.Messages[j]
+				continue
+			}
+			others = append(others, types.ChangeMessageVisibilityBatchRequestEntry{
+				Id:                aws.String(strconv.Itoa(j)),
+				ReceiptHandle:     message.ReceiptHandle,
+				VisibilityTimeout: 0,
+			})
+		}
+		if len(others) > 0 {
+			if _, err := destSvc.ChangeMessageVisibilityBatch(ctx, &sqs.ChangeMessageVisibilityBatchInput{
+				QueueUrl: destQueueURL,
+				Entries:  others,
+			}); err != nil {
+				return err
+			}
+		}
+		if found != nil {
+			if _, err := destSvc.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+				QueueUrl:      destQueueURL,
+				ReceiptHandle: found.ReceiptHandle,
+			}); err != nil {
+				return err
+			}
+			logger.Printf("Received and deleted test message %s\n", aws.ToString(sent.MessageId))
+			return nil
+		}
+	}
+	return fmt.Errorf("test message %s was sent but not received back to delete, it is still on the destination", aws.ToString(sent.MessageId))
+}